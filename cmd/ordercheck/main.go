@@ -0,0 +1,53 @@
+// Checks the ordering of categories and tasks and optionally repairs it by rebalancing the rank keys of the affected lists
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"todolist/internal/database"
+)
+
+func main() {
+	repair := flag.Bool("repair", false, "rebalance every list with a duplicate, malformed, too long, missing or stray rank key")
+	flag.Parse()
+
+	database.New()
+
+	issues, err := database.CheckRanks()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "check failed: %v\n", err)
+		os.Exit(2)
+	}
+	for _, issue := range issues {
+		if issue.Repairable() {
+			fmt.Printf("%s %d (%s %d): %s\n", issue.Table, issue.Id, issue.Scope, issue.Scope_id, issue.Problem)
+		} else {
+			fmt.Printf("%s %d: %s\n", issue.Table, issue.Id, issue.Problem)
+		}
+	}
+	fmt.Printf("%d issues found\n", len(issues))
+	if len(issues) == 0 {
+		return
+	}
+	if !*repair {
+		os.Exit(1)
+	}
+
+	count, err := database.RepairRanks(issues)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "repair failed after %d lists: %v\n", count, err)
+		os.Exit(2)
+	}
+	fmt.Printf("%d lists rebalanced\n", count)
+
+	issues, err = database.CheckRanks()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "check failed: %v\n", err)
+		os.Exit(2)
+	}
+	if len(issues) > 0 {
+		fmt.Printf("%d issues need manual repair\n", len(issues))
+		os.Exit(1)
+	}
+}
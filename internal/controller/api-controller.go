@@ -0,0 +1,1221 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"todolist/internal/auth"
+	"todolist/internal/database"
+	"todolist/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIController serves the versioned JSON API under /api/v1. Resources are addressed by their id in the path instead of the request body
+type APIController interface {
+	ListCategories(ctx *gin.Context)
+	CreateCategory(ctx *gin.Context)
+	GetCategory(ctx *gin.Context)
+	PatchCategory(ctx *gin.Context)
+	DeleteCategory(ctx *gin.Context)
+	ListCategoryTasks(ctx *gin.Context)
+	CreateTask(ctx *gin.Context)
+	GetTask(ctx *gin.Context)
+	PatchTask(ctx *gin.Context)
+	DeleteTask(ctx *gin.Context)
+	BulkTasks(ctx *gin.Context)
+	ListTasks(ctx *gin.Context)
+	SearchTasks(ctx *gin.Context)
+	GetDependencies(ctx *gin.Context)
+	AddDependency(ctx *gin.Context)
+	RemoveDependency(ctx *gin.Context)
+	AddAssignee(ctx *gin.Context)
+	RemoveAssignee(ctx *gin.Context)
+	GetTaskHistory(ctx *gin.Context)
+	GetCategoryHistory(ctx *gin.Context)
+	ListTrash(ctx *gin.Context)
+	RestoreTask(ctx *gin.Context)
+	RestoreCategory(ctx *gin.Context)
+	ArchiveTask(ctx *gin.Context)
+	UnarchiveTask(ctx *gin.Context)
+	ArchiveCategory(ctx *gin.Context)
+	UnarchiveCategory(ctx *gin.Context)
+	ListArchivedTasks(ctx *gin.Context)
+	ListArchivedCategories(ctx *gin.Context)
+	GetComments(ctx *gin.Context)
+	CreateComment(ctx *gin.Context)
+	PatchComment(ctx *gin.Context)
+	DeleteComment(ctx *gin.Context)
+	CreateSubtask(ctx *gin.Context)
+	CreateChecklistItem(ctx *gin.Context)
+	PatchChecklistItem(ctx *gin.Context)
+	DeleteChecklistItem(ctx *gin.Context)
+}
+
+type apiController struct {
+	service service.TaskService
+}
+
+func NewAPIController(service service.TaskService) APIController {
+	return &apiController{
+		service: service,
+	}
+}
+
+// Maps the errors of the services to a status code
+func apiErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrNotFound), errors.Is(err, database.ErrNoResult):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, database.ErrNullField), errors.Is(err, service.ErrInvalidOperation), errors.Is(err, service.ErrTooManyItems),
+		errors.Is(err, service.ErrInvalidQuery), errors.Is(err, database.ErrInvalidCursor), errors.Is(err, database.ErrEmptySearch),
+		errors.Is(err, database.ErrInvalidDue), errors.Is(err, database.ErrInvalidTimezone), errors.Is(err, database.ErrInvalidRecurrence),
+		errors.Is(err, service.ErrInvalidScope), errors.Is(err, database.ErrInvalidState), errors.Is(err, database.ErrSubtaskMove),
+		errors.Is(err, database.ErrInvalidDependency), errors.Is(err, database.ErrInvalidLabel),
+		errors.Is(err, database.ErrInvalidAssignee), errors.Is(err, database.ErrInvalidComment), errors.Is(err, service.ErrInvalidAttachment),
+		errors.Is(err, database.ErrArchiveSubtask):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, service.ErrUnsupportedType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, service.ErrInvalidTransition), errors.Is(err, service.ErrBlocked):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, service.ErrNotApplied):
+		return http.StatusFailedDependency
+	case errors.Is(err, database.ErrAlreadyExists), errors.Is(err, database.ErrVersionConflict), errors.Is(err, database.ErrDependencyCycle),
+		errors.Is(err, database.ErrDeletedParent), errors.Is(err, database.ErrArchived):
+		return http.StatusConflict
+	default:
+		log.Println(err)
+		return http.StatusInternalServerError
+	}
+}
+
+// Responds with the status of the error. A blocked task also gets the list of its open blockers
+func abortWithAPIError(ctx *gin.Context, err error) {
+	status := apiErrorStatus(err)
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		message = "Internal server error"
+	}
+	body := gin.H{
+		"error": message,
+	}
+	var blocked *service.BlockedError
+	if errors.As(err, &blocked) {
+		body["blockers"] = blocked.Blockers
+		body["hidden_blockers"] = blocked.Hidden
+	}
+	ctx.AbortWithStatusJSON(status, body)
+}
+
+// Answers a stale write with 412 if the version came from an If-Match header and with 409 if it came from the body
+func abortWithWriteError(ctx *gin.Context, err error, precondition bool) {
+	if precondition && errors.Is(err, database.ErrVersionConflict) {
+		ctx.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	abortWithAPIError(ctx, err)
+}
+
+// The entity tag of a task or category is its version
+func etag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// Sets the ETag header and answers with 304 if it matches If-None-Match. Returns false if the response is already written
+func writeETag(ctx *gin.Context, version int64) bool {
+	tag := etag(version)
+	ctx.Header("ETag", tag)
+	for _, candidate := range strings.Split(ctx.GetHeader("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == tag || candidate == "*" {
+			ctx.AbortWithStatus(http.StatusNotModified)
+			return false
+		}
+	}
+	return true
+}
+
+// Parses the If-Match header into the expected version. "*" and a missing header expect no particular version.
+// Responds with 400 and returns false if the header is not an entity tag of this API
+func ifMatchVersion(ctx *gin.Context) (int64, bool) {
+	value := strings.TrimSpace(ctx.GetHeader("If-Match"))
+	if value == "" || value == "*" {
+		return 0, true
+	}
+	version, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(value, "W/"), `"`), 10, 64)
+	if err != nil || version < 1 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid If-Match header: %s", value),
+		})
+		return 0, false
+	}
+	return version, true
+}
+
+// Parses an optional RFC 3339 query parameter. A missing parameter yields nil
+func queryTime(ctx *gin.Context, key string) (*time.Time, error) {
+	value := ctx.Query(key)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s, expected RFC 3339: %s", key, value)
+	}
+	return &t, nil
+}
+
+// Parses a query parameter holding a list of ids, given repeatedly or separated by commas
+func queryIds(ctx *gin.Context, key string) ([]int64, error) {
+	var ids []int64
+	for _, value := range ctx.QueryArray(key) {
+		for _, part := range strings.Split(value, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+			if err != nil || id < 1 {
+				return nil, fmt.Errorf("invalid %s id: %s", key, part)
+			}
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// Parses the numeric path parameter "id". Responds with 400 and returns false if it is not a valid id
+func pathId(ctx *gin.Context) (int64, bool) {
+	return pathParamId(ctx, "id")
+}
+
+// Parses the id in the path parameter name. Responds with 400 and returns false if it is not a positive number
+func pathParamId(ctx *gin.Context, name string) (int64, bool) {
+	id, err := strconv.ParseInt(ctx.Param(name), 10, 64)
+	if err != nil || id < 1 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid id: %s", ctx.Param(name)),
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+// Reads the page size from the query parameter "limit", 0 if it is absent. Responds with 400 if it is not a number
+func queryLimit(ctx *gin.Context) (int, bool) {
+	if ctx.Query("limit") == "" {
+		return 0, true
+	}
+	limit, err := strconv.Atoi(ctx.Query("limit"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return 0, false
+	}
+	return limit, true
+}
+
+// Returns the username of the authenticated user. Responds with 400 and returns false if the token carries none
+func apiUsername(ctx *gin.Context) (string, bool) {
+	username, err := auth.GetUsernameFromCtx(ctx)
+	if err != nil {
+		log.Println(err)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return "", false
+	}
+	return username, true
+}
+
+// GET /api/v1/categories lists the categories of the workspace given by the query parameter "workspace" (default: personal workspace)
+func (c *apiController) ListCategories(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	workspaceId, err := queryId(ctx, "workspace")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	categories, err := c.service.GetCategories(username, workspaceId)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	if categories == nil {
+		categories = []database.Categories{}
+	}
+	ctx.JSON(http.StatusOK, categories)
+}
+
+// POST /api/v1/categories
+func (c *apiController) CreateCategory(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	var category database.Categories
+	err := ctx.BindJSON(&category)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	category, err = c.service.AddCategory(category, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	category, err = c.service.GetCategory(category.Id, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.Header("Location", fmt.Sprintf("/api/v1/categories/%d", category.Id))
+	ctx.Header("ETag", etag(category.Version))
+	ctx.JSON(http.StatusCreated, category)
+}
+
+// GET /api/v1/categories/{id}
+func (c *apiController) GetCategory(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	category, err := c.service.GetCategory(id, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	if !writeETag(ctx, category.Version) {
+		return
+	}
+	ctx.JSON(http.StatusOK, category)
+}
+
+// PATCH /api/v1/categories/{id} updates the fields present in the body. null clears the name, a new order moves the category.
+// If-Match or a version in the body reject the update if the category changed in the meantime
+func (c *apiController) PatchCategory(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(ctx)
+	if !ok {
+		return
+	}
+	var patch database.CategoryPatch
+	err := ctx.BindJSON(&patch)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if version != 0 {
+		patch.Version = version
+	}
+	category, err := c.service.PatchCategory(id, patch, username)
+	if err != nil {
+		abortWithWriteError(ctx, err, version != 0)
+		return
+	}
+	ctx.Header("ETag", etag(category.Version))
+	ctx.JSON(http.StatusOK, category)
+}
+
+// DELETE /api/v1/categories/{id}. If-Match rejects the deletion if the category changed in the meantime
+func (c *apiController) DeleteCategory(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(ctx)
+	if !ok {
+		return
+	}
+	category, err := c.service.GetCategory(id, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	category.Version = version
+	err = c.service.DeleteCategory(category, username)
+	if err != nil {
+		abortWithWriteError(ctx, err, true)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// GET /api/v1/categories/{id}/tasks
+func (c *apiController) ListCategoryTasks(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	tasks, err := c.service.GetCategoryTasks(id, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, tasks)
+}
+
+// POST /api/v1/categories/{id}/tasks
+func (c *apiController) CreateTask(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	_, err := c.service.GetCategory(id, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	var task database.Task
+	err = ctx.BindJSON(&task)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	err = task.Validate()
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	task.Belongs_to = id
+	task, err = c.service.AddTask(task, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	task, err = c.service.GetTask(task.Id, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.Header("Location", fmt.Sprintf("/api/v1/tasks/%d", task.Id))
+	ctx.Header("ETag", etag(task.Version))
+	ctx.JSON(http.StatusCreated, task)
+}
+
+// GET /api/v1/tasks/{id}
+func (c *apiController) GetTask(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	task, err := c.service.GetTask(id, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	if !writeETag(ctx, task.Version) {
+		return
+	}
+	ctx.JSON(http.StatusOK, task)
+}
+
+// PATCH /api/v1/tasks/{id} updates the fields present in the body. null clears title, details and due, a new belongs_to or order moves the task.
+// If-Match or a version in the body reject the update if the task changed in the meantime
+func (c *apiController) PatchTask(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(ctx)
+	if !ok {
+		return
+	}
+	var patch database.TaskPatch
+	err := ctx.BindJSON(&patch)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if version != 0 {
+		patch.Version = version
+	}
+	patch.Scope = ctx.Query("scope")
+	task, err := c.service.PatchTask(id, patch, username)
+	if err != nil {
+		abortWithWriteError(ctx, err, version != 0)
+		return
+	}
+	ctx.Header("ETag", etag(task.Version))
+	ctx.JSON(http.StatusOK, task)
+}
+
+// DELETE /api/v1/tasks/{id}. If-Match rejects the deletion if the task changed in the meantime
+func (c *apiController) DeleteTask(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(ctx)
+	if !ok {
+		return
+	}
+	task, err := c.service.GetTask(id, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	task.Version = version
+	err = c.service.DeleteTask(task, username)
+	if err != nil {
+		abortWithWriteError(ctx, err, true)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// POST /api/v1/tasks/{id}/subtasks adds a subtask to the task at the position given by order among its subtasks
+func (c *apiController) CreateSubtask(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	var task database.Task
+	err := ctx.BindJSON(&task)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	err = task.Validate()
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	task, err = c.service.AddSubtask(id, task, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.Header("Location", fmt.Sprintf("/api/v1/tasks/%d", task.Id))
+	ctx.Header("ETag", etag(task.Version))
+	ctx.JSON(http.StatusCreated, task)
+}
+
+// POST /api/v1/tasks/{id}/checklist adds an item to the checklist of the task at the position given by order
+func (c *apiController) CreateChecklistItem(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	var item database.ChecklistItem
+	err := ctx.BindJSON(&item)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	item.Task_id = id
+	item, err = c.service.AddChecklistItem(item, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.Header("ETag", etag(item.Version))
+	ctx.JSON(http.StatusCreated, item)
+}
+
+// PATCH /api/v1/checklist/{id} updates the fields present in the body. null clears the text, a new order moves the item.
+// If-Match or a version in the body reject the update if the item changed in the meantime
+func (c *apiController) PatchChecklistItem(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(ctx)
+	if !ok {
+		return
+	}
+	var patch database.ChecklistPatch
+	err := ctx.BindJSON(&patch)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if version != 0 {
+		patch.Version = version
+	}
+	item, err := c.service.PatchChecklistItem(id, patch, username)
+	if err != nil {
+		abortWithWriteError(ctx, err, version != 0)
+		return
+	}
+	ctx.Header("ETag", etag(item.Version))
+	ctx.JSON(http.StatusOK, item)
+}
+
+// DELETE /api/v1/checklist/{id}. If-Match rejects the deletion if the item changed in the meantime
+func (c *apiController) DeleteChecklistItem(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(ctx)
+	if !ok {
+		return
+	}
+	err := c.service.DeleteChecklistItem(database.ChecklistItem{Id: id, Version: version}, username)
+	if err != nil {
+		abortWithWriteError(ctx, err, true)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// GET /api/v1/tasks/{id}/dependencies lists the tasks the task waits for (blocked_by) and the tasks waiting for it (blocks)
+func (c *apiController) GetDependencies(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	dependencies, err := c.service.GetDependencies(id, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, dependencies)
+}
+
+// POST /api/v1/tasks/{id}/dependencies with {"blocker_id": ...} makes the task wait for the blocker. A dependency that would close a cycle is rejected with 409
+func (c *apiController) AddDependency(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	var request struct {
+		Blocker_id int64 `json:"blocker_id" binding:"required,min=1"`
+	}
+	err := ctx.ShouldBindJSON(&request)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	err = c.service.AddDependency(id, request.Blocker_id, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	dependencies, err := c.service.GetDependencies(id, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, dependencies)
+}
+
+// DELETE /api/v1/tasks/{id}/dependencies/{blocker_id}
+func (c *apiController) RemoveDependency(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	blockerId, err := strconv.ParseInt(ctx.Param("blocker_id"), 10, 64)
+	if err != nil || blockerId < 1 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid id: %s", ctx.Param("blocker_id")),
+		})
+		return
+	}
+	err = c.service.RemoveDependency(id, blockerId, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// PUT /api/v1/tasks/{id}/assignees/{user_id} assigns the task to a user with access to its category
+func (c *apiController) AddAssignee(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	userId, ok := pathParamId(ctx, "user_id")
+	if !ok {
+		return
+	}
+	err := c.service.AddAssignee(id, userId, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// DELETE /api/v1/tasks/{id}/assignees/{user_id}
+func (c *apiController) RemoveAssignee(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	userId, ok := pathParamId(ctx, "user_id")
+	if !ok {
+		return
+	}
+	err := c.service.RemoveAssignee(id, userId, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// GET /api/v1/tasks/{id}/history lists the audit log of the task, newest first. The next page is requested with the next_cursor of the
+// response, limit defaults to 50
+func (c *apiController) GetTaskHistory(ctx *gin.Context) {
+	c.getHistory(ctx, c.service.GetTaskHistory)
+}
+
+// GET /api/v1/categories/{id}/history lists the audit log of the category like GetTaskHistory
+func (c *apiController) GetCategoryHistory(ctx *gin.Context) {
+	c.getHistory(ctx, c.service.GetCategoryHistory)
+}
+
+func (c *apiController) getHistory(ctx *gin.Context, history func(int64, string, int, string) (database.AuditPage, error)) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	limit, ok := queryLimit(ctx)
+	if !ok {
+		return
+	}
+	page, err := history(id, ctx.Query("cursor"), limit, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+// GET /api/v1/trash lists the deleted categories and tasks of the workspace given by the query parameter "workspace" (default: personal
+// workspace), most recently deleted first
+func (c *apiController) ListTrash(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	workspaceId, err := queryId(ctx, "workspace")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	trash, err := c.service.GetTrash(username, workspaceId)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, trash)
+}
+
+// POST /api/v1/tasks/{id}/restore takes the task out of the trash and puts it back at its old position. Fails with 409 while its
+// category or parent task is deleted
+func (c *apiController) RestoreTask(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	task, err := c.service.RestoreTask(id, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.Header("ETag", etag(task.Version))
+	ctx.JSON(http.StatusOK, task)
+}
+
+// POST /api/v1/categories/{id}/restore takes the category with the tasks deleted with it out of the trash
+func (c *apiController) RestoreCategory(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	category, err := c.service.RestoreCategory(id, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.Header("ETag", etag(category.Version))
+	ctx.JSON(http.StatusOK, category)
+}
+
+// PUT /api/v1/tasks/{id}/archive archives the task with its subtasks. If-Match rejects it if the task changed in the meantime
+func (c *apiController) ArchiveTask(ctx *gin.Context) {
+	c.changeTaskArchive(ctx, c.service.ArchiveTask)
+}
+
+// DELETE /api/v1/tasks/{id}/archive takes the task out of the archive and puts it back at its old position
+func (c *apiController) UnarchiveTask(ctx *gin.Context) {
+	c.changeTaskArchive(ctx, c.service.UnarchiveTask)
+}
+
+func (c *apiController) changeTaskArchive(ctx *gin.Context, change func(int64, int64, string) (database.Task, error)) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(ctx)
+	if !ok {
+		return
+	}
+	task, err := change(id, version, username)
+	if err != nil {
+		abortWithWriteError(ctx, err, version != 0)
+		return
+	}
+	ctx.Header("ETag", etag(task.Version))
+	ctx.JSON(http.StatusOK, task)
+}
+
+// PUT /api/v1/categories/{id}/archive archives the category, which hides its tasks. If-Match rejects it if the category changed in the meantime
+func (c *apiController) ArchiveCategory(ctx *gin.Context) {
+	c.changeCategoryArchive(ctx, c.service.ArchiveCategory)
+}
+
+// DELETE /api/v1/categories/{id}/archive takes the category out of the archive and puts it back at its old position
+func (c *apiController) UnarchiveCategory(ctx *gin.Context) {
+	c.changeCategoryArchive(ctx, c.service.UnarchiveCategory)
+}
+
+func (c *apiController) changeCategoryArchive(ctx *gin.Context, change func(int64, int64, string) (database.Categories, error)) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(ctx)
+	if !ok {
+		return
+	}
+	category, err := change(id, version, username)
+	if err != nil {
+		abortWithWriteError(ctx, err, version != 0)
+		return
+	}
+	ctx.Header("ETag", etag(category.Version))
+	ctx.JSON(http.StatusOK, category)
+}
+
+// GET /api/v1/archive/tasks lists the archived tasks of the workspace given by the query parameter "workspace" (default: personal
+// workspace), most recently archived first. The next page is requested with the next_cursor of the response, limit defaults to 50
+func (c *apiController) ListArchivedTasks(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	workspaceId, err := queryId(ctx, "workspace")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	limit, ok := queryLimit(ctx)
+	if !ok {
+		return
+	}
+	page, err := c.service.GetArchivedTasks(username, workspaceId, ctx.Query("cursor"), limit)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+// GET /api/v1/archive/categories lists the archived categories of a workspace like ListArchivedTasks
+func (c *apiController) ListArchivedCategories(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	workspaceId, err := queryId(ctx, "workspace")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	limit, ok := queryLimit(ctx)
+	if !ok {
+		return
+	}
+	page, err := c.service.GetArchivedCategories(username, workspaceId, ctx.Query("cursor"), limit)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+// GET /api/v1/tasks/{id}/comments lists the comments on the task as threads, oldest first
+func (c *apiController) GetComments(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	comments, err := c.service.GetComments(id, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, comments)
+}
+
+// POST /api/v1/tasks/{id}/comments with {"body": ..., "parent_id": ...} comments on the task or, with parent_id, answers a comment
+func (c *apiController) CreateComment(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	var request struct {
+		Body      string `json:"body"`
+		Parent_id *int64 `json:"parent_id"`
+	}
+	err := ctx.ShouldBindJSON(&request)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	comment, err := c.service.AddComment(database.Comment{Task_id: id, Parent_id: request.Parent_id, Body: request.Body}, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.Header("ETag", etag(comment.Version))
+	ctx.JSON(http.StatusCreated, comment)
+}
+
+// PATCH /api/v1/comments/{id} with {"body": ...} edits a comment of the user. If-Match or a version in the body reject the edit if the
+// comment changed in the meantime
+func (c *apiController) PatchComment(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(ctx)
+	if !ok {
+		return
+	}
+	var request struct {
+		Body    string `json:"body"`
+		Version int64  `json:"version"`
+	}
+	err := ctx.ShouldBindJSON(&request)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if version != 0 {
+		request.Version = version
+	}
+	comment, err := c.service.EditComment(id, request.Body, request.Version, username)
+	if err != nil {
+		abortWithWriteError(ctx, err, version != 0)
+		return
+	}
+	ctx.Header("ETag", etag(comment.Version))
+	ctx.JSON(http.StatusOK, comment)
+}
+
+// DELETE /api/v1/comments/{id} deletes a comment of the user
+func (c *apiController) DeleteComment(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(ctx)
+	if !ok {
+		return
+	}
+	err := c.service.DeleteComment(database.Comment{Id: id, Version: version}, username)
+	if err != nil {
+		abortWithWriteError(ctx, err, true)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// POST /api/v1/tasks/bulk applies {"operations": [{"op": ..., "task_ids": [...]}, ...]} in one transaction. Either all operations are applied
+// and the response is 200, or none is and the status is the one of the first failed item. The body lists the status of every item in both cases
+func (c *apiController) BulkTasks(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	var request struct {
+		Operations []database.BulkOperation `json:"operations" binding:"required,min=1,dive"`
+	}
+	err := ctx.ShouldBindJSON(&request)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	results, err := c.service.BulkTasks(request.Operations, username)
+	if err != nil && !errors.Is(err, service.ErrBulkRejected) {
+		abortWithAPIError(ctx, err)
+		return
+	}
+
+	type item struct {
+		Op              int                `json:"op"`
+		Task_id         int64              `json:"task_id"`
+		Status          int                `json:"status"`
+		Error           string             `json:"error,omitempty"`
+		Blockers        []database.TaskRef `json:"blockers,omitempty"`
+		Hidden_blockers int                `json:"hidden_blockers,omitempty"`
+	}
+	status := http.StatusOK
+	items := make([]item, len(results))
+	for i, result := range results {
+		items[i] = item{Op: result.Op, Task_id: result.Task_id, Status: http.StatusOK}
+		if result.Err != nil {
+			items[i].Status = apiErrorStatus(result.Err)
+			items[i].Error = result.Err.Error()
+			if items[i].Status == http.StatusInternalServerError {
+				items[i].Error = "Internal server error"
+			}
+			var blocked *service.BlockedError
+			if errors.As(result.Err, &blocked) {
+				items[i].Blockers = blocked.Blockers
+				items[i].Hidden_blockers = blocked.Hidden
+			}
+			if status == http.StatusOK && items[i].Status != http.StatusFailedDependency {
+				status = items[i].Status
+			}
+		}
+	}
+	if err != nil && status == http.StatusOK {
+		status = http.StatusConflict
+	}
+	ctx.JSON(status, gin.H{
+		"applied": err == nil,
+		"results": items,
+	})
+}
+
+// GET /api/v1/tasks lists the tasks the user can access, one page at a time. Filters: workspace, category, state, due_before, due_after, q
+// (text in title or details), label (ids, matched by labels_match any or all) and assignee (only "me"). sort is one of due, created, order,
+// smart and title, a leading "-" sorts descending. The next page is requested with the next_cursor of the response
+func (c *apiController) ListTasks(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	query := database.TaskQuery{
+		Username:   username,
+		Text:       ctx.Query("q"),
+		Sort:       strings.TrimPrefix(ctx.Query("sort"), "-"),
+		Descending: strings.HasPrefix(ctx.Query("sort"), "-"),
+		Cursor:     ctx.Query("cursor"),
+	}
+	var err error
+	query.Workspace_id, err = queryId(ctx, "workspace")
+	if err == nil {
+		query.Category_id, err = queryId(ctx, "category")
+	}
+	if err == nil {
+		query.Due_before, err = queryTime(ctx, "due_before")
+	}
+	if err == nil {
+		query.Due_after, err = queryTime(ctx, "due_after")
+	}
+	if err == nil && ctx.Query("state") != "" {
+		state := database.TaskState(ctx.Query("state"))
+		if !state.Valid() {
+			err = fmt.Errorf("%w: %q", database.ErrInvalidState, state)
+		}
+		query.State = &state
+	}
+	if err == nil {
+		query.Labels, err = queryIds(ctx, "label")
+		query.Label_match = ctx.Query("labels_match")
+	}
+	if err == nil && ctx.Query("assignee") != "" {
+		query.Assigned_to_me = ctx.Query("assignee") == "me"
+		if !query.Assigned_to_me {
+			err = fmt.Errorf("invalid assignee %q, only me is supported", ctx.Query("assignee"))
+		}
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	query.Limit, ok = queryLimit(ctx)
+	if !ok {
+		return
+	}
+	page, err := c.service.ListTasks(query)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+// GET /api/v1/search?q=... searches the titles and details of all tasks the user can access. workspace restricts the search, limit defaults to 20
+func (c *apiController) SearchTasks(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	workspaceId, err := queryId(ctx, "workspace")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	limit, ok := queryLimit(ctx)
+	if !ok {
+		return
+	}
+	results, err := c.service.SearchTasks(username, ctx.Query("q"), workspaceId, limit)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, results)
+}
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"todolist/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Room for the multipart headers around the file in an upload
+const multipartOverhead = 64 << 10
+
+// AttachmentController serves the attachments of a task under /api/v1/tasks/{id}/attachments and their content under /api/v1/attachments
+type AttachmentController interface {
+	ListAttachments(ctx *gin.Context)
+	UploadAttachment(ctx *gin.Context)
+	DownloadAttachment(ctx *gin.Context)
+	DeleteAttachment(ctx *gin.Context)
+}
+
+type attachmentController struct {
+	service service.AttachmentService
+}
+
+func NewAttachmentController(service service.AttachmentService) AttachmentController {
+	return &attachmentController{
+		service: service,
+	}
+}
+
+// GET /api/v1/tasks/{id}/attachments lists the attachments of the task, oldest first
+func (c *attachmentController) ListAttachments(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	attachments, err := c.service.GetAttachments(id, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, attachments)
+}
+
+// POST /api/v1/tasks/{id}/attachments uploads the multipart/form-data field "file". Too large files are rejected with 413, types that
+// are not allowed with 415
+func (c *attachmentController) UploadAttachment(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, c.service.MaxSize()+multipartOverhead)
+	header, err := ctx.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			abortWithAPIError(ctx, service.ErrTooLarge)
+			return
+		}
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	defer file.Close()
+
+	attachment, err := c.service.AddAttachment(ctx.Request.Context(), id, service.Upload{
+		Filename: header.Filename,
+		Size:     header.Size,
+		Content:  file,
+	}, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, attachment)
+}
+
+// GET /api/v1/attachments/{id} streams the content of the attachment as a download
+func (c *attachmentController) DownloadAttachment(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	attachment, content, err := c.service.OpenAttachment(ctx.Request.Context(), id, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	defer content.Close()
+
+	ctx.DataFromReader(http.StatusOK, attachment.Size, attachment.Content_type, content, map[string]string{
+		"Content-Disposition":    mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}),
+		"X-Content-Type-Options": "nosniff",
+	})
+}
+
+// DELETE /api/v1/attachments/{id}
+func (c *attachmentController) DeleteAttachment(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	err := c.service.DeleteAttachment(id, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"net/http"
+	"todolist/internal/database"
+	"todolist/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LabelController serves the labels under /api/v1/labels and the labels of a task under /api/v1/tasks/{id}/labels
+type LabelController interface {
+	ListLabels(ctx *gin.Context)
+	CreateLabel(ctx *gin.Context)
+	PatchLabel(ctx *gin.Context)
+	DeleteLabel(ctx *gin.Context)
+	AddTaskLabel(ctx *gin.Context)
+	RemoveTaskLabel(ctx *gin.Context)
+}
+
+type labelController struct {
+	service service.LabelService
+}
+
+func NewLabelController(service service.LabelService) LabelController {
+	return &labelController{
+		service: service,
+	}
+}
+
+// GET /api/v1/labels lists the personal labels of the user and the labels of the workspace given by the query parameter "workspace"
+// (default: all workspaces of the user)
+func (c *labelController) ListLabels(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	workspaceId, err := queryId(ctx, "workspace")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	labels, err := c.service.GetLabels(username, workspaceId)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, labels)
+}
+
+// POST /api/v1/labels creates a label of the workspace_id in the body or, if it is null, a personal label
+func (c *labelController) CreateLabel(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	var label database.Label
+	err := ctx.BindJSON(&label)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	label, err = c.service.AddLabel(label, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.Header("ETag", etag(label.Version))
+	ctx.JSON(http.StatusCreated, label)
+}
+
+// PATCH /api/v1/labels/{id} changes the name or the color. If-Match or a version in the body reject the update if the label changed in the meantime
+func (c *labelController) PatchLabel(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(ctx)
+	if !ok {
+		return
+	}
+	var patch database.LabelPatch
+	err := ctx.BindJSON(&patch)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if version != 0 {
+		patch.Version = version
+	}
+	label, err := c.service.PatchLabel(id, patch, username)
+	if err != nil {
+		abortWithWriteError(ctx, err, version != 0)
+		return
+	}
+	ctx.Header("ETag", etag(label.Version))
+	ctx.JSON(http.StatusOK, label)
+}
+
+// DELETE /api/v1/labels/{id} deletes the label and removes it from all tasks
+func (c *labelController) DeleteLabel(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(ctx)
+	if !ok {
+		return
+	}
+	err := c.service.DeleteLabel(database.Label{Id: id, Version: version}, username)
+	if err != nil {
+		abortWithWriteError(ctx, err, true)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// PUT /api/v1/tasks/{id}/labels/{label_id} puts the label on the task
+func (c *labelController) AddTaskLabel(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	labelId, ok := pathParamId(ctx, "label_id")
+	if !ok {
+		return
+	}
+	err := c.service.AddTaskLabel(id, labelId, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// DELETE /api/v1/tasks/{id}/labels/{label_id} removes the label from the task
+func (c *labelController) RemoveTaskLabel(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	id, ok := pathId(ctx)
+	if !ok {
+		return
+	}
+	labelId, ok := pathParamId(ctx, "label_id")
+	if !ok {
+		return
+	}
+	err := c.service.RemoveTaskLabel(id, labelId, username)
+	if err != nil {
+		abortWithAPIError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
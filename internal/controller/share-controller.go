@@ -0,0 +1,192 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"todolist/internal/auth"
+	"todolist/internal/database"
+	"todolist/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ShareController interface {
+	CreateInvitation(ctx *gin.Context)
+	GetInvitations(ctx *gin.Context)
+	RevokeInvitation(ctx *gin.Context)
+	ShowInvitation(ctx *gin.Context)
+	RedeemInvitation(ctx *gin.Context)
+}
+
+type shareController struct {
+	service service.ShareService
+}
+
+func NewShareController(service service.ShareService) ShareController {
+	return &shareController{
+		service: service,
+	}
+}
+
+// Maps the errors of the share service to a status code
+func shareErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrNoSuchInvitation):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrInvalidShareRole), errors.Is(err, service.ErrInvalidExpiry):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrInvitationExpired), errors.Is(err, service.ErrInvitationUsed), errors.Is(err, service.ErrInvitationRevoked):
+		return http.StatusGone
+	default:
+		log.Println(err)
+		return http.StatusInternalServerError
+	}
+}
+
+func abortWithShareError(ctx *gin.Context, err error) {
+	status := shareErrorStatus(err)
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		message = "Internal server error"
+	}
+	ctx.JSON(status, gin.H{
+		"error": message,
+	})
+}
+
+// Creates an invitation link. The body contains category_id, role and optionally expires_in_hours and single_use
+func (c *shareController) CreateInvitation(ctx *gin.Context) {
+	var invitation database.CategoryInvitation
+	err := ctx.BindJSON(&invitation)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	username, err := auth.GetUsernameFromCtx(ctx)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	invitation, err = c.service.CreateInvitation(invitation, username)
+	if err != nil {
+		abortWithShareError(ctx, err)
+		return
+	}
+	invitation.Url = invitationUrl(ctx, invitation.Token)
+	ctx.JSON(http.StatusOK, invitation)
+}
+
+// Lists the outstanding invitations of the category given by the query parameter "category"
+func (c *shareController) GetInvitations(ctx *gin.Context) {
+	username, err := auth.GetUsernameFromCtx(ctx)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	categoryId, err := queryId(ctx, "category")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	invitations, err := c.service.GetInvitations(categoryId, username)
+	if err != nil {
+		abortWithShareError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, invitations)
+}
+
+func (c *shareController) RevokeInvitation(ctx *gin.Context) {
+	var invitation database.CategoryInvitation
+	err := ctx.BindJSON(&invitation)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	username, err := auth.GetUsernameFromCtx(ctx)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	err = c.service.RevokeInvitation(invitation, username)
+	if err != nil {
+		abortWithShareError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusOK)
+}
+
+// Shows the invitation of the token in the path without redeeming it, so that opening or prefetching the link doesn't use it up. Browsers
+// get a page to confirm the invitation with, other clients receive the invitation as JSON
+func (c *shareController) ShowInvitation(ctx *gin.Context) {
+	invitation, err := c.service.GetInvitation(ctx.Param("token"))
+	if ctx.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEHTML {
+		page := gin.H{"Token": ctx.Param("token"), "Invitation": invitation}
+		status := http.StatusOK
+		if err != nil {
+			status = shareErrorStatus(err)
+			page["Error"] = err.Error()
+			if status == http.StatusInternalServerError {
+				page["Error"] = "Internal server error"
+			}
+		}
+		ctx.HTML(status, "invite.html", page)
+		return
+	}
+	if err != nil {
+		abortWithShareError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, invitation)
+}
+
+// Redeems the invitation token in the path. Browsers are sent to their task list afterwards, other clients receive the invitation as JSON
+func (c *shareController) RedeemInvitation(ctx *gin.Context) {
+	username, err := auth.GetUsernameFromCtx(ctx)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	invitation, err := c.service.RedeemInvitation(ctx.Param("token"), username)
+	if err != nil {
+		abortWithShareError(ctx, err)
+		return
+	}
+	if ctx.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEHTML {
+		ctx.Redirect(http.StatusSeeOther, "/tasks/")
+		return
+	}
+	ctx.JSON(http.StatusOK, invitation)
+}
+
+// Builds the absolute link under which an invitation token can be redeemed
+func invitationUrl(ctx *gin.Context, token string) string {
+	scheme := "http"
+	if ctx.Request.TLS != nil || ctx.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + ctx.Request.Host + "/invite/" + token
+}
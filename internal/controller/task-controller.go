@@ -1,8 +1,11 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"todolist/internal/auth"
 	"todolist/internal/database"
 	"todolist/internal/service"
@@ -50,9 +53,16 @@ func (c *taskController) AddTask(ctx *gin.Context) {
 		})
 		return
 	}
+	err = task.Validate()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 	task, err = c.service.AddTask(task, username)
 	if err != nil {
-		ctx.JSON(http.StatusForbidden, gin.H{
+		ctx.JSON(taskErrorStatus(err), gin.H{
 			"error": err.Error(),
 		})
 		return
@@ -78,10 +88,17 @@ func (c *taskController) UpdateTask(ctx *gin.Context) {
 		})
 		return
 	}
+	err = task.Validate()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 
 	task, err = c.service.UpdateTask(task, username)
 	if err != nil {
-		ctx.JSON(http.StatusForbidden, gin.H{
+		ctx.JSON(taskErrorStatus(err), gin.H{
 			"error": err.Error(),
 		})
 		return
@@ -109,7 +126,7 @@ func (c *taskController) DeleteTask(ctx *gin.Context) {
 	}
 	err = c.service.DeleteTask(task, username)
 	if err != nil {
-		ctx.JSON(http.StatusForbidden, gin.H{
+		ctx.JSON(taskErrorStatus(err), gin.H{
 			"error": err.Error(),
 		})
 		return
@@ -137,7 +154,7 @@ func (c *taskController) RelocateTask(ctx *gin.Context) {
 	}
 	task, err = c.service.RelocateTask(task, username)
 	if err != nil {
-		ctx.JSON(http.StatusForbidden, gin.H{
+		ctx.JSON(taskErrorStatus(err), gin.H{
 			"error": err.Error(),
 		})
 		return
@@ -165,7 +182,7 @@ func (c *taskController) AddCategory(ctx *gin.Context) {
 	}
 	category, err = c.service.AddCategory(category, username)
 	if err != nil {
-		ctx.JSON(http.StatusForbidden, gin.H{
+		ctx.JSON(taskErrorStatus(err), gin.H{
 			"error": err.Error(),
 		})
 		return
@@ -193,7 +210,7 @@ func (c *taskController) UpdateCategory(ctx *gin.Context) {
 	}
 	category, err = c.service.UpdateCategory(category, username)
 	if err != nil {
-		ctx.JSON(http.StatusForbidden, gin.H{
+		ctx.JSON(taskErrorStatus(err), gin.H{
 			"error": err.Error(),
 		})
 		return
@@ -221,7 +238,7 @@ func (c *taskController) DeleteCategory(ctx *gin.Context) {
 	}
 	err = c.service.DeleteCategory(category, username)
 	if err != nil {
-		ctx.JSON(http.StatusForbidden, gin.H{
+		ctx.JSON(taskErrorStatus(err), gin.H{
 			"error": err.Error(),
 		})
 		return
@@ -248,14 +265,23 @@ func (c *taskController) RelocateCategory(ctx *gin.Context) {
 		return
 	}
 	err = c.service.RelocateCategory(category, username)
+	if err != nil {
+		ctx.JSON(taskErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 	type getdata struct {
 		Categories []database.Categories `json:"categories"`
 		Tasks      []database.Task       `json:"tasks"`
 	}
 	var data getdata
-	data.Categories, data.Tasks = c.service.GetAllTasksAndCategories(username)
+	if category.Workspace_id == 0 {
+		category.Workspace_id, _ = queryId(ctx, "workspace")
+	}
+	data.Categories, data.Tasks, err = c.service.GetAllTasksAndCategories(username, category.Workspace_id)
 	if err != nil {
-		ctx.JSON(http.StatusForbidden, gin.H{
+		ctx.JSON(taskErrorStatus(err), gin.H{
 			"error": err.Error(),
 		})
 		return
@@ -279,7 +305,47 @@ func (c *taskController) GetAllTasksAndCategories(ctx *gin.Context) {
 	}
 	var data getdata
 
-	data.Categories, data.Tasks = c.service.GetAllTasksAndCategories(username)
+	workspaceId, err := queryId(ctx, "workspace")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	data.Categories, data.Tasks, err = c.service.GetAllTasksAndCategories(username, workspaceId)
+	if err != nil {
+		ctx.JSON(taskErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 
 	ctx.JSON(http.StatusOK, data)
 }
+
+// Parses an optional numeric query parameter. A missing parameter yields 0
+func queryId(ctx *gin.Context, key string) (int64, error) {
+	value := ctx.Query(key)
+	if value == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s id: %s", key, value)
+	}
+	return id, nil
+}
+
+// Stale updates answer with 409 so the frontend can offer to reload, every other error of the services is a denied permission
+func taskErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrVersionConflict):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrInvalidTransition), errors.Is(err, service.ErrBlocked):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, database.ErrSubtaskMove):
+		return http.StatusBadRequest
+	default:
+		return http.StatusForbidden
+	}
+}
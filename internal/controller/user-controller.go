@@ -3,6 +3,7 @@ package controller
 import (
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"time"
 	"todolist/internal/database"
@@ -14,6 +15,8 @@ import (
 type UserController interface {
 	Register(ctx *gin.Context)
 	Login(ctx *gin.Context)
+	GetSettings(ctx *gin.Context)
+	PatchSettings(ctx *gin.Context)
 }
 
 type userController struct {
@@ -100,3 +103,57 @@ func (c userController) Login(ctx *gin.Context) {
 		"jwt": token,
 	})
 }
+
+// GET /api/v1/settings
+func (c userController) GetSettings(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	settings, err := c.service.GetSettings(username)
+	if err != nil {
+		abortWithSettingsError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, settings)
+}
+
+// PATCH /api/v1/settings updates the settings present in the body
+func (c userController) PatchSettings(ctx *gin.Context) {
+	username, ok := apiUsername(ctx)
+	if !ok {
+		return
+	}
+	var patch database.SettingsPatch
+	err := ctx.BindJSON(&patch)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	settings, err := c.service.PatchSettings(username, patch)
+	if err != nil {
+		abortWithSettingsError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, settings)
+}
+
+func abortWithSettingsError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrNoSuchUser):
+		ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+	case errors.Is(err, database.ErrInvalidTimezone), errors.Is(err, service.ErrInvalidSettings):
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+	default:
+		log.Println(err)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": "Internal server error",
+		})
+	}
+}
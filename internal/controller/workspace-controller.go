@@ -0,0 +1,398 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"todolist/internal/auth"
+	"todolist/internal/database"
+	"todolist/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WorkspaceController interface {
+	GetWorkspaces(ctx *gin.Context)
+	AddWorkspace(ctx *gin.Context)
+	UpdateWorkspace(ctx *gin.Context)
+	DeleteWorkspace(ctx *gin.Context)
+	GetMembers(ctx *gin.Context)
+	UpdateMember(ctx *gin.Context)
+	RemoveMember(ctx *gin.Context)
+	InviteUser(ctx *gin.Context)
+	GetInvitations(ctx *gin.Context)
+	AcceptInvitation(ctx *gin.Context)
+	DeclineInvitation(ctx *gin.Context)
+	GetStateTransitions(ctx *gin.Context)
+	UpdateStateTransitions(ctx *gin.Context)
+}
+
+type workspaceController struct {
+	service service.WorkspaceService
+}
+
+func NewWorkspaceController(service service.WorkspaceService) WorkspaceController {
+	return &workspaceController{
+		service: service,
+	}
+}
+
+// Maps the errors of the workspace service to a status code
+func workspaceErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrNoSuchUser), errors.Is(err, service.ErrNoSuchInvitation), errors.Is(err, service.ErrNotAMember), errors.Is(err, database.ErrNoResult):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrInvalidRole), errors.Is(err, database.ErrInvalidState):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrAlreadyMember), errors.Is(err, service.ErrLastOwner), errors.Is(err, service.ErrPersonalWorkspace):
+		return http.StatusConflict
+	default:
+		log.Println(err)
+		return http.StatusInternalServerError
+	}
+}
+
+func abortWithWorkspaceError(ctx *gin.Context, err error) {
+	status := workspaceErrorStatus(err)
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		message = "Internal server error"
+	}
+	ctx.JSON(status, gin.H{
+		"error": message,
+	})
+}
+
+func (c *workspaceController) GetWorkspaces(ctx *gin.Context) {
+	username, err := auth.GetUsernameFromCtx(ctx)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	workspaces, err := c.service.GetWorkspaces(username)
+	if err != nil {
+		abortWithWorkspaceError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, workspaces)
+}
+
+func (c *workspaceController) AddWorkspace(ctx *gin.Context) {
+	var workspace database.Workspace
+	err := ctx.BindJSON(&workspace)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	username, err := auth.GetUsernameFromCtx(ctx)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	workspace, err = c.service.AddWorkspace(workspace, username)
+	if err != nil {
+		abortWithWorkspaceError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, workspace)
+}
+
+func (c *workspaceController) UpdateWorkspace(ctx *gin.Context) {
+	var workspace database.Workspace
+	err := ctx.BindJSON(&workspace)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	username, err := auth.GetUsernameFromCtx(ctx)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	workspace, err = c.service.UpdateWorkspace(workspace, username)
+	if err != nil {
+		abortWithWorkspaceError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, workspace)
+}
+
+func (c *workspaceController) DeleteWorkspace(ctx *gin.Context) {
+	var workspace database.Workspace
+	err := ctx.BindJSON(&workspace)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	username, err := auth.GetUsernameFromCtx(ctx)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	err = c.service.DeleteWorkspace(workspace, username)
+	if err != nil {
+		abortWithWorkspaceError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusOK)
+}
+
+// Lists the members of the workspace given by the query parameter "workspace"
+func (c *workspaceController) GetMembers(ctx *gin.Context) {
+	username, err := auth.GetUsernameFromCtx(ctx)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	workspaceId, err := queryId(ctx, "workspace")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	members, err := c.service.GetMembers(workspaceId, username)
+	if err != nil {
+		abortWithWorkspaceError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, members)
+}
+
+func (c *workspaceController) UpdateMember(ctx *gin.Context) {
+	var member database.WorkspaceMember
+	err := ctx.BindJSON(&member)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	username, err := auth.GetUsernameFromCtx(ctx)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	err = c.service.UpdateMember(member, username)
+	if err != nil {
+		abortWithWorkspaceError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusOK)
+}
+
+func (c *workspaceController) RemoveMember(ctx *gin.Context) {
+	var member database.WorkspaceMember
+	err := ctx.BindJSON(&member)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	username, err := auth.GetUsernameFromCtx(ctx)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	err = c.service.RemoveMember(member, username)
+	if err != nil {
+		abortWithWorkspaceError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusOK)
+}
+
+// Invites a user by username. The body contains workspace_id, username and role
+func (c *workspaceController) InviteUser(ctx *gin.Context) {
+	var invitation database.WorkspaceInvitation
+	err := ctx.BindJSON(&invitation)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	username, err := auth.GetUsernameFromCtx(ctx)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	invitation, err = c.service.InviteUser(invitation, username)
+	if err != nil {
+		abortWithWorkspaceError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, invitation)
+}
+
+// Lists the invitations addressed to the user or, if the query parameter "workspace" is given, the pending invitations of that workspace
+func (c *workspaceController) GetInvitations(ctx *gin.Context) {
+	username, err := auth.GetUsernameFromCtx(ctx)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	workspaceId, err := queryId(ctx, "workspace")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	var invitations []database.WorkspaceInvitation
+	if workspaceId == 0 {
+		invitations, err = c.service.GetInvitations(username)
+	} else {
+		invitations, err = c.service.GetWorkspaceInvitations(workspaceId, username)
+	}
+	if err != nil {
+		abortWithWorkspaceError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, invitations)
+}
+
+func (c *workspaceController) AcceptInvitation(ctx *gin.Context) {
+	var invitation database.WorkspaceInvitation
+	err := ctx.BindJSON(&invitation)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	username, err := auth.GetUsernameFromCtx(ctx)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	err = c.service.AcceptInvitation(invitation, username)
+	if err != nil {
+		abortWithWorkspaceError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusOK)
+}
+
+func (c *workspaceController) DeclineInvitation(ctx *gin.Context) {
+	var invitation database.WorkspaceInvitation
+	err := ctx.BindJSON(&invitation)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	username, err := auth.GetUsernameFromCtx(ctx)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	err = c.service.DeclineInvitation(invitation, username)
+	if err != nil {
+		abortWithWorkspaceError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusOK)
+}
+
+// Returns the allowed state changes of the tasks in the workspace given by the query parameter "workspace"
+func (c *workspaceController) GetStateTransitions(ctx *gin.Context) {
+	username, err := auth.GetUsernameFromCtx(ctx)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	workspaceId, err := queryId(ctx, "workspace")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	transitions, err := c.service.GetStateTransitions(workspaceId, username)
+	if err != nil {
+		abortWithWorkspaceError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, transitions)
+}
+
+func (c *workspaceController) UpdateStateTransitions(ctx *gin.Context) {
+	var transitions database.WorkspaceTransitions
+	err := ctx.BindJSON(&transitions)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	username, err := auth.GetUsernameFromCtx(ctx)
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	transitions, err = c.service.SetStateTransitions(transitions, username)
+	if err != nil {
+		abortWithWorkspaceError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, transitions)
+}
@@ -0,0 +1,391 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	// Returned when an archived task or category is moved
+	ErrArchived error = errors.New("the task or category is archived")
+	// Returned when a subtask is archived or unarchived on its own
+	ErrArchiveSubtask error = errors.New("subtasks are archived together with their parent task")
+)
+
+// The longest time in days a user can let done tasks wait before they are archived
+const MaxAutoArchiveDays = 3650
+
+// One page of archived categories, most recently archived first. Next_cursor is empty on the last page
+type CategoryPage struct {
+	Categories  []Categories `json:"categories"`
+	Next_cursor string       `json:"next_cursor,omitempty"`
+}
+
+// The cursor of the archive points behind the last entry of a page
+type archiveCursor struct {
+	Archived_at time.Time `json:"a"`
+	Id          int64     `json:"i"`
+}
+
+func encodeArchiveCursor(archivedAt time.Time, id int64) string {
+	b, _ := json.Marshal(archiveCursor{Archived_at: archivedAt, Id: id})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeArchiveCursor(s string) (archiveCursor, error) {
+	var cursor archiveCursor
+	if s == "" {
+		return cursor, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	err = json.Unmarshal(b, &cursor)
+	if err != nil || cursor.Id < 1 {
+		return cursor, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// Returns ErrArchived if the row of the table is archived
+func checkNotArchived(q querier, table string, id int64) error {
+	var archived bool
+	err := q.QueryRow(fmt.Sprintf(`SELECT archived_at IS NOT NULL FROM "%s" WHERE id = $1`, table), id).Scan(&archived)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoResult
+		}
+		return fmt.Errorf("query error: %v", err)
+	}
+	if archived {
+		return ErrArchived
+	}
+	return nil
+}
+
+// Archives a task with its subtasks and increments its version. The task leaves its list by moving its key to archived_rank, the
+// subtasks keep theirs. Archiving an archived task changes nothing. If version is not 0 the task is only archived if the stored version
+// still matches. Returns ErrArchiveSubtask for a subtask and ErrNoResult if the task does not exist or is deleted
+func ArchiveTask(taskId int64, version int64) error {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	archived, err := archiveTask(tx, taskId, version)
+	if err != nil || !archived {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Archives the task and its subtasks at the same time. Reports whether the task was archived, which it is not if it already was
+func archiveTask(tx *sql.Tx, taskId int64, version int64) (bool, error) {
+	err := lockVersion(tx, "Task", taskId, version)
+	if err != nil {
+		return false, err
+	}
+	var subtask, archived bool
+	query := `SELECT parent_task_id IS NOT NULL, archived_at IS NOT NULL FROM "Task" WHERE id = $1 AND deleted_at IS NULL`
+	err = tx.QueryRow(query, taskId).Scan(&subtask, &archived)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrNoResult
+		}
+		return false, fmt.Errorf("query error: %v", err)
+	}
+	if subtask {
+		return false, ErrArchiveSubtask
+	}
+	if archived {
+		return false, nil
+	}
+
+	query = `
+	WITH root AS (
+		UPDATE "Task" t SET archived_at = now(), archived_rank = (SELECT a.rank FROM "CategoryTasks" a WHERE a.task_id = t.id),
+			version = version + 1
+		WHERE t.id = $1
+		RETURNING t.id
+	)
+	UPDATE "CategoryTasks" SET rank = NULL WHERE task_id IN (SELECT id FROM root)`
+	err = expectOneRow(tx.Exec(query, taskId))
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.Exec(subtreeQuery+` UPDATE "Task" SET archived_at = now() WHERE id IN (SELECT id FROM tree) AND archived_at IS NULL`, taskId)
+	if err != nil {
+		return false, fmt.Errorf("failed to archive subtasks: %v", err)
+	}
+	return true, nil
+}
+
+// Takes a task with its subtasks out of the archive and puts it back at its old position in its category. Unarchiving a task that is
+// not archived changes nothing. If version is not 0 the task is only unarchived if the stored version still matches. Returns
+// ErrArchiveSubtask for a subtask and ErrNoResult if the task does not exist or is deleted
+func UnarchiveTask(taskId int64, version int64) error {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockVersion(tx, "Task", taskId, version)
+	if err != nil {
+		return err
+	}
+	var subtask bool
+	var archivedRank sql.NullString
+	var categoryId int64
+	query := `
+	SELECT t.parent_task_id IS NOT NULL, t.archived_rank, a.category_id
+	FROM "Task" t JOIN "CategoryTasks" a ON a.task_id = t.id
+	WHERE t.id = $1 AND t.deleted_at IS NULL`
+	err = tx.QueryRow(query, taskId).Scan(&subtask, &archivedRank, &categoryId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoResult
+		}
+		return fmt.Errorf("query error: %v", err)
+	}
+	if subtask {
+		return ErrArchiveSubtask
+	}
+	if !archivedRank.Valid {
+		return nil
+	}
+
+	err = taskList.lock(tx, categoryId)
+	if err != nil {
+		return err
+	}
+	key, err := taskList.restoreKey(tx, categoryId, archivedRank.String)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE "CategoryTasks" SET rank = $1 WHERE task_id = $2`, key, taskId)
+	if err != nil {
+		return fmt.Errorf("failed to unarchive task: %v", err)
+	}
+	_, err = tx.Exec(subtreeQuery+`
+	UPDATE "Task" SET archived_at = NULL
+	WHERE id IN (SELECT id FROM tree) AND archived_at = (SELECT archived_at FROM "Task" WHERE id = $1)`, taskId)
+	if err != nil {
+		return fmt.Errorf("failed to unarchive subtasks: %v", err)
+	}
+	_, err = tx.Exec(`UPDATE "Task" SET archived_at = NULL, archived_rank = NULL, version = version + 1 WHERE id = $1`, taskId)
+	if err != nil {
+		return fmt.Errorf("failed to unarchive task: %v", err)
+	}
+	return tx.Commit()
+}
+
+// Archives a category and increments its version. Its tasks stay as they are and are hidden together with it. Archiving an archived
+// category changes nothing. If version is not 0 the category is only archived if the stored version still matches. Returns ErrNoResult
+// if the category does not exist or is deleted
+func ArchiveCategory(categoryId int64, version int64) error {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockVersion(tx, "Categories", categoryId, version)
+	if err != nil {
+		return err
+	}
+	query := `
+	UPDATE "Categories" SET archived_at = coalesce(archived_at, now()), archived_rank = coalesce(archived_rank, rank), rank = NULL,
+		version = CASE WHEN archived_at IS NULL THEN version + 1 ELSE version END
+	WHERE id = $1 AND deleted_at IS NULL`
+	err = expectOneRow(tx.Exec(query, categoryId))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Takes a category out of the archive and puts it back at its old position in its workspace. Unarchiving a category that is not
+// archived changes nothing. If version is not 0 the category is only unarchived if the stored version still matches. Returns ErrNoResult
+// if the category does not exist or is deleted
+func UnarchiveCategory(categoryId int64, version int64) error {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockVersion(tx, "Categories", categoryId, version)
+	if err != nil {
+		return err
+	}
+	var workspaceId int64
+	var archivedRank sql.NullString
+	query := `SELECT workspace_id, archived_rank FROM "Categories" WHERE id = $1 AND deleted_at IS NULL`
+	err = tx.QueryRow(query, categoryId).Scan(&workspaceId, &archivedRank)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoResult
+		}
+		return fmt.Errorf("query error: %v", err)
+	}
+	if !archivedRank.Valid {
+		return nil
+	}
+	err = categoryList.lock(tx, workspaceId)
+	if err != nil {
+		return err
+	}
+	key, err := categoryList.restoreKey(tx, workspaceId, archivedRank.String)
+	if err != nil {
+		return err
+	}
+	query = `UPDATE "Categories" SET rank = $1, archived_at = NULL, archived_rank = NULL, version = version + 1 WHERE id = $2`
+	_, err = tx.Exec(query, key, categoryId)
+	if err != nil {
+		return fmt.Errorf("failed to unarchive category: %v", err)
+	}
+	return tx.Commit()
+}
+
+// Returns a page of the archived tasks of the workspace, most recently archived first. Subtasks are archived with their parent and not
+// listed on their own. The cursor is the next_cursor of the previous page or empty for the first page
+func GetArchivedTasks(workspaceId int64, cursor string, limit int) (TaskPage, error) {
+	after, err := decodeArchiveCursor(cursor)
+	if err != nil {
+		return TaskPage{}, err
+	}
+	query := `
+	SELECT ` + taskColumns + `
+	FROM "Task" t JOIN "CategoryTasks" a ON a.task_id = t.id JOIN "Categories" c ON c.id = a.category_id
+	WHERE c.workspace_id = $1 AND c.deleted_at IS NULL AND t.deleted_at IS NULL AND t.archived_at IS NOT NULL AND t.parent_task_id IS NULL
+		AND ($2 = 0 OR (t.archived_at, t.id) < ($3::timestamptz, $2))
+	ORDER BY t.archived_at DESC, t.id DESC
+	LIMIT $4`
+	tasks, err := queryTasks(dbInstance.db, query, workspaceId, after.Id, after.Archived_at, limit+1)
+	if err != nil {
+		return TaskPage{}, err
+	}
+	page := TaskPage{Tasks: tasks}
+	if len(tasks) > limit {
+		page.Tasks = tasks[:limit]
+		last := page.Tasks[limit-1]
+		page.Next_cursor = encodeArchiveCursor(*last.Archived_at, last.Id)
+	}
+	return page, nil
+}
+
+// Returns a page of the archived categories of the workspace, most recently archived first. The cursor is the next_cursor of the
+// previous page or empty for the first page
+func GetArchivedCategories(workspaceId int64, cursor string, limit int) (CategoryPage, error) {
+	after, err := decodeArchiveCursor(cursor)
+	if err != nil {
+		return CategoryPage{}, err
+	}
+	query := `
+	SELECT ` + categoryColumns + `
+	FROM "Categories" c
+	WHERE c.workspace_id = $1 AND c.deleted_at IS NULL AND c.archived_at IS NOT NULL AND ($2 = 0 OR (c.archived_at, c.id) < ($3::timestamptz, $2))
+	ORDER BY c.archived_at DESC, c.id DESC
+	LIMIT $4`
+	categories, err := queryCategories(dbInstance.db, query, workspaceId, after.Id, after.Archived_at, limit+1)
+	if err != nil {
+		return CategoryPage{}, err
+	}
+	page := CategoryPage{Categories: categories}
+	if len(categories) > limit {
+		page.Categories = categories[:limit]
+		last := page.Categories[limit-1]
+		page.Next_cursor = encodeArchiveCursor(*last.Archived_at, last.Id)
+	}
+	return page, nil
+}
+
+// The tasks t that have been done for longer than the auto-archive days of the users who can see them
+const autoArchivable = `
+	FROM "Task" t JOIN "CategoryTasks" a ON a.task_id = t.id JOIN "Categories" c ON c.id = a.category_id
+		JOIN LATERAL (
+			SELECT bool_and(u.auto_archive_days > 0) AS enabled, max(u.auto_archive_days) AS days
+			FROM "CategoryAccess" ca JOIN "User" u ON u.id = ca.user_id
+			WHERE ca.category_id = c.id
+		) s ON true
+	WHERE t.state = 'done' AND t.archived_at IS NULL AND t.deleted_at IS NULL AND t.parent_task_id IS NULL AND c.deleted_at IS NULL
+		AND s.enabled AND t.completed_at < now() - make_interval(days => s.days)`
+
+// Archives the tasks that have been done for longer than the auto-archive days of the users who can see them, each in its own transaction,
+// and records it in the audit log with AuditSystemActor as actor. As an archived task is gone for all of them, the longest setting counts
+// and a task stays while one of them set 0 days. A task that fails to archive is logged and skipped. Returns the number of archived tasks
+func AutoArchiveTasks() (int, error) {
+	query := `SELECT t.id ` + autoArchivable + ` ORDER BY t.id`
+	ids, err := queryIds(dbInstance.db, query)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for _, id := range ids {
+		archived, err := autoArchiveTask(id)
+		if err != nil {
+			log.Printf("Failed to archive task %d: %v", id, err)
+			continue
+		}
+		if archived {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Archives the task unless it was reopened, completed again, archived or deleted in the meantime. The row is locked before it is checked
+// again, so that it cannot change until it is archived
+func autoArchiveTask(taskId int64) (bool, error) {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`SELECT 1 FROM "Task" WHERE id = $1 FOR UPDATE`, taskId)
+	if err != nil {
+		return false, fmt.Errorf("query error: %v", err)
+	}
+	var due bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 `+autoArchivable+` AND t.id = $1)`, taskId).Scan(&due)
+	if err != nil {
+		return false, fmt.Errorf("query error: %v", err)
+	}
+	if !due {
+		return false, nil
+	}
+	archived, err := archiveTask(tx, taskId, 0)
+	if err != nil {
+		if errors.Is(err, ErrNoResult) {
+			return false, nil
+		}
+		return false, err
+	}
+	if !archived {
+		return false, nil
+	}
+	var archivedAt time.Time
+	err = tx.QueryRow(`SELECT archived_at FROM "Task" WHERE id = $1`, taskId).Scan(&archivedAt)
+	if err != nil {
+		return false, fmt.Errorf("query error: %v", err)
+	}
+	changes, err := AuditDiff(map[string]any{"archived_at": nil}, map[string]any{"archived_at": archivedAt})
+	if err != nil {
+		return false, err
+	}
+	err = addAuditEntry(tx, AuditEntry{Entity: AuditTask, Entity_id: taskId, Action: AuditArchive, Actor: AuditSystemActor, Changes: changes})
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidAssignee error = errors.New("invalid assignee")
+
+// A user a task is assigned to
+type TaskAssignee struct {
+	Id       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+// Assigns the task to the user, who needs access to its category, and records the assignment in the audit log as done by the actor.
+// Assigning a task again changes nothing. Returns ErrNoResult if the task or the user does not exist
+func AddAssignee(taskId int64, userId int64, actor string) error {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var access bool
+	var assignee TaskAssignee
+	query := `
+	SELECT u.id, u.username, EXISTS (
+		SELECT 1 FROM "CategoryTasks" a JOIN "CategoryAccess" ca ON ca.category_id = a.category_id
+		WHERE a.task_id = t.id AND ca.user_id = u.id)
+	FROM "Task" t JOIN "User" u ON u.id = $2
+	WHERE t.id = $1`
+	err = tx.QueryRow(query, taskId, userId).Scan(&assignee.Id, &assignee.Username, &access)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoResult
+		}
+		return fmt.Errorf("query error: %v", err)
+	}
+	if !access {
+		return fmt.Errorf("%w: %s has no access to the category of the task", ErrInvalidAssignee, assignee.Username)
+	}
+
+	result, err := tx.Exec(`INSERT INTO "TaskAssignees" (task_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, taskId, userId)
+	if err != nil {
+		return fmt.Errorf("failed to assign task: %v", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return nil
+	}
+	err = addAssignmentEntry(tx, taskId, actor, AuditAssigned, nil, &assignee)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Removes the user from the assignees of the task and records it in the audit log. Returns ErrNoResult if the task is not
+// assigned to the user
+func RemoveAssignee(taskId int64, userId int64, actor string) error {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var assignee TaskAssignee
+	query := `
+	DELETE FROM "TaskAssignees" ta USING "User" u
+	WHERE ta.task_id = $1 AND ta.user_id = $2 AND u.id = ta.user_id
+	RETURNING u.id, u.username`
+	err = tx.QueryRow(query, taskId, userId).Scan(&assignee.Id, &assignee.Username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoResult
+		}
+		return fmt.Errorf("failed to unassign task: %v", err)
+	}
+	err = addAssignmentEntry(tx, taskId, actor, AuditUnassigned, &assignee, nil)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Records a change of the assignees of the task in the audit log as a change of the field assignee from before to after
+func addAssignmentEntry(tx *sql.Tx, taskId int64, actor string, action string, before *TaskAssignee, after *TaskAssignee) error {
+	changes, err := AuditDiff(map[string]any{"assignee": before}, map[string]any{"assignee": after})
+	if err != nil {
+		return err
+	}
+	return addAuditEntry(tx, AuditEntry{Entity: AuditTask, Entity_id: taskId, Action: action, Actor: actor, Changes: changes})
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// A file attached to a task. Its content is kept in a blob store under Storage_key, the database only holds the metadata
+type Attachment struct {
+	Id           int64     `json:"id"`
+	Task_id      int64     `json:"task_id"`
+	Filename     string    `json:"filename"`
+	Content_type string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Storage_key  string    `json:"-"`
+	Uploaded_by  string    `json:"uploaded_by"`
+	Created_at   time.Time `json:"created_at"`
+}
+
+const attachmentColumns = `f.id, f.task_id, f.filename, f.content_type, f.size, f.storage_key, coalesce(u.username, ''), f.created_at`
+
+func scanAttachment(row scanner) (Attachment, error) {
+	var attachment Attachment
+	err := row.Scan(&attachment.Id, &attachment.Task_id, &attachment.Filename, &attachment.Content_type, &attachment.Size,
+		&attachment.Storage_key, &attachment.Uploaded_by, &attachment.Created_at)
+	return attachment, err
+}
+
+// Returns the attachment or ErrNoResult if it does not exist
+func GetAttachment(attachmentId int64) (Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` FROM "Attachments" f LEFT JOIN "User" u ON u.id = f.user_id WHERE f.id = $1`
+	attachment, err := scanAttachment(dbInstance.db.QueryRow(query, attachmentId))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Attachment{}, ErrNoResult
+		}
+		return Attachment{}, fmt.Errorf("query error: %v", err)
+	}
+	return attachment, nil
+}
+
+// Returns the attachments of the task, oldest first
+func GetAttachments(taskId int64) ([]Attachment, error) {
+	query := `
+	SELECT ` + attachmentColumns + `
+	FROM "Attachments" f LEFT JOIN "User" u ON u.id = f.user_id
+	WHERE f.task_id = $1
+	ORDER BY f.created_at, f.id`
+	rows, err := dbInstance.db.Query(query, taskId)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	attachments := []Attachment{}
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, rows.Err()
+}
+
+// Records an attachment whose content is already stored. Returns ErrNoResult if the task does not exist (anymore)
+func AddAttachment(attachment Attachment) (Attachment, error) {
+	query := `
+	INSERT INTO "Attachments" (task_id, filename, content_type, size, storage_key, user_id)
+	SELECT t.id, $2, $3, $4, $5, (SELECT id FROM "User" WHERE username = $6) FROM "Task" t WHERE t.id = $1
+	RETURNING id`
+	var attachmentId int64
+	err := dbInstance.db.QueryRow(query, attachment.Task_id, attachment.Filename, attachment.Content_type, attachment.Size,
+		attachment.Storage_key, attachment.Uploaded_by).Scan(&attachmentId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Attachment{}, ErrNoResult
+		}
+		return Attachment{}, fmt.Errorf("failed to insert attachment: %v", err)
+	}
+	return GetAttachment(attachmentId)
+}
+
+// Deletes the metadata of the attachment. Like every removed attachment, including those removed with their task, its content is
+// queued for GetOrphanedBlobs
+func DeleteAttachment(attachmentId int64) error {
+	return expectOneRow(dbInstance.db.Exec(`DELETE FROM "Attachments" WHERE id = $1`, attachmentId))
+}
+
+// Returns up to limit keys of blobs whose attachment was deleted
+func GetOrphanedBlobs(limit int) ([]string, error) {
+	return queryStrings(dbInstance.db, `SELECT storage_key FROM "OrphanedBlobs" ORDER BY deleted_at LIMIT $1`, limit)
+}
+
+// Removes the key from the queue once its blob is deleted
+func RemoveOrphanedBlob(key string) error {
+	_, err := dbInstance.db.Exec(`DELETE FROM "OrphanedBlobs" WHERE storage_key = $1`, key)
+	if err != nil {
+		return fmt.Errorf("failed to remove orphaned blob: %v", err)
+	}
+	return nil
+}
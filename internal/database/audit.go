@@ -0,0 +1,177 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Entities recorded in the audit log
+const (
+	AuditTask     = "task"
+	AuditCategory = "category"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditCreate     = "create"
+	AuditUpdate     = "update"
+	AuditDelete     = "delete"
+	AuditMove       = "move"
+	AuditAssigned   = "assigned"
+	AuditUnassigned = "unassigned"
+	AuditRestore    = "restore"
+	AuditArchive    = "archive"
+	AuditUnarchive  = "unarchive"
+)
+
+// The actor of changes made by the application itself, like archiving done tasks. No user can register with this name
+const AuditSystemActor = "system"
+
+// Fields left out of a diff because they change as a side effect of other changes or are recorded on their own
+var auditIgnored = map[string]bool{
+	"id": true, "rank": true, "version": true, "created_at": true, "labels": true, "assignees": true, "comment_count": true,
+	"subtasks": true, "checklist": true, "progress": true,
+}
+
+// The value of a field before and after a change. Before is null for created entities, After for deleted ones
+type AuditChange struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// An entry in the append-only audit log. Actor is the name of the user who made the change, Changes maps the JSON names of the changed
+// fields to their values
+type AuditEntry struct {
+	Id         int64                  `json:"id"`
+	Entity     string                 `json:"entity"`
+	Entity_id  int64                  `json:"entity_id"`
+	Action     string                 `json:"action"`
+	Actor      string                 `json:"actor"`
+	Changes    map[string]AuditChange `json:"changes"`
+	Created_at time.Time              `json:"created_at"`
+}
+
+// One page of the history of an entity, newest first. Next_cursor is empty on the last page
+type AuditPage struct {
+	Entries     []AuditEntry `json:"entries"`
+	Next_cursor string       `json:"next_cursor,omitempty"`
+}
+
+// Compares the JSON representations of two versions of an entity field by field. A nil version has no fields, so the diff of a created
+// entity holds all of its fields that are not null
+func AuditDiff(before any, after any) (map[string]AuditChange, error) {
+	beforeFields, err := auditFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := auditFields(after)
+	if err != nil {
+		return nil, err
+	}
+	changes := map[string]AuditChange{}
+	for _, fields := range []map[string]json.RawMessage{beforeFields, afterFields} {
+		for name := range fields {
+			change := AuditChange{Before: nullJSON(beforeFields[name]), After: nullJSON(afterFields[name])}
+			if !auditIgnored[name] && !bytes.Equal(change.Before, change.After) {
+				changes[name] = change
+			}
+		}
+	}
+	return changes, nil
+}
+
+func auditFields(v any) (map[string]json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
+
+func nullJSON(value json.RawMessage) json.RawMessage {
+	if value == nil {
+		return json.RawMessage("null")
+	}
+	return value
+}
+
+// Appends an entry to the audit log
+func AddAuditEntry(entry AuditEntry) error {
+	return addAuditEntry(dbInstance.db, entry)
+}
+
+func addAuditEntry(q querier, entry AuditEntry) error {
+	changes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO "AuditLog" (entity, entity_id, action, actor, changes) VALUES ($1, $2, $3, $4, $5)`
+	_, err = q.Exec(query, entry.Entity, entry.Entity_id, entry.Action, entry.Actor, string(changes))
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry: %v", err)
+	}
+	return nil
+}
+
+// Returns a page of the history of the entity, newest first. The cursor is the next_cursor of the previous page or empty for the first page
+func GetAuditLog(entity string, entityId int64, cursor string, limit int) (AuditPage, error) {
+	var before int64
+	if cursor != "" {
+		var err error
+		before, err = strconv.ParseInt(cursor, 10, 64)
+		if err != nil || before < 1 {
+			return AuditPage{}, ErrInvalidCursor
+		}
+	}
+	query := `
+	SELECT id, entity, entity_id, action, actor, changes::text, created_at
+	FROM "AuditLog"
+	WHERE entity = $1 AND entity_id = $2 AND ($3 = 0 OR id < $3)
+	ORDER BY id DESC
+	LIMIT $4`
+	rows, err := dbInstance.db.Query(query, entity, entityId, before, limit+1)
+	if err != nil {
+		return AuditPage{}, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	page := AuditPage{Entries: []AuditEntry{}}
+	for rows.Next() {
+		var entry AuditEntry
+		var changes string
+		err = rows.Scan(&entry.Id, &entry.Entity, &entry.Entity_id, &entry.Action, &entry.Actor, &changes, &entry.Created_at)
+		if err != nil {
+			return AuditPage{}, fmt.Errorf("scan error: %v", err)
+		}
+		err = json.Unmarshal([]byte(changes), &entry.Changes)
+		if err != nil {
+			return AuditPage{}, err
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return AuditPage{}, fmt.Errorf("rows error: %v", err)
+	}
+	if len(page.Entries) > limit {
+		page.Entries = page.Entries[:limit]
+		page.Next_cursor = strconv.FormatInt(page.Entries[limit-1].Id, 10)
+	}
+	return page, nil
+}
+
+// Deletes the entries recorded before the given time and returns how many were deleted
+func PurgeAuditLog(before time.Time) (int64, error) {
+	result, err := dbInstance.db.Exec(`DELETE FROM "AuditLog" WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge audit log: %v", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// The operations of a bulk request
+const (
+	BulkComplete = "complete"
+	BulkDelete   = "delete"
+	BulkMove     = "move"
+	BulkSetDue   = "set_due"
+)
+
+// One operation of a bulk request applied to every task in Task_ids. move appends the tasks to the category Category_id,
+// set_due sets the due date fields of all tasks to Due, All_day and Timezone, a null due clears them
+type BulkOperation struct {
+	Op          string     `json:"op" binding:"required"`
+	Task_ids    []int64    `json:"task_ids" binding:"required,min=1"`
+	Category_id int64      `json:"category_id"`
+	Due         *time.Time `json:"due"`
+	All_day     bool       `json:"all_day"`
+	Timezone    string     `json:"timezone"`
+}
+
+// Returned by ApplyBulkOperations for the item that failed. Op is the index of the operation in the request
+type BulkError struct {
+	Op      int
+	Task_id int64
+	Err     error
+}
+
+func (e *BulkError) Error() string {
+	return fmt.Sprintf("operation %d on task %d: %v", e.Op, e.Task_id, e.Err)
+}
+
+func (e *BulkError) Unwrap() error {
+	return e.Err
+}
+
+// Applies all operations in one transaction, in the order of the request. Either all of them are applied or, if one fails, none.
+// Every changed task gets a new version. Permissions must have been checked before
+func ApplyBulkOperations(operations []BulkOperation) error {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+
+	for i, operation := range operations {
+		if operation.Op == BulkMove {
+			err = taskList.lock(tx, operation.Category_id)
+			if err != nil {
+				tx.Rollback()
+				return &BulkError{Op: i, Err: err}
+			}
+		}
+		for _, taskId := range operation.Task_ids {
+			err = applyBulkOperation(tx, operation, taskId)
+			if err != nil {
+				tx.Rollback()
+				return &BulkError{Op: i, Task_id: taskId, Err: err}
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func applyBulkOperation(q querier, operation BulkOperation, taskId int64) error {
+	switch operation.Op {
+	case BulkComplete:
+		return expectOneRow(q.Exec(`UPDATE "Task" SET state = 'done', completed_at = coalesce(completed_at, now()), version = version + 1 WHERE id = $1`, taskId))
+	case BulkDelete:
+		return deleteTask(q, taskId)
+	case BulkMove:
+		// Moved tasks are appended in the order of the request
+		key, err := taskList.keyAt(q, operation.Category_id, math.MaxInt64, taskId)
+		if err != nil {
+			return err
+		}
+		err = moveTaskTree(q, taskId, operation.Category_id, key)
+		if err != nil {
+			return err
+		}
+		return expectOneRow(q.Exec(`UPDATE "Task" SET version = version + 1 WHERE id = $1`, taskId))
+	case BulkSetDue:
+		query := `UPDATE "Task" SET due = $1, all_day = $2, due_timezone = $3, version = version + 1 WHERE id = $4`
+		return expectOneRow(q.Exec(query, operation.Due, operation.All_day, operation.Timezone, taskId))
+	default:
+		return fmt.Errorf("unknown bulk operation %q", operation.Op)
+	}
+}
@@ -0,0 +1,198 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var ErrInvalidComment error = errors.New("invalid comment")
+
+const maxCommentBody = 10000
+
+// A comment on a task. A reply has the comment it answers in Parent_id and is returned in the Replies of that comment. Author is empty if
+// the user was deleted. Edited_at is set once the comment was changed. A deleted comment that still has replies stays in the thread
+// with Deleted set and an empty body
+type Comment struct {
+	Id         int64      `json:"id"`
+	Task_id    int64      `json:"task_id"`
+	Parent_id  *int64     `json:"parent_id"`
+	User_id    int64      `json:"-"`
+	Author     string     `json:"author"`
+	Body       string     `json:"body"`
+	Created_at time.Time  `json:"created_at"`
+	Edited_at  *time.Time `json:"edited_at"`
+	Deleted    bool       `json:"deleted"`
+	Version    int64      `json:"version"`
+	Replies    []Comment  `json:"replies"`
+}
+
+// Trims the body and checks its length
+func ValidateCommentBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if body == "" || utf8.RuneCountInString(body) > maxCommentBody {
+		return "", fmt.Errorf("%w: the body must have 1 to %d characters", ErrInvalidComment, maxCommentBody)
+	}
+	return body, nil
+}
+
+const commentColumns = `m.id, m.task_id, m.parent_id, coalesce(m.user_id, 0), coalesce(u.username, ''), m.body, m.created_at, m.edited_at,
+	m.deleted_at IS NOT NULL, m.version`
+
+func scanComment(row scanner) (Comment, error) {
+	var comment Comment
+	err := row.Scan(&comment.Id, &comment.Task_id, &comment.Parent_id, &comment.User_id, &comment.Author, &comment.Body, &comment.Created_at,
+		&comment.Edited_at, &comment.Deleted, &comment.Version)
+	return comment, err
+}
+
+// Returns the comment or ErrNoResult if it does not exist
+func GetComment(commentId int64) (Comment, error) {
+	query := `SELECT ` + commentColumns + ` FROM "TaskComments" m LEFT JOIN "User" u ON u.id = m.user_id WHERE m.id = $1`
+	comment, err := scanComment(dbInstance.db.QueryRow(query, commentId))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Comment{}, ErrNoResult
+		}
+		return Comment{}, fmt.Errorf("query error: %v", err)
+	}
+	comment.Replies = []Comment{}
+	return comment, nil
+}
+
+// Returns the comments of the task as threads, oldest first
+func GetComments(taskId int64) ([]Comment, error) {
+	query := `
+	SELECT ` + commentColumns + `
+	FROM "TaskComments" m LEFT JOIN "User" u ON u.id = m.user_id
+	WHERE m.task_id = $1
+	ORDER BY m.created_at, m.id`
+	rows, err := dbInstance.db.Query(query, taskId)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		comments = append(comments, comment)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return nestComments(comments), nil
+}
+
+// Moves the replies among the comments into the comments they answer, keeping their order
+func nestComments(comments []Comment) []Comment {
+	replies := make(map[int64][]Comment)
+	roots := []Comment{}
+	for _, comment := range comments {
+		if comment.Parent_id != nil {
+			replies[*comment.Parent_id] = append(replies[*comment.Parent_id], comment)
+		} else {
+			roots = append(roots, comment)
+		}
+	}
+	var nest func(comment Comment) Comment
+	nest = func(comment Comment) Comment {
+		comment.Replies = []Comment{}
+		for _, reply := range replies[comment.Id] {
+			comment.Replies = append(comment.Replies, nest(reply))
+		}
+		return comment
+	}
+	for i, root := range roots {
+		roots[i] = nest(root)
+	}
+	return roots
+}
+
+// Inserts a comment by the user on the task. A reply has to answer a comment on the same task. Returns ErrNoResult if the task does not exist
+func AddComment(comment Comment) (Comment, error) {
+	if comment.Parent_id != nil {
+		var taskId int64
+		err := dbInstance.db.QueryRow(`SELECT task_id FROM "TaskComments" WHERE id = $1`, *comment.Parent_id).Scan(&taskId)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return Comment{}, fmt.Errorf("query error: %v", err)
+		}
+		if taskId != comment.Task_id {
+			return Comment{}, fmt.Errorf("%w: the parent comment does not belong to the task", ErrInvalidComment)
+		}
+	}
+	query := `
+	INSERT INTO "TaskComments" (task_id, parent_id, user_id, body)
+	SELECT t.id, $2, $3, $4 FROM "Task" t WHERE t.id = $1
+	RETURNING id`
+	var commentId int64
+	err := dbInstance.db.QueryRow(query, comment.Task_id, comment.Parent_id, comment.User_id, comment.Body).Scan(&commentId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Comment{}, ErrNoResult
+		}
+		return Comment{}, fmt.Errorf("failed to insert comment: %v", err)
+	}
+	return GetComment(commentId)
+}
+
+// Replaces the body of the comment and marks it as edited. Returns ErrNoResult if the comment does not exist and ErrVersionConflict if
+// the stored version does not match the one given, unless that is 0
+func EditComment(commentId int64, body string, version int64) error {
+	var b updateBuilder
+	b.set("body", body)
+	b.set("edited_at", time.Now())
+	return b.exec("TaskComments", commentId, version)
+}
+
+// Deletes the comment. A comment with replies is only emptied and marked as deleted, so that the thread stays intact, and is removed
+// together with its last reply. If comment.Version is not 0 the comment is only deleted if the stored version still matches
+func DeleteComment(comment Comment) error {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockVersion(tx, "TaskComments", comment.Id, comment.Version)
+	if err != nil {
+		return err
+	}
+	id := comment.Id
+	for {
+		var replies bool
+		err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM "TaskComments" WHERE parent_id = $1)`, id).Scan(&replies)
+		if err != nil {
+			return fmt.Errorf("query error: %v", err)
+		}
+		if replies {
+			_, err = tx.Exec(`UPDATE "TaskComments" SET body = '', deleted_at = now(), version = version + 1 WHERE id = $1`, id)
+			if err != nil {
+				return fmt.Errorf("failed to delete comment: %v", err)
+			}
+			break
+		}
+		// The parent is removed as well if it was deleted before and this was its last reply
+		var parentId sql.NullInt64
+		var parentDeleted sql.NullBool
+		query := `
+		DELETE FROM "TaskComments" m WHERE m.id = $1
+		RETURNING m.parent_id, (SELECT p.deleted_at IS NOT NULL FROM "TaskComments" p WHERE p.id = m.parent_id)`
+		err = tx.QueryRow(query, id).Scan(&parentId, &parentDeleted)
+		if err != nil {
+			return fmt.Errorf("failed to delete comment: %v", err)
+		}
+		if !parentId.Valid || !parentDeleted.Bool {
+			break
+		}
+		id = parentId.Int64
+	}
+	return tx.Commit()
+}
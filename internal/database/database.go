@@ -58,6 +58,7 @@ func New() Service {
 		db: db,
 	}
 	dbInstance.createAllTables()
+	dbInstance.migrate()
 	return dbInstance
 }
 
@@ -203,121 +204,64 @@ func GetUserByID(userid int64) (User, error) {
 	return user, nil
 }
 
-// Returns the username associated with a category id. This is to check whether the user who made the request has the permission to manipulate the task with the specified id
-// Modify later for sharing feature
-func GetUsernameByCategoryId(category_id int64) (string, error) {
-	query := `SELECT u.username FROM "User" u JOIN "Categories" c ON u.id = c.belongs_to WHERE c.id = $1`
-	var username string
-	err := dbInstance.db.QueryRow(query, category_id).Scan(&username)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return "", ErrNoResult
-		}
-		log.Fatalf("Error getting username by Category ID: %v\n", err)
-	}
-	return username, nil
-}
-
-// Returns the username associated with a task id. This is to check whether the user who made the request has the permission to manipulate the task with the specified id
-func GetUsernameByTaskId(task_id int64) (string, error) {
-	query := `SELECT u.username FROM "User" u JOIN "Categories" c ON u.id = c.belongs_to JOIN "CategoryTasks" a ON c.id = a.category_id WHERE a.task_id = $1`
-	var username string
-	err := dbInstance.db.QueryRow(query, task_id).Scan(&username)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return "", ErrNoResult
-		}
-		log.Fatalf("Error getting username by Task ID: %v\n", err)
-	}
-	return username, nil
-}
-
-// Returns an empty Categories instance and sql.ErrNoRows if the category was not found
+// Returns ErrNoResult if the category was not found or is deleted
 func GetCategoryByID(categoryId int64) (Categories, error) {
-	querystr := `SELECT c.id, c.belongs_to, c.name, c.order FROM "Categories" c WHERE "id" = $1`
-	var category Categories
-	err := dbInstance.db.QueryRow(querystr, categoryId).Scan(&category.Id, &category.Belongs_to, &category.Name, &category.Order)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			log.Printf("No rows found with category ID %d\n", categoryId)
-			return Categories{}, err
-		}
-		log.Fatal(err)
-	}
-
-	return category, nil
+	query := `SELECT ` + categoryColumns + ` FROM "Categories" c WHERE "id" = $1 AND c.deleted_at IS NULL`
+	return queryCategory(dbInstance.db, query, categoryId)
 }
 
-// Returns a slice of Categories belonging to a particular user. Returns an empty slice if the user does not have any categories or if the user does not exist
-func GetCategoriesByUsername(username string) []Categories {
-	query := `SELECT c.id, c.belongs_to, c.name, c.order FROM "User" u JOIN "Categories" c ON u.id = c.belongs_to WHERE u.username = $1`
-	var categories []Categories
-	rows, err := dbInstance.db.Query(query, username)
+// Returns a slice of the Categories belonging to a particular workspace that are not archived. Returns an empty slice if the workspace does not have any categories or if the workspace does not exist
+func GetCategoriesByWorkspaceId(workspaceId int64) []Categories {
+	query := `SELECT ` + categoryColumns + ` FROM "Categories" c WHERE c.workspace_id = $1 AND c.deleted_at IS NULL AND c.archived_at IS NULL ORDER BY c.rank`
+	categories, err := queryCategories(dbInstance.db, query, workspaceId)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return categories
-		}
-		log.Fatal(err)
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var category Categories
-		err := rows.Scan(&category.Id, &category.Belongs_to, &category.Name, &category.Order)
-		if err != nil {
-			log.Fatal(err)
-		}
-		categories = append(categories, category)
-	}
-
-	if err = rows.Err(); err != nil {
 		log.Fatal(err)
 	}
-
 	return categories
 }
 
-// Returns a slice of Tasks belonging to a particular user. Returns an empty slice if the user does not have any tasks or if the user does not exist, nil on error
-func GetTasksByUsername(username string) []Task {
+// Returns a slice of the Tasks in the categories of a particular workspace that are not archived, like their categories. Returns an empty slice if the workspace does not have any tasks or if the workspace does not exist
+func GetTasksByWorkspaceId(workspaceId int64) []Task {
 	query := `
-	SELECT 
-		t.id,
-		t.title,
-		t.details,
-		t.state,
-		t.due,
-		a.order,
-		a.category_id
-
+	SELECT ` + taskColumns + `
 	FROM 
-		"User" u JOIN "Categories" c ON c.belongs_to = u.id JOIN "CategoryTasks" a ON c.id = a.category_id JOIN "Task" t ON a.task_id = t.id
+		"Categories" c JOIN "CategoryTasks" a ON c.id = a.category_id JOIN "Task" t ON a.task_id = t.id
 	WHERE 
-		u.username = $1;
+		c.workspace_id = $1 AND t.deleted_at IS NULL AND t.archived_at IS NULL AND c.archived_at IS NULL;
 	`
-	var tasks []Task
-	rows, err := dbInstance.db.Query(query, username)
+	tasks, err := queryTasks(dbInstance.db, query, workspaceId)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return tasks
-		}
 		log.Fatal(err)
 	}
-	defer rows.Close()
+	return tasks
+}
 
-	for rows.Next() {
-		var task Task
-		err := rows.Scan(&task.Id, &task.Title, &task.Details, &task.State, &task.Due, &task.Order, &task.Belongs_to)
-		if err != nil {
-			log.Fatal(err)
-		}
-		tasks = append(tasks, task)
-	}
+// Returns the task with the given id together with its category and order or ErrNoResult if it does not exist or is deleted
+func GetTaskById(taskId int64) (Task, error) {
+	query := `
+	SELECT ` + taskColumns + `
+	FROM "Task" t JOIN "CategoryTasks" a ON a.task_id = t.id
+	WHERE t.id = $1 AND t.deleted_at IS NULL`
+	return queryTask(dbInstance.db, query, taskId)
+}
 
-	if err = rows.Err(); err != nil {
-		log.Fatal(err)
-	}
+// Returns the tasks among the ids that exist and are not deleted, in no particular order
+func GetTasksByIds(taskIds []int64) ([]Task, error) {
+	query := `
+	SELECT ` + taskColumns + `
+	FROM "Task" t JOIN "CategoryTasks" a ON a.task_id = t.id
+	WHERE t.id = ANY($1) AND t.deleted_at IS NULL`
+	return queryTasks(dbInstance.db, query, taskIds)
+}
 
-	return tasks
+// Returns the tasks of a category that are not archived sorted by their order. Returns an empty slice if the category does not have any tasks
+func GetTasksByCategoryId(categoryId int64) ([]Task, error) {
+	query := `
+	SELECT ` + taskColumns + `
+	FROM "Task" t JOIN "CategoryTasks" a ON a.task_id = t.id
+	WHERE a.category_id = $1 AND t.deleted_at IS NULL AND t.archived_at IS NULL
+	ORDER BY a.rank`
+	return queryTasks(dbInstance.db, query, categoryId)
 }
 
 // not used
@@ -362,58 +306,21 @@ func GetTasksByUser(user User) ([]Task, error) {
 	return tasks, nil
 }
 
-func UpdateTask(task Task) Task {
-	query := `UPDATE "Task" SET title = $1, details = $2, state = $3, due = $4 WHERE id = $5`
-	result, err := dbInstance.db.Exec(query, task.Title, task.Details, task.State, task.Due, task.Id)
-	if err != nil {
-		log.Fatal(err)
-	}
-	rows, err := result.RowsAffected()
-	if err != nil {
-		log.Fatal(err)
-	}
-	if rows != 1 {
-		log.Fatalf("expected to affect 1 row, affected %d", rows)
-	}
-	return task
-}
-
-func DeleteTask(task Task) {
-
-	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	query0 := `SELECT "order", category_id FROM "CategoryTasks" WHERE task_id = $1`
-	query := `DELETE FROM "Task" WHERE id = $1`
-	query2 := `UPDATE "CategoryTasks" SET "order" = "order" - 1 WHERE "order" >= $1 AND category_id = $2`
-	var old_belongs_to int64
-	var old_order int64
-	err = tx.QueryRow(query0, task.Id).Scan(&old_order, &old_belongs_to)
+// Overwrites title, details, state and the due date fields of a task, keeping its priority, and increments its version. If task.Version is
+// not 0 the update only happens if the stored version still matches, otherwise ErrVersionConflict is returned. Returns ErrNoResult if the
+// task does not exist
+func UpdateTask(task Task) (Task, error) {
+	query := `UPDATE "Task" SET title = $1, details = $2, state = $3, due = $4, all_day = $5, due_timezone = $6, version = version + 1,
+		completed_at = CASE WHEN $3 = 'done' THEN coalesce(completed_at, now()) END
+	WHERE id = $7 AND ($8 = 0 OR version = $8) RETURNING version, completed_at, priority`
+	err := dbInstance.db.QueryRow(query, task.Title, task.Details, task.State, task.Due, task.All_day, task.Timezone, task.Id, task.Version).Scan(&task.Version, &task.Completed_at, &task.Priority)
 	if err != nil {
-		log.Fatal(err)
-	}
-	result, err := tx.Exec(query, task.Id)
-	if err != nil {
-		log.Fatal(err)
-	}
-	rows, err := result.RowsAffected()
-	if err != nil {
-		log.Fatal(err)
-	}
-	if rows != 1 {
-		tx.Rollback()
-		log.Fatalf("expected to affect 1 row, affected %d", rows)
-	}
-	_, err = tx.Exec(query2, old_order, old_belongs_to)
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = tx.Commit()
-	if err != nil {
-		log.Fatal(err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return Task{}, missingOrConflict(dbInstance.db, "Task", task.Id)
+		}
+		return Task{}, fmt.Errorf("failed to update task: %v", err)
 	}
+	return task, nil
 }
 
 // Hashes the given password using bcrypt
@@ -437,13 +344,29 @@ func AddUser(user User) error {
 		return fmt.Errorf("failed to hash password: %v", err)
 	}
 
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+
 	query := `
 	INSERT INTO "User" (username, password)
 	VALUES ($1, $2)
 	RETURNING id
 	`
 	var userID int64
-	err = dbInstance.db.QueryRow(query, user.Username, hashedPassword).Scan(&userID)
+	err = tx.QueryRow(query, user.Username, hashedPassword).Scan(&userID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to insert user: %v", err)
+	}
+
+	err = createPersonalWorkspace(tx, userID, user.Username)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
 	if err != nil {
 		return fmt.Errorf("failed to insert user: %v", err)
 	}
@@ -452,21 +375,26 @@ func AddUser(user User) error {
 	return nil
 }
 
+// Inserts the category at the position given by its order in its workspace. Only the new row is written
 func AddCategory(category Categories) int64 {
 
 	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
 	if err != nil {
 		log.Fatal(err)
 	}
-	query := `INSERT INTO "Categories" ("belongs_to", "name", "order") VALUES ($1, $2, $3) RETURNING id`
-	query2 := `UPDATE "Categories" SET "order" = "order" + 1 WHERE "order" >= $1 AND NOT "id" = $2`
-	var categoryID int64
-	err = tx.QueryRow(query, category.Belongs_to, category.Name, category.Order).Scan(&categoryID)
+	err = categoryList.lock(tx, category.Workspace_id)
 	if err != nil {
 		tx.Rollback()
 		log.Fatal(err)
 	}
-	_, err = tx.Exec(query2, category.Order, categoryID)
+	key, err := categoryList.keyAt(tx, category.Workspace_id, category.Order, 0)
+	if err != nil {
+		tx.Rollback()
+		log.Fatal(err)
+	}
+	query := `INSERT INTO "Categories" ("belongs_to", "workspace_id", "name", "rank") VALUES ($1, $2, $3, $4) RETURNING id`
+	var categoryID int64
+	err = tx.QueryRow(query, category.Belongs_to, category.Workspace_id, category.Name, key).Scan(&categoryID)
 	if err != nil {
 		tx.Rollback()
 		log.Fatal(err)
@@ -478,143 +406,99 @@ func AddCategory(category Categories) int64 {
 	return categoryID
 }
 
-func UpdateCategory(category Categories) Categories {
+// Renames a category and increments its version. If category.Version is not 0 the update only happens if the stored version still matches
+func UpdateCategory(category Categories) (Categories, error) {
 
-	query := `UPDATE "Categories" SET name = $1 WHERE id = $2`
-	result, err := dbInstance.db.Exec(query, category.Name, category.Id)
-	if err != nil {
-		log.Fatal(err)
-	}
-	rows, err := result.RowsAffected()
+	query := `UPDATE "Categories" SET name = $1, version = version + 1 WHERE id = $2 AND ($3 = 0 OR version = $3) RETURNING version`
+	err := dbInstance.db.QueryRow(query, category.Name, category.Id, category.Version).Scan(&category.Version)
 	if err != nil {
-		log.Fatal(err)
-	}
-	if rows != 1 {
-		log.Fatalf("expected to affect 1 row, affected %d", rows)
+		if errors.Is(err, sql.ErrNoRows) {
+			return Categories{}, missingOrConflict(dbInstance.db, "Categories", category.Id)
+		}
+		return Categories{}, fmt.Errorf("failed to update category: %v", err)
 	}
-	return category
+	return category, nil
 }
 
-func DeleteCategory(category Categories) {
-
+// Moves a category to a new position in its workspace by giving it a new rank key and increments its version. No other category is written.
+// If version is not 0 the category is only moved if the stored version still matches. Returns ErrArchived for an archived category
+func ChangeCategoryOrder(category_id int64, to int64, workspace_id int64, version int64) error {
 	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	query := `UPDATE "Categories" SET "order" = "order" - 1 WHERE "order" > (SELECT "order" FROM "Categories" WHERE "id" = $1)`
-	query2 := `DELETE FROM "Categories" WHERE id = $1`
-
-	_, err = tx.Exec(query, category.Id)
+	err = lockVersion(tx, "Categories", category_id, version)
 	if err != nil {
 		tx.Rollback()
-		log.Fatal(err)
+		return err
 	}
-
-	result, err := tx.Exec(query2, category.Id)
+	err = checkNotArchived(tx, "Categories", category_id)
 	if err != nil {
 		tx.Rollback()
-		log.Fatal(err)
+		return err
 	}
-	rows, err := result.RowsAffected()
+	err = categoryList.lock(tx, workspace_id)
 	if err != nil {
 		tx.Rollback()
-		log.Fatal(err)
+		return err
 	}
-	if rows != 1 {
+	key, err := categoryList.keyAt(tx, workspace_id, to, category_id)
+	if err != nil {
 		tx.Rollback()
-		log.Fatalf("expected to affect 1 row, affected %d", rows)
+		return err
 	}
-	err = tx.Commit()
+
+	query := `UPDATE "Categories" SET rank = $1, version = version + 1 WHERE id = $2 AND workspace_id = $3`
+	_, err = tx.Exec(query, key, category_id, workspace_id)
 	if err != nil {
-		log.Fatal(err)
+		tx.Rollback()
+		return fmt.Errorf("failed to update order: %v", err)
 	}
+	return tx.Commit()
 }
 
-func ChangeCategoryOrder(category_id int64, to int64, belongs_to int64) {
-	var oldCategoryOrder int64
+// Inserts the task at the position given by its order in the category given by belongs_to. Only the new rows are written
+func AddTask(task Task) Task {
+
 	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	query := `SELECT "order" FROM "Categories" WHERE id = $1`
-	err = tx.QueryRow(query, category_id).Scan(&oldCategoryOrder)
+	err = taskList.lock(tx, task.Belongs_to)
 	if err != nil {
 		tx.Rollback()
 		log.Fatal(err)
 	}
-
-	if oldCategoryOrder == to {
-		return
-	}
-	if oldCategoryOrder > to {
-		query = `UPDATE "Categories" SET "order" = "order" + 1 WHERE "order" >= $1 AND "order" < $2 AND belongs_to = $3`
-		_, err = tx.Exec(query, to, oldCategoryOrder, belongs_to)
-		if err != nil {
-			tx.Rollback()
-			log.Fatal(err)
-		}
-		query = `UPDATE "Categories" SET "order" = $1 WHERE id = $2`
-		_, err = tx.Exec(query, to, category_id)
-		if err != nil {
-			tx.Rollback()
-			log.Fatal(err)
-		}
-		err = tx.Commit()
-		if err != nil {
-			log.Fatal(err)
-		}
-	} else {
-		query = `UPDATE "Categories" SET "order" = "order" - 1 WHERE "order" > $1 AND "order" <= $2`
-		_, err = tx.Exec(query, oldCategoryOrder, to)
-		if err != nil {
-			tx.Rollback()
-			log.Fatal(err)
-		}
-		query = `UPDATE "Categories" SET "order" = $1 WHERE id = $2`
-		_, err = tx.Exec(query, to, category_id)
-		if err != nil {
-			tx.Rollback()
-			log.Fatal(err)
-		}
-		err = tx.Commit()
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-}
-
-func AddTask(task Task) Task {
-
-	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	task.Rank, err = taskList.keyAt(tx, task.Belongs_to, task.Order, 0)
 	if err != nil {
+		tx.Rollback()
 		log.Fatal(err)
 	}
 
 	query := `
 	WITH rows AS (
-        INSERT INTO "Task" (title, details, state, due)
-        VALUES ($1, $2, $3, $4)
+        INSERT INTO "Task" (title, details, state, due, all_day, due_timezone, completed_at, priority)
+        VALUES ($1, $2, $3, $4, $5, $6, CASE WHEN $3 = 'done' THEN now() END, $9)
         RETURNING id
         )
-        INSERT INTO "CategoryTasks" ("category_id", "order", "task_id")
-        SELECT $5, $6, id FROM rows
+        INSERT INTO "CategoryTasks" ("category_id", "rank", "task_id")
+        SELECT $7, $8, id FROM rows
 		RETURNING task_id;
 	`
-	query2 := `UPDATE "CategoryTasks" SET "order" = "order" + 1 WHERE "order" >= $1 AND category_id = $2 AND NOT task_id = $3`
 
-	err = tx.QueryRow(query, task.Title, task.Details, task.State, task.Due, task.Belongs_to, task.Order).Scan(&task.Id)
+	err = tx.QueryRow(query, task.Title, task.Details, task.State, task.Due, task.All_day, task.Timezone, task.Belongs_to, task.Rank, task.Priority).Scan(&task.Id)
 	if err != nil {
 		tx.Rollback()
-		log.Println("query1")
 		log.Fatal(err)
 	}
-	_, err = tx.Exec(query2, task.Order, task.Belongs_to, task.Id)
-	if err != nil {
-		log.Println("query2")
-		tx.Rollback()
-		log.Fatal(err)
+	var seriesId int64
+	if task.Recurrence != "" {
+		seriesId, err = createSeries(tx, task.Id, task.Recurrence)
+		if err != nil {
+			tx.Rollback()
+			log.Fatal(err)
+		}
 	}
 
 	err = tx.Commit()
@@ -622,6 +506,50 @@ func AddTask(task Task) Task {
 		log.Fatal(err)
 	}
 
+	task.Version = 1
+	if task.Recurrence != "" {
+		task.Series_id, task.Occurrence = &seriesId, 1
+		task.Recurrence, _ = parseRecurrence(task.Recurrence)
+	}
 	fmt.Printf("Task %s added with ID: %d\n", task.Title, task.Id)
 	return task
 }
+
+// Moves a task to a position in a category, which may be its current one, and increments its version. The task keeps its id and gets a new
+// rank key between its new neighbours, so no other task is written. Its subtasks move along, a subtask itself can't be moved. If version is
+// not 0 the task is only moved if the stored version still matches
+func MoveTask(taskId int64, toCategory int64, toOrder int64, version int64) error {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+
+	err = lockVersion(tx, "Task", taskId, version)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = taskList.lock(tx, toCategory)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	key, err := taskList.keyAt(tx, toCategory, toOrder, taskId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = moveTaskTree(tx, taskId, toCategory, key)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	query := `UPDATE "Task" SET version = version + 1 WHERE id = $1`
+	_, err = tx.Exec(query, taskId)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to update version: %v", err)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,183 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidDependency error = errors.New("invalid dependency")
+	ErrDependencyCycle   error = errors.New("the dependency would create a cycle")
+)
+
+// A task referenced by a dependency
+type TaskRef struct {
+	Id    int64     `json:"id"`
+	Title string    `json:"title"`
+	State TaskState `json:"state"`
+}
+
+// The tasks a task waits for and the tasks waiting for it. Only tasks the user can access are listed, Hidden_blockers counts the other
+// tasks the task waits for
+type TaskDependencies struct {
+	Task_id         int64     `json:"task_id"`
+	Blocked_by      []TaskRef `json:"blocked_by"`
+	Blocks          []TaskRef `json:"blocks"`
+	Hidden_blockers int64     `json:"hidden_blockers"`
+}
+
+// An open blocker of the task Task_id. Visible is false if the user can't access the blocker, which must then not be shown
+type OpenBlocker struct {
+	TaskRef
+	Task_id int64
+	Visible bool
+}
+
+// Selects whether the user $2 can access the task t
+const taskVisible = `EXISTS (
+		SELECT 1 FROM "CategoryTasks" va JOIN "CategoryAccess" ca ON ca.category_id = va.category_id JOIN "User" u ON u.id = ca.user_id
+		WHERE va.task_id = t.id AND u.username = $2)`
+
+// Records that the task can't start before the blocker is closed. Both tasks have to be in the same workspace. Adding an existing dependency
+// changes nothing. Returns ErrDependencyCycle if the blocker already waits for the task, directly or through other tasks
+func AddDependency(taskId int64, blockerId int64) error {
+	if taskId == blockerId {
+		return fmt.Errorf("%w: a task can't depend on itself", ErrInvalidDependency)
+	}
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Serializes the insertions, so that two concurrent ones can't close a cycle the check of each one misses
+	_, err = tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('TaskDependencies'))`)
+	if err != nil {
+		return err
+	}
+	var workspaces, tasks int64
+	query := `
+	SELECT count(DISTINCT c.workspace_id), count(*)
+	FROM "CategoryTasks" a JOIN "Categories" c ON c.id = a.category_id
+	WHERE a.task_id = ANY($1)`
+	err = tx.QueryRow(query, []int64{taskId, blockerId}).Scan(&workspaces, &tasks)
+	if err != nil {
+		return fmt.Errorf("query error: %v", err)
+	}
+	if tasks < 2 {
+		return ErrNoResult
+	}
+	if workspaces > 1 {
+		return fmt.Errorf("%w: the tasks are in different workspaces", ErrInvalidDependency)
+	}
+
+	var cycle bool
+	query = `
+	WITH RECURSIVE chain AS (
+		SELECT blocker_id FROM "TaskDependencies" WHERE task_id = $1
+		UNION
+		SELECT d.blocker_id FROM "TaskDependencies" d JOIN chain ON d.task_id = chain.blocker_id
+	)
+	SELECT EXISTS (SELECT 1 FROM chain WHERE blocker_id = $2)`
+	err = tx.QueryRow(query, blockerId, taskId).Scan(&cycle)
+	if err != nil {
+		return fmt.Errorf("query error: %v", err)
+	}
+	if cycle {
+		return fmt.Errorf("%w: task %d already waits for task %d", ErrDependencyCycle, blockerId, taskId)
+	}
+
+	_, err = tx.Exec(`INSERT INTO "TaskDependencies" (task_id, blocker_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, taskId, blockerId)
+	if err != nil {
+		return fmt.Errorf("failed to insert dependency: %v", err)
+	}
+	return tx.Commit()
+}
+
+// Removes the dependency. Returns ErrNoResult if it does not exist
+func RemoveDependency(taskId int64, blockerId int64) error {
+	return expectOneRow(dbInstance.db.Exec(`DELETE FROM "TaskDependencies" WHERE task_id = $1 AND blocker_id = $2`, taskId, blockerId))
+}
+
+// Returns the blockers of the task and the tasks it blocks that the user can access
+func GetDependencies(taskId int64, username string) (TaskDependencies, error) {
+	var err error
+	dependencies := TaskDependencies{Task_id: taskId}
+	query := `
+	SELECT t.id, t.title, t.state
+	FROM "TaskDependencies" d JOIN "Task" t ON t.id = d.blocker_id
+	WHERE d.task_id = $1 AND t.deleted_at IS NULL AND ` + taskVisible + `
+	ORDER BY t.id`
+	dependencies.Blocked_by, err = queryTaskRefs(query, taskId, username)
+	if err != nil {
+		return TaskDependencies{}, err
+	}
+	query = `
+	SELECT t.id, t.title, t.state
+	FROM "TaskDependencies" d JOIN "Task" t ON t.id = d.task_id
+	WHERE d.blocker_id = $1 AND t.deleted_at IS NULL AND ` + taskVisible + `
+	ORDER BY t.id`
+	dependencies.Blocks, err = queryTaskRefs(query, taskId, username)
+	if err != nil {
+		return TaskDependencies{}, err
+	}
+	query = `
+	SELECT count(*)
+	FROM "TaskDependencies" d JOIN "Task" t ON t.id = d.blocker_id
+	WHERE d.task_id = $1 AND t.deleted_at IS NULL AND NOT ` + taskVisible
+	err = dbInstance.db.QueryRow(query, taskId, username).Scan(&dependencies.Hidden_blockers)
+	if err != nil {
+		return TaskDependencies{}, fmt.Errorf("query error: %v", err)
+	}
+	return dependencies, nil
+}
+
+// Returns the blockers of the tasks that are neither done nor cancelled, keyed by task id. Deleted blockers block nothing. Blockers the
+// user can't access are marked as not visible
+func GetOpenBlockers(taskIds []int64, username string) (map[int64][]OpenBlocker, error) {
+	query := `
+	SELECT d.task_id, t.id, t.title, t.state, ` + taskVisible + `
+	FROM "TaskDependencies" d JOIN "Task" t ON t.id = d.blocker_id
+	WHERE d.task_id = ANY($1) AND t.state NOT IN ` + closedStates + ` AND t.deleted_at IS NULL
+	ORDER BY d.task_id, t.id`
+	rows, err := dbInstance.db.Query(query, taskIds, username)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	blockers := map[int64][]OpenBlocker{}
+	for rows.Next() {
+		var blocker OpenBlocker
+		err = rows.Scan(&blocker.Task_id, &blocker.Id, &blocker.Title, &blocker.State, &blocker.Visible)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		blockers[blocker.Task_id] = append(blockers[blocker.Task_id], blocker)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return blockers, nil
+}
+
+// Runs a query selecting id, title and state of tasks. Returns an empty slice if there are no rows
+func queryTaskRefs(query string, args ...any) ([]TaskRef, error) {
+	rows, err := dbInstance.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	refs := []TaskRef{}
+	for rows.Next() {
+		var ref TaskRef
+		err = rows.Scan(&ref.Id, &ref.Title, &ref.State)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidTimezone error = errors.New("unknown time zone")
+var ErrInvalidDue error = errors.New("invalid due date")
+
+var locations sync.Map
+
+// Returns the IANA time zone with the name. Unknown and empty names fall back to UTC. Loaded zones are cached
+func location(name string) *time.Location {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "" {
+		loc = time.UTC
+	}
+	locations.Store(name, loc)
+	return loc
+}
+
+// Reports whether the name is an IANA time zone known to the server
+func ValidTimezone(name string) bool {
+	_, err := time.LoadLocation(name)
+	return name != "" && err == nil
+}
+
+// Checks the due date fields of a task as sent by a client. An all-day task needs a due date and the year has to be within 1970 and 9999
+func (t Task) ValidateDue() error {
+	if t.Timezone != "" && !ValidTimezone(t.Timezone) {
+		return fmt.Errorf("%w: %s", ErrInvalidTimezone, t.Timezone)
+	}
+	if t.Due == nil {
+		if t.All_day {
+			return fmt.Errorf("%w: all_day requires a due date", ErrInvalidDue)
+		}
+		return nil
+	}
+	if t.Due.Year() < 1970 || t.Due.Year() > 9999 {
+		return fmt.Errorf("%w: %s is out of range", ErrInvalidDue, t.Due.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Fills in the time zone, if the task has none, and moves an all-day due date to midnight of its day in that time zone
+func (t *Task) NormalizeDue(timezone string) {
+	if t.Timezone == "" {
+		t.Timezone = timezone
+	}
+	if t.Due == nil {
+		t.All_day = false
+		return
+	}
+	due := t.Due.In(location(t.Timezone))
+	if t.All_day {
+		due = time.Date(due.Year(), due.Month(), due.Day(), 0, 0, 0, 0, due.Location())
+	}
+	t.Due = &due
+}
+
+// Layouts of the free-text due dates written by older versions, mostly by the datetime-local input of the frontend
+var legacyDueLayouts = []struct {
+	layout string
+	allDay bool
+}{
+	{time.RFC3339, false},
+	{"2006-01-02T15:04", false},
+	{"2006-01-02T15:04:05", false},
+	{"2006-01-02 15:04", false},
+	{"2006-01-02 15:04:05", false},
+	{"02.01.2006 15:04", false},
+	{"2006-01-02", true},
+	{"02.01.2006", true},
+}
+
+// Parses a free-text due date. Times without an offset are taken as UTC
+func parseLegacyDue(text string) (time.Time, bool, bool) {
+	text = strings.TrimSpace(text)
+	for _, l := range legacyDueLayouts {
+		due, err := time.Parse(l.layout, text)
+		if err == nil {
+			return due, l.allDay, true
+		}
+	}
+	return time.Time{}, false, false
+}
@@ -0,0 +1,182 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrInvalidLabel error = errors.New("invalid label")
+
+const maxLabelName = 50
+
+var labelColor = regexp.MustCompile(`^#[0-9a-f]{6}$`)
+
+// A label of a workspace or, if Workspace_id is null, a personal label of the user who created it. Personal labels can only be seen and
+// used by that user, but show on every task they are put on
+type Label struct {
+	Id           int64  `json:"id"`
+	Name         string `json:"name"`
+	Color        string `json:"color"`
+	Workspace_id *int64 `json:"workspace_id"`
+	User_id      int64  `json:"-"`
+	Version      int64  `json:"version"`
+}
+
+// A label as shown on a task
+type TaskLabel struct {
+	Id    int64  `json:"id"`
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// A partial update of a label
+type LabelPatch struct {
+	Name    Optional[string] `json:"name"`
+	Color   Optional[string] `json:"color"`
+	Version int64            `json:"version"`
+}
+
+// Checks the name and the color of a label and lowercases the color
+func (l *Label) Validate() error {
+	l.Name = strings.TrimSpace(l.Name)
+	if l.Name == "" || utf8.RuneCountInString(l.Name) > maxLabelName {
+		return fmt.Errorf("%w: the name must have 1 to %d characters", ErrInvalidLabel, maxLabelName)
+	}
+	l.Color = strings.ToLower(l.Color)
+	if !labelColor.MatchString(l.Color) {
+		return fmt.Errorf("%w: the color must be given as #rrggbb", ErrInvalidLabel)
+	}
+	return nil
+}
+
+// Checks and normalizes the fields that are set
+func (p *LabelPatch) Validate() error {
+	if p.Name.Null || p.Color.Null {
+		return fmt.Errorf("name and color: %w", ErrNullField)
+	}
+	label := Label{Name: "-", Color: "#000000"}
+	if p.Name.Set {
+		label.Name = p.Name.Value
+	}
+	if p.Color.Set {
+		label.Color = p.Color.Value
+	}
+	err := label.Validate()
+	if err != nil {
+		return err
+	}
+	if p.Name.Set {
+		p.Name.Value = label.Name
+	}
+	if p.Color.Set {
+		p.Color.Value = label.Color
+	}
+	return nil
+}
+
+const labelColumns = `l.id, l.name, l.color, l.workspace_id, coalesce(l.user_id, 0), l.version`
+
+func scanLabel(row scanner) (Label, error) {
+	var label Label
+	err := row.Scan(&label.Id, &label.Name, &label.Color, &label.Workspace_id, &label.User_id, &label.Version)
+	return label, err
+}
+
+// Returns the label or ErrNoResult if it does not exist
+func GetLabel(labelId int64) (Label, error) {
+	label, err := scanLabel(dbInstance.db.QueryRow(`SELECT `+labelColumns+` FROM "Labels" l WHERE l.id = $1`, labelId))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Label{}, ErrNoResult
+		}
+		return Label{}, fmt.Errorf("query error: %v", err)
+	}
+	return label, nil
+}
+
+// Returns the personal labels of the user followed by the labels of the workspace, sorted by name. A workspace id of 0 returns the labels
+// of all workspaces the user is a member of
+func GetLabels(username string, workspaceId int64) ([]Label, error) {
+	query := `
+	SELECT ` + labelColumns + `
+	FROM "Labels" l JOIN "User" u ON u.username = $1
+	WHERE l.user_id = u.id
+		OR ($2 = 0 AND l.workspace_id IN (SELECT m.workspace_id FROM "WorkspaceMembers" m WHERE m.user_id = u.id))
+		OR l.workspace_id = $2
+	ORDER BY l.workspace_id NULLS FIRST, lower(l.name), l.id`
+	rows, err := dbInstance.db.Query(query, username, workspaceId)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	labels := []Label{}
+	for rows.Next() {
+		label, err := scanLabel(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		labels = append(labels, label)
+	}
+	return labels, rows.Err()
+}
+
+// Inserts a label of the workspace or, without workspace, of the user. Returns ErrAlreadyExists if the scope already has a label of that name
+func AddLabel(label Label) (Label, error) {
+	var userId *int64
+	if label.Workspace_id == nil {
+		userId = &label.User_id
+	}
+	query := `INSERT INTO "Labels" (name, color, workspace_id, user_id) VALUES ($1, $2, $3, $4) RETURNING id, version`
+	err := dbInstance.db.QueryRow(query, label.Name, label.Color, label.Workspace_id, userId).Scan(&label.Id, &label.Version)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return Label{}, ErrAlreadyExists
+		}
+		return Label{}, fmt.Errorf("failed to insert label: %v", err)
+	}
+	return label, nil
+}
+
+// Updates only the fields of the label that are set in the patch. Returns ErrNoResult if the label does not exist, ErrVersionConflict if
+// the patch is based on an outdated version and ErrAlreadyExists if the new name is taken
+func PatchLabel(labelId int64, patch LabelPatch) error {
+	var b updateBuilder
+	if patch.Name.Set {
+		b.set("name", patch.Name.Value)
+	}
+	if patch.Color.Set {
+		b.set("color", patch.Color.Value)
+	}
+	if len(b.columns) == 0 {
+		return nil
+	}
+	err := b.exec("Labels", labelId, patch.Version)
+	if isUniqueViolation(err) {
+		return ErrAlreadyExists
+	}
+	return err
+}
+
+// Deletes a label and removes it from all tasks. If label.Version is not 0 the label is only deleted if the stored version still matches
+func DeleteLabel(label Label) error {
+	return deleteVersioned("Labels", label.Id, label.Version)
+}
+
+// Puts the label on the task. Putting it on again changes nothing
+func AddTaskLabel(taskId int64, labelId int64) error {
+	_, err := dbInstance.db.Exec(`INSERT INTO "TaskLabels" (task_id, label_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, taskId, labelId)
+	if err != nil {
+		return fmt.Errorf("failed to add label: %v", err)
+	}
+	return nil
+}
+
+// Removes the label from the task. Returns ErrNoResult if the task does not have the label
+func RemoveTaskLabel(taskId int64, labelId int64) error {
+	return expectOneRow(dbInstance.db.Exec(`DELETE FROM "TaskLabels" WHERE task_id = $1 AND label_id = $2`, taskId, labelId))
+}
@@ -0,0 +1,244 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor error = errors.New("invalid cursor")
+
+// How the labels of a task listing are matched
+const (
+	LabelMatchAny = "any"
+	LabelMatchAll = "all"
+)
+
+// Filters, sort order and page of a task listing. Zero values don't filter. Only tasks the user can access through workspaces or shares are listed,
+// without the archived tasks and the tasks of archived categories.
+// Labels selects the tasks with any of the labels or, with Label_match LabelMatchAll, with all of them. Assigned_to_me selects the tasks
+// assigned to the user
+type TaskQuery struct {
+	Username       string
+	Workspace_id   int64
+	Category_id    int64
+	State          *TaskState
+	Due_before     *time.Time
+	Due_after      *time.Time
+	Text           string
+	Labels         []int64
+	Label_match    string
+	Assigned_to_me bool
+	Sort           string
+	Descending     bool
+	Limit          int
+	Cursor         string
+}
+
+// One page of a task listing. Next_cursor is empty on the last page
+type TaskPage struct {
+	Tasks       []Task `json:"tasks"`
+	Next_cursor string `json:"next_cursor,omitempty"`
+}
+
+// A sort order for tasks. The columns end with the task id, so that they identify a position in the listing, which is what the cursor stores
+type taskSort struct {
+	columns []string
+	types   []string
+	values  func(Task) []string
+}
+
+// The group of a task in the smart sort order: open tasks first, blocked ones after them and closed ones last
+const stateGroup = `CASE WHEN t.state IN ` + closedStates + ` THEN 2 WHEN t.state = 'blocked' THEN 1 ELSE 0 END`
+
+func taskStateGroup(state TaskState) int {
+	switch {
+	case state.Closed():
+		return 2
+	case state == StateBlocked:
+		return 1
+	}
+	return 0
+}
+
+// The sort orders of QueryTasks. Each one except "smart" is backed by an index, "order" sorts by category and the position in it
+var taskSorts = map[string]taskSort{
+	"created": {
+		columns: []string{"t.created_at", "t.id"},
+		types:   []string{"timestamptz", "bigint"},
+		values: func(t Task) []string {
+			return []string{t.Created_at.Format(time.RFC3339Nano), strconv.FormatInt(t.Id, 10)}
+		},
+	},
+	// Tasks without due date come last
+	"due": {
+		columns: []string{"(t.due IS NULL)", "coalesce(t.due, '-infinity')", "t.id"},
+		types:   []string{"boolean", "timestamptz", "bigint"},
+		values: func(t Task) []string {
+			due := "-infinity"
+			if t.Due != nil {
+				due = t.Due.Format(time.RFC3339Nano)
+			}
+			return []string{strconv.FormatBool(t.Due == nil), due, strconv.FormatInt(t.Id, 10)}
+		},
+	},
+	// Subtasks have no rank in the category and come first
+	"order": {
+		columns: []string{"a.category_id", "coalesce(a.rank, '')", "t.id"},
+		types:   []string{"bigint", "text", "bigint"},
+		values: func(t Task) []string {
+			rank := t.Rank
+			if t.Parent_task_id != nil {
+				rank = ""
+			}
+			return []string{strconv.FormatInt(t.Belongs_to, 10), rank, strconv.FormatInt(t.Id, 10)}
+		},
+	},
+	// By state group, then by priority from urgent to none, then by due date with tasks without due date last. Ties keep the manual order
+	"smart": {
+		columns: []string{stateGroup, "-t.priority", "(t.due IS NULL)", "coalesce(t.due, '-infinity')", "a.category_id", "coalesce(a.rank, '')", "t.id"},
+		types:   []string{"integer", "integer", "boolean", "timestamptz", "bigint", "text", "bigint"},
+		values: func(t Task) []string {
+			due := "-infinity"
+			if t.Due != nil {
+				due = t.Due.Format(time.RFC3339Nano)
+			}
+			rank := t.Rank
+			if t.Parent_task_id != nil {
+				rank = ""
+			}
+			return []string{strconv.Itoa(taskStateGroup(t.State)), strconv.Itoa(-int(t.Priority)), strconv.FormatBool(t.Due == nil), due,
+				strconv.FormatInt(t.Belongs_to, 10), rank, strconv.FormatInt(t.Id, 10)}
+		},
+	},
+	"title": {
+		columns: []string{"t.title", "t.id"},
+		types:   []string{"text", "bigint"},
+		values: func(t Task) []string {
+			return []string{t.Title, strconv.FormatInt(t.Id, 10)}
+		},
+	},
+}
+
+// Reports whether QueryTasks supports the sort order
+func ValidTaskSort(sort string) bool {
+	_, ok := taskSorts[sort]
+	return ok
+}
+
+// The cursor points behind the last task of a page. It is only valid for the sort order it was created for
+type taskCursor struct {
+	Sort       string   `json:"s"`
+	Descending bool     `json:"d"`
+	Values     []string `json:"v"`
+}
+
+func encodeCursor(cursor taskCursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string, sort taskSort) (taskCursor, error) {
+	var cursor taskCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	err = json.Unmarshal(b, &cursor)
+	if err != nil || len(cursor.Values) != len(sort.columns) {
+		return cursor, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// Lists one page of the tasks matching the query using keyset pagination, so that every page costs the same regardless of how far the user paged
+func QueryTasks(q TaskQuery) (TaskPage, error) {
+	sort, ok := taskSorts[q.Sort]
+	if !ok {
+		return TaskPage{}, fmt.Errorf("unknown sort order %q", q.Sort)
+	}
+
+	var args []any
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	where := []string{"u.username = " + arg(q.Username), "t.deleted_at IS NULL", "t.archived_at IS NULL", "c.archived_at IS NULL"}
+	if q.Workspace_id != 0 {
+		where = append(where, "c.workspace_id = "+arg(q.Workspace_id))
+	}
+	if q.Category_id != 0 {
+		where = append(where, "a.category_id = "+arg(q.Category_id))
+	}
+	if q.State != nil {
+		where = append(where, "t.state = "+arg(*q.State))
+	}
+	if q.Due_before != nil {
+		where = append(where, "t.due < "+arg(*q.Due_before))
+	}
+	if q.Due_after != nil {
+		where = append(where, "t.due >= "+arg(*q.Due_after))
+	}
+	if len(q.Labels) > 0 {
+		labels := arg(q.Labels)
+		if q.Label_match == LabelMatchAll {
+			where = append(where, fmt.Sprintf(`(SELECT count(*) FROM "TaskLabels" tl WHERE tl.task_id = t.id AND tl.label_id = ANY(%s)) = %s`, labels, arg(len(q.Labels))))
+		} else {
+			where = append(where, fmt.Sprintf(`EXISTS (SELECT 1 FROM "TaskLabels" tl WHERE tl.task_id = t.id AND tl.label_id = ANY(%s))`, labels))
+		}
+	}
+	if q.Assigned_to_me {
+		where = append(where, `EXISTS (SELECT 1 FROM "TaskAssignees" ta WHERE ta.task_id = t.id AND ta.user_id = u.id)`)
+	}
+	if q.Text != "" {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(q.Text) + "%"
+		where = append(where, "(coalesce(t.title, '') || ' ' || coalesce(t.details, '')) ILIKE "+arg(pattern))
+	}
+
+	direction, comparison := "ASC", ">"
+	if q.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if q.Cursor != "" {
+		cursor, err := decodeCursor(q.Cursor, sort)
+		if err != nil {
+			return TaskPage{}, err
+		}
+		if cursor.Sort != q.Sort || cursor.Descending != q.Descending {
+			return TaskPage{}, fmt.Errorf("%w: the cursor belongs to another sort order", ErrInvalidCursor)
+		}
+		placeholders := make([]string, len(cursor.Values))
+		for i, value := range cursor.Values {
+			placeholders[i] = arg(value) + "::" + sort.types[i]
+		}
+		where = append(where, fmt.Sprintf("(%s) %s (%s)", strings.Join(sort.columns, ", "), comparison, strings.Join(placeholders, ", ")))
+	}
+	order := make([]string, len(sort.columns))
+	for i, column := range sort.columns {
+		order[i] = column + " " + direction
+	}
+
+	// One more task than requested tells whether there is a next page
+	query := `
+	SELECT ` + taskColumns + `
+	FROM "Task" t JOIN "CategoryTasks" a ON a.task_id = t.id JOIN "Categories" c ON c.id = a.category_id
+		JOIN "CategoryAccess" ca ON ca.category_id = a.category_id JOIN "User" u ON u.id = ca.user_id
+	WHERE ` + strings.Join(where, " AND ") + `
+	ORDER BY ` + strings.Join(order, ", ") + `
+	LIMIT ` + arg(q.Limit+1)
+	tasks, err := queryTasks(dbInstance.db, query, args...)
+	if err != nil {
+		return TaskPage{}, err
+	}
+
+	page := TaskPage{Tasks: tasks}
+	if len(tasks) > q.Limit {
+		page.Tasks = tasks[:q.Limit]
+		page.Next_cursor = encodeCursor(taskCursor{Sort: q.Sort, Descending: q.Descending, Values: sort.values(page.Tasks[q.Limit-1])})
+	}
+	return page, nil
+}
@@ -0,0 +1,604 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+)
+
+// A migration upgrades the schema of an existing database. Every migration is applied exactly once, in the order of the migrations slice
+type migration struct {
+	version     int64
+	description string
+	apply       func(tx *sql.Tx) error
+}
+
+var migrations = []migration{
+	{1, "workspaces with membership and personal workspaces for existing users", migrateWorkspaces},
+	{2, "category shares and invitation links", migrateCategoryShares},
+	{3, "version columns for optimistic concurrency control", migrateVersions},
+	{4, "rank keys replace the order of categories and tasks", migrateRanks},
+	{5, "creation time of tasks and indexes for listing tasks", migrateTaskListing},
+	{6, "full-text search on tasks", migrateTaskSearch},
+	{7, "due dates as timestamps with all-day flag and time zones", migrateDueDates},
+	{8, "recurring task series", migrateRecurrence},
+	{9, "reminders before due dates", migrateReminders},
+	{10, "named task states with per-workspace transitions", migrateTaskStates},
+	{11, "subtasks and checklist items", migrateSubtasks},
+	{12, "dependencies between tasks", migrateDependencies},
+	{13, "labels on tasks", migrateLabels},
+	{14, "task priorities", migrateTaskPriority},
+	{15, "task assignees and activity", migrateAssignees},
+	{16, "comments on tasks", migrateComments},
+	{17, "attachments and removal of the tasks of deleted categories", migrateAttachments},
+	{18, "audit log of tasks and categories replacing the task activity", migrateAuditLog},
+	{19, "soft delete of tasks and categories", migrateSoftDelete},
+	{20, "archive of tasks and categories with auto-archive of done tasks", migrateArchive},
+}
+
+// Applies all migrations that have not been applied yet. Each migration runs in its own transaction together with the bookkeeping row
+func (s *service) migrate() {
+	queryStr := `CREATE TABLE IF NOT EXISTS "SchemaMigrations" (
+	"version" bigint NOT NULL,
+	"description" text NOT NULL,
+	"applied_at" timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY ("version")
+);`
+	_, err := s.db.Exec(queryStr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, m := range migrations {
+		tx, err := s.db.Begin()
+		if err != nil {
+			log.Fatal(err)
+		}
+		// Serializes concurrently starting servers so that no migration is applied twice
+		_, err = tx.Exec(`LOCK TABLE "SchemaMigrations" IN EXCLUSIVE MODE`)
+		if err != nil {
+			tx.Rollback()
+			log.Fatal(err)
+		}
+		var applied bool
+		err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM "SchemaMigrations" WHERE "version" = $1)`, m.version).Scan(&applied)
+		if err != nil {
+			tx.Rollback()
+			log.Fatal(err)
+		}
+		if applied {
+			tx.Rollback()
+			continue
+		}
+		err = m.apply(tx)
+		if err != nil {
+			tx.Rollback()
+			log.Fatalf("migration %d (%s) failed: %v", m.version, m.description, err)
+		}
+		_, err = tx.Exec(`INSERT INTO "SchemaMigrations" ("version", "description") VALUES ($1, $2)`, m.version, m.description)
+		if err != nil {
+			tx.Rollback()
+			log.Fatal(err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Applied migration %d: %s\n", m.version, m.description)
+	}
+}
+
+// Categories used to belong to a single user. They now belong to a workspace and every existing user gets a personal workspace that receives their categories
+func migrateWorkspaces(tx *sql.Tx) error {
+	queryStr := `CREATE TABLE IF NOT EXISTS "Workspace" (
+	"id" bigint GENERATED ALWAYS AS IDENTITY NOT NULL UNIQUE,
+	"name" text NOT NULL,
+	"personal" boolean NOT NULL DEFAULT false,
+	"created_by" bigint,
+	PRIMARY KEY ("id"),
+	FOREIGN KEY ("created_by") REFERENCES "User"("id") ON DELETE SET NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS "Workspace_personal_idx" ON "Workspace" ("created_by") WHERE "personal";
+
+CREATE TABLE IF NOT EXISTS "WorkspaceMembers" (
+	"workspace_id" bigint NOT NULL,
+	"user_id" bigint NOT NULL,
+	"role" text NOT NULL CHECK ("role" IN ('owner', 'editor', 'viewer')),
+	PRIMARY KEY ("workspace_id", "user_id"),
+	FOREIGN KEY ("workspace_id") REFERENCES "Workspace"("id") ON DELETE CASCADE,
+	FOREIGN KEY ("user_id") REFERENCES "User"("id") ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS "WorkspaceInvitations" (
+	"id" bigint GENERATED ALWAYS AS IDENTITY NOT NULL UNIQUE,
+	"workspace_id" bigint NOT NULL,
+	"invited_user" bigint NOT NULL,
+	"invited_by" bigint,
+	"role" text NOT NULL CHECK ("role" IN ('owner', 'editor', 'viewer')),
+	"created_at" timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY ("id"),
+	UNIQUE ("workspace_id", "invited_user"),
+	FOREIGN KEY ("workspace_id") REFERENCES "Workspace"("id") ON DELETE CASCADE,
+	FOREIGN KEY ("invited_user") REFERENCES "User"("id") ON DELETE CASCADE,
+	FOREIGN KEY ("invited_by") REFERENCES "User"("id") ON DELETE SET NULL
+);
+
+ALTER TABLE "Categories" ADD COLUMN IF NOT EXISTS "workspace_id" bigint REFERENCES "Workspace"("id") ON DELETE CASCADE;
+
+INSERT INTO "Workspace" ("name", "personal", "created_by")
+SELECT u.username, true, u.id FROM "User" u
+WHERE NOT EXISTS (SELECT 1 FROM "Workspace" w WHERE w.personal AND w.created_by = u.id);
+
+INSERT INTO "WorkspaceMembers" ("workspace_id", "user_id", "role")
+SELECT w.id, w.created_by, 'owner' FROM "Workspace" w WHERE w.personal
+ON CONFLICT DO NOTHING;
+
+UPDATE "Categories" c SET "workspace_id" = w.id FROM "Workspace" w
+WHERE w.personal AND w.created_by = c.belongs_to AND c.workspace_id IS NULL;
+
+ALTER TABLE "Categories" ALTER COLUMN "workspace_id" SET NOT NULL;`
+
+	_, err := tx.Exec(queryStr)
+	return err
+}
+
+// Categories can be shared with single users through invitation links. The CategoryAccess view combines workspace membership and shares into one role per user and category
+func migrateCategoryShares(tx *sql.Tx) error {
+	queryStr := `CREATE TABLE IF NOT EXISTS "CategoryShares" (
+	"category_id" bigint NOT NULL,
+	"user_id" bigint NOT NULL,
+	"role" text NOT NULL CHECK ("role" IN ('editor', 'viewer')),
+	PRIMARY KEY ("category_id", "user_id"),
+	FOREIGN KEY ("category_id") REFERENCES "Categories"("id") ON DELETE CASCADE,
+	FOREIGN KEY ("user_id") REFERENCES "User"("id") ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS "CategoryInvitations" (
+	"id" bigint GENERATED ALWAYS AS IDENTITY NOT NULL UNIQUE,
+	"category_id" bigint NOT NULL,
+	"token_hash" text NOT NULL UNIQUE,
+	"role" text NOT NULL CHECK ("role" IN ('editor', 'viewer')),
+	"created_by" bigint,
+	"created_at" timestamptz NOT NULL DEFAULT now(),
+	"expires_at" timestamptz NOT NULL,
+	"single_use" boolean NOT NULL DEFAULT false,
+	"use_count" bigint NOT NULL DEFAULT 0,
+	"revoked_at" timestamptz,
+	PRIMARY KEY ("id"),
+	FOREIGN KEY ("category_id") REFERENCES "Categories"("id") ON DELETE CASCADE,
+	FOREIGN KEY ("created_by") REFERENCES "User"("id") ON DELETE SET NULL
+);
+
+CREATE OR REPLACE VIEW "CategoryAccess" AS
+SELECT a.category_id, a.user_id, (array_agg(a.role ORDER BY a.rank DESC))[1] AS role
+FROM (
+	SELECT c.id AS category_id, m.user_id, m.role, CASE m.role WHEN 'owner' THEN 3 WHEN 'editor' THEN 2 ELSE 1 END AS rank
+	FROM "Categories" c JOIN "WorkspaceMembers" m ON m.workspace_id = c.workspace_id
+	UNION ALL
+	SELECT s.category_id, s.user_id, s.role, CASE s.role WHEN 'editor' THEN 2 ELSE 1 END AS rank
+	FROM "CategoryShares" s
+) a
+GROUP BY a.category_id, a.user_id;`
+
+	_, err := tx.Exec(queryStr)
+	return err
+}
+
+// Every update of a task or category increments its version so that clients can detect that they work on an outdated copy
+func migrateVersions(tx *sql.Tx) error {
+	queryStr := `ALTER TABLE "Task" ADD COLUMN IF NOT EXISTS "version" bigint NOT NULL DEFAULT 1;
+ALTER TABLE "Categories" ADD COLUMN IF NOT EXISTS "version" bigint NOT NULL DEFAULT 1;`
+
+	_, err := tx.Exec(queryStr)
+	return err
+}
+
+// Replaces the dense "order" columns by rank keys. The existing order is kept, ties are broken by id
+func migrateRanks(tx *sql.Tx) error {
+	queryStr := `ALTER TABLE "Categories" ADD COLUMN IF NOT EXISTS "rank" text COLLATE "C";
+ALTER TABLE "CategoryTasks" ADD COLUMN IF NOT EXISTS "rank" text COLLATE "C";
+ALTER TABLE "Categories" ADD CONSTRAINT "Categories_rank_key" UNIQUE ("workspace_id", "rank") DEFERRABLE INITIALLY IMMEDIATE;
+ALTER TABLE "CategoryTasks" ADD CONSTRAINT "CategoryTasks_rank_key" UNIQUE ("category_id", "rank") DEFERRABLE INITIALLY IMMEDIATE;`
+	_, err := tx.Exec(queryStr)
+	if err != nil {
+		return err
+	}
+
+	for _, list := range []struct {
+		rankedList
+		query string
+	}{
+		{categoryList, `SELECT workspace_id, id FROM "Categories" ORDER BY workspace_id, "order", id`},
+		{taskList, `SELECT category_id, task_id FROM "CategoryTasks" ORDER BY category_id, "order", task_id`},
+	} {
+		scopes := map[int64][]int64{}
+		rows, err := tx.Query(list.query)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var scopeId, id int64
+			err = rows.Scan(&scopeId, &id)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			scopes[scopeId] = append(scopes[scopeId], id)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		for _, ids := range scopes {
+			err = list.assign(tx, ids)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	queryStr = `ALTER TABLE "Categories" ALTER COLUMN "rank" SET NOT NULL;
+ALTER TABLE "CategoryTasks" ALTER COLUMN "rank" SET NOT NULL;
+ALTER TABLE "Categories" DROP COLUMN "order";
+ALTER TABLE "CategoryTasks" DROP COLUMN "order";`
+	_, err = tx.Exec(queryStr)
+	return err
+}
+
+// The indexes match the sort orders and the text filter of QueryTasks. Tasks that existed before get the time of the migration as creation time
+func migrateTaskListing(tx *sql.Tx) error {
+	queryStr := `ALTER TABLE "Task" ADD COLUMN IF NOT EXISTS "created_at" timestamptz NOT NULL DEFAULT now();
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS "Task_created_at_idx" ON "Task" ("created_at", "id");
+CREATE INDEX IF NOT EXISTS "Task_due_idx" ON "Task" (("due" = ''), "due", "id");
+CREATE INDEX IF NOT EXISTS "Task_title_idx" ON "Task" ("title", "id");
+CREATE INDEX IF NOT EXISTS "Task_state_idx" ON "Task" ("state");
+CREATE INDEX IF NOT EXISTS "Task_text_idx" ON "Task" USING gin ((coalesce("title", '') || ' ' || coalesce("details", '')) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS "CategoryTasks_task_id_idx" ON "CategoryTasks" ("task_id");`
+
+	_, err := tx.Exec(queryStr)
+	return err
+}
+
+// The search vector is kept up to date by PostgreSQL. The simple configuration doesn't stem, as titles mix German and English
+func migrateTaskSearch(tx *sql.Tx) error {
+	queryStr := `ALTER TABLE "Task" ADD COLUMN IF NOT EXISTS "search" tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('simple', coalesce("title", '')), 'A') || setweight(to_tsvector('simple', coalesce("details", '')), 'B')
+) STORED;
+CREATE INDEX IF NOT EXISTS "Task_search_idx" ON "Task" USING gin ("search");`
+
+	_, err := tx.Exec(queryStr)
+	return err
+}
+
+// Parses the free-text due dates into timestamps. Dates without a time become all-day dates, times without an offset are taken as UTC,
+// as users had no time zone before. Texts that can't be parsed are appended to the details of the task so that nothing is lost
+func migrateDueDates(tx *sql.Tx) error {
+	queryStr := `ALTER TABLE "User" ADD COLUMN IF NOT EXISTS "timezone" text NOT NULL DEFAULT 'UTC';
+ALTER TABLE "Task" RENAME COLUMN "due" TO "due_text";
+ALTER TABLE "Task" ADD COLUMN "due" timestamptz,
+	ADD COLUMN "all_day" boolean NOT NULL DEFAULT false,
+	ADD COLUMN "due_timezone" text NOT NULL DEFAULT 'UTC';`
+	_, err := tx.Exec(queryStr)
+	if err != nil {
+		return err
+	}
+
+	type legacyDue struct {
+		id   int64
+		text string
+	}
+	var dues []legacyDue
+	rows, err := tx.Query(`SELECT id, due_text FROM "Task" WHERE trim(coalesce(due_text, '')) <> ''`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var due legacyDue
+		err = rows.Scan(&due.id, &due.text)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		dues = append(dues, due)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	unparsed := 0
+	for _, due := range dues {
+		parsed, allDay, ok := parseLegacyDue(due.text)
+		if ok {
+			_, err = tx.Exec(`UPDATE "Task" SET due = $1, all_day = $2 WHERE id = $3`, parsed, allDay, due.id)
+		} else {
+			unparsed++
+			_, err = tx.Exec(`UPDATE "Task" SET details = concat_ws(E'\n', nullif(details, ''), 'Fällig: ' || due_text) WHERE id = $1`, due.id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if unparsed > 0 {
+		log.Printf("%d of %d due dates could not be parsed and were moved to the details of their tasks\n", unparsed, len(dues))
+	}
+
+	queryStr = `ALTER TABLE "Task" DROP COLUMN "due_text";
+CREATE INDEX IF NOT EXISTS "Task_due_idx" ON "Task" (("due" IS NULL), coalesce("due", '-infinity'), "id");`
+	_, err = tx.Exec(queryStr)
+	return err
+}
+
+// A series holds the rule and the template of its occurrences, which are ordinary tasks. Deleting the series keeps the tasks
+func migrateRecurrence(tx *sql.Tx) error {
+	queryStr := `CREATE TABLE IF NOT EXISTS "TaskSeries" (
+	"id" bigserial PRIMARY KEY,
+	"rrule" text NOT NULL,
+	"dtstart" timestamptz NOT NULL,
+	"title" text,
+	"details" text,
+	"all_day" boolean NOT NULL DEFAULT false,
+	"due_timezone" text NOT NULL DEFAULT 'UTC',
+	"occurrences" integer NOT NULL DEFAULT 1,
+	"last_due" timestamptz NOT NULL
+);
+ALTER TABLE "Task" ADD COLUMN IF NOT EXISTS "series_id" bigint REFERENCES "TaskSeries"("id") ON DELETE SET NULL,
+	ADD COLUMN IF NOT EXISTS "occurrence" integer;
+CREATE INDEX IF NOT EXISTS "Task_series_idx" ON "Task" ("series_id") WHERE "series_id" IS NOT NULL;`
+	_, err := tx.Exec(queryStr)
+	return err
+}
+
+// Reminders are computed from the due dates and the offsets of the users. A sent reminder is recorded for the due date it was sent for,
+// so it is not sent again after a restart but is sent again when the due date changes
+func migrateReminders(tx *sql.Tx) error {
+	queryStr := `ALTER TABLE "User" ADD COLUMN IF NOT EXISTS "email" text,
+	ADD COLUMN IF NOT EXISTS "reminder_offsets" integer[] NOT NULL DEFAULT '{60}';
+
+CREATE TABLE IF NOT EXISTS "SentReminders" (
+	"task_id" bigint NOT NULL REFERENCES "Task"("id") ON DELETE CASCADE,
+	"user_id" bigint NOT NULL REFERENCES "User"("id") ON DELETE CASCADE,
+	"offset_minutes" integer NOT NULL,
+	"sent_for_due" timestamptz NOT NULL,
+	"sent_at" timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY ("task_id", "user_id", "offset_minutes", "sent_for_due")
+);
+CREATE INDEX IF NOT EXISTS "SentReminders_due_idx" ON "SentReminders" ("sent_for_due");
+CREATE INDEX IF NOT EXISTS "Task_open_due_idx" ON "Task" ("due") WHERE "due" IS NOT NULL AND "state" <> 1;`
+	_, err := tx.Exec(queryStr)
+	return err
+}
+
+// The numeric states become names, 0 is todo and everything else done as the frontend showed it. The partial index on open tasks
+// depends on the old type and is rebuilt. Tasks that are already done get the migration time as completion time
+func migrateTaskStates(tx *sql.Tx) error {
+	queryStr := `DROP INDEX IF EXISTS "Task_open_due_idx";
+DROP INDEX IF EXISTS "Task_state_idx";
+ALTER TABLE "Task" ALTER COLUMN "state" DROP DEFAULT,
+	ALTER COLUMN "state" TYPE text USING CASE WHEN "state" = 0 THEN 'todo' ELSE 'done' END,
+	ALTER COLUMN "state" SET DEFAULT 'todo',
+	ADD CONSTRAINT "Task_state_check" CHECK ("state" IN ('todo', 'in_progress', 'blocked', 'done', 'cancelled')),
+	ADD COLUMN IF NOT EXISTS "completed_at" timestamptz;
+UPDATE "Task" SET "completed_at" = now() WHERE "state" = 'done';
+ALTER TABLE "Workspace" ADD COLUMN IF NOT EXISTS "state_transitions" jsonb;
+CREATE INDEX IF NOT EXISTS "Task_state_idx" ON "Task" ("state");
+CREATE INDEX IF NOT EXISTS "Task_open_due_idx" ON "Task" ("due") WHERE "due" IS NOT NULL AND "state" NOT IN ('done', 'cancelled');`
+	_, err := tx.Exec(queryStr)
+	return err
+}
+
+// A subtask is a task with a parent task. It is ordered among the subtasks of its parent by the rank of the task and belongs to the category
+// of its parent without a rank there, so it takes no position in the category. Checklist items are ordered among the items of their task
+func migrateSubtasks(tx *sql.Tx) error {
+	queryStr := `ALTER TABLE "Task" ADD COLUMN IF NOT EXISTS "parent_task_id" bigint REFERENCES "Task"("id") ON DELETE CASCADE,
+	ADD COLUMN IF NOT EXISTS "rank" text COLLATE "C",
+	ADD CONSTRAINT "Task_rank_key" UNIQUE ("parent_task_id", "rank") DEFERRABLE INITIALLY IMMEDIATE,
+	ADD CONSTRAINT "Task_parent_check" CHECK (("parent_task_id" IS NULL) = ("rank" IS NULL) AND "parent_task_id" <> "id");
+ALTER TABLE "CategoryTasks" ALTER COLUMN "rank" DROP NOT NULL;
+CREATE TABLE IF NOT EXISTS "ChecklistItems" (
+	"id" bigint GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+	"task_id" bigint NOT NULL REFERENCES "Task"("id") ON DELETE CASCADE,
+	"text" text NOT NULL,
+	"done" boolean NOT NULL DEFAULT false,
+	"rank" text COLLATE "C" NOT NULL,
+	"version" bigint NOT NULL DEFAULT 1,
+	CONSTRAINT "ChecklistItems_rank_key" UNIQUE ("task_id", "rank") DEFERRABLE INITIALLY IMMEDIATE
+);`
+	_, err := tx.Exec(queryStr)
+	return err
+}
+
+// A dependency records that the task can't start before the blocker is closed. Deleting either task removes the dependency
+func migrateDependencies(tx *sql.Tx) error {
+	queryStr := `CREATE TABLE IF NOT EXISTS "TaskDependencies" (
+	"task_id" bigint NOT NULL REFERENCES "Task"("id") ON DELETE CASCADE,
+	"blocker_id" bigint NOT NULL REFERENCES "Task"("id") ON DELETE CASCADE,
+	"created_at" timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY ("task_id", "blocker_id"),
+	CHECK ("task_id" <> "blocker_id")
+);
+CREATE INDEX IF NOT EXISTS "TaskDependencies_blocker_id_idx" ON "TaskDependencies" ("blocker_id");`
+	_, err := tx.Exec(queryStr)
+	return err
+}
+
+// A label belongs either to a workspace or to a single user. Its name is unique within that scope regardless of case
+func migrateLabels(tx *sql.Tx) error {
+	queryStr := `CREATE TABLE IF NOT EXISTS "Labels" (
+	"id" bigint GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+	"name" text NOT NULL,
+	"color" text NOT NULL CHECK ("color" ~ '^#[0-9a-f]{6}$'),
+	"workspace_id" bigint REFERENCES "Workspace"("id") ON DELETE CASCADE,
+	"user_id" bigint REFERENCES "User"("id") ON DELETE CASCADE,
+	"version" bigint NOT NULL DEFAULT 1,
+	CHECK (("workspace_id" IS NULL) <> ("user_id" IS NULL))
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "Labels_workspace_name_key" ON "Labels" ("workspace_id", lower("name")) WHERE "workspace_id" IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS "Labels_user_name_key" ON "Labels" ("user_id", lower("name")) WHERE "user_id" IS NOT NULL;
+CREATE TABLE IF NOT EXISTS "TaskLabels" (
+	"task_id" bigint NOT NULL REFERENCES "Task"("id") ON DELETE CASCADE,
+	"label_id" bigint NOT NULL REFERENCES "Labels"("id") ON DELETE CASCADE,
+	PRIMARY KEY ("task_id", "label_id")
+);
+CREATE INDEX IF NOT EXISTS "TaskLabels_label_id_idx" ON "TaskLabels" ("label_id");`
+	_, err := tx.Exec(queryStr)
+	return err
+}
+
+func migrateTaskPriority(tx *sql.Tx) error {
+	queryStr := `ALTER TABLE "Task" ADD COLUMN IF NOT EXISTS "priority" smallint NOT NULL DEFAULT 0 CHECK ("priority" BETWEEN 0 AND 4);`
+	_, err := tx.Exec(queryStr)
+	return err
+}
+
+// The activity of a task outlives the user who caused it
+func migrateAssignees(tx *sql.Tx) error {
+	queryStr := `CREATE TABLE IF NOT EXISTS "TaskAssignees" (
+	"task_id" bigint NOT NULL REFERENCES "Task"("id") ON DELETE CASCADE,
+	"user_id" bigint NOT NULL REFERENCES "User"("id") ON DELETE CASCADE,
+	"assigned_at" timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY ("task_id", "user_id")
+);
+CREATE INDEX IF NOT EXISTS "TaskAssignees_user_id_idx" ON "TaskAssignees" ("user_id");
+CREATE TABLE IF NOT EXISTS "TaskActivity" (
+	"id" bigint GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+	"task_id" bigint NOT NULL REFERENCES "Task"("id") ON DELETE CASCADE,
+	"user_id" bigint REFERENCES "User"("id") ON DELETE SET NULL,
+	"action" text NOT NULL,
+	"details" jsonb NOT NULL DEFAULT '{}',
+	"created_at" timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS "TaskActivity_task_id_idx" ON "TaskActivity" ("task_id", "id");`
+	_, err := tx.Exec(queryStr)
+	return err
+}
+
+// Replies are deleted with the comment they answer, comments of deleted users are kept without author
+func migrateComments(tx *sql.Tx) error {
+	queryStr := `CREATE TABLE IF NOT EXISTS "TaskComments" (
+	"id" bigint GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+	"task_id" bigint NOT NULL REFERENCES "Task"("id") ON DELETE CASCADE,
+	"parent_id" bigint REFERENCES "TaskComments"("id") ON DELETE CASCADE,
+	"user_id" bigint REFERENCES "User"("id") ON DELETE SET NULL,
+	"body" text NOT NULL,
+	"created_at" timestamptz NOT NULL DEFAULT now(),
+	"edited_at" timestamptz,
+	"deleted_at" timestamptz,
+	"version" bigint NOT NULL DEFAULT 1
+);
+CREATE INDEX IF NOT EXISTS "TaskComments_task_id_idx" ON "TaskComments" ("task_id", "created_at");
+CREATE INDEX IF NOT EXISTS "TaskComments_parent_id_idx" ON "TaskComments" ("parent_id");`
+	_, err := tx.Exec(queryStr)
+	return err
+}
+
+// Tasks are linked to their category only through "CategoryTasks", so deleting a category or workspace used to leave its tasks behind.
+// A trigger now deletes a task together with its last category. Another one queues the blob of every deleted attachment, however it was
+// deleted, so that a job can remove it from the blob store
+func migrateAttachments(tx *sql.Tx) error {
+	queryStr := `CREATE TABLE IF NOT EXISTS "Attachments" (
+	"id" bigint GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+	"task_id" bigint NOT NULL REFERENCES "Task"("id") ON DELETE CASCADE,
+	"filename" text NOT NULL,
+	"content_type" text NOT NULL,
+	"size" bigint NOT NULL,
+	"storage_key" text NOT NULL UNIQUE,
+	"user_id" bigint REFERENCES "User"("id") ON DELETE SET NULL,
+	"created_at" timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS "Attachments_task_id_idx" ON "Attachments" ("task_id");
+CREATE TABLE IF NOT EXISTS "OrphanedBlobs" (
+	"storage_key" text PRIMARY KEY,
+	"deleted_at" timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE OR REPLACE FUNCTION "queue_attachment_blob"() RETURNS trigger AS $$
+BEGIN
+	INSERT INTO "OrphanedBlobs" ("storage_key") VALUES (OLD."storage_key") ON CONFLICT DO NOTHING;
+	RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE TRIGGER "Attachments_deleted" AFTER DELETE ON "Attachments"
+	FOR EACH ROW EXECUTE FUNCTION "queue_attachment_blob"();
+
+CREATE OR REPLACE FUNCTION "delete_uncategorized_task"() RETURNS trigger AS $$
+BEGIN
+	DELETE FROM "Task" t WHERE t.id = OLD."task_id" AND NOT EXISTS (SELECT 1 FROM "CategoryTasks" a WHERE a."task_id" = t.id);
+	RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE TRIGGER "CategoryTasks_deleted" AFTER DELETE ON "CategoryTasks"
+	FOR EACH ROW EXECUTE FUNCTION "delete_uncategorized_task"();`
+	_, err := tx.Exec(queryStr)
+	return err
+}
+
+// The audit log keeps the history of deleted entities and the names of deleted users, so it has no foreign keys. A trigger rejects
+// updates to make it append-only, only the retention job deletes entries. The activity of tasks is moved into it
+func migrateAuditLog(tx *sql.Tx) error {
+	queryStr := `CREATE TABLE IF NOT EXISTS "AuditLog" (
+	"id" bigint GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+	"entity" text NOT NULL CHECK ("entity" IN ('task', 'category')),
+	"entity_id" bigint NOT NULL,
+	"action" text NOT NULL,
+	"actor" text NOT NULL,
+	"changes" jsonb NOT NULL DEFAULT '{}',
+	"created_at" timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS "AuditLog_entity_idx" ON "AuditLog" ("entity", "entity_id", "id");
+CREATE INDEX IF NOT EXISTS "AuditLog_created_at_idx" ON "AuditLog" ("created_at");
+
+CREATE OR REPLACE FUNCTION "reject_audit_update"() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'the audit log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE TRIGGER "AuditLog_updated" BEFORE UPDATE ON "AuditLog"
+	FOR EACH STATEMENT EXECUTE FUNCTION "reject_audit_update"();
+
+INSERT INTO "AuditLog" ("entity", "entity_id", "action", "actor", "changes", "created_at")
+SELECT 'task', e."task_id", e."action", coalesce(u."username", ''),
+	jsonb_build_object('assignee', CASE WHEN e."action" = 'assigned'
+		THEN jsonb_build_object('before', NULL, 'after', e."details")
+		ELSE jsonb_build_object('before', e."details", 'after', NULL) END),
+	e."created_at"
+FROM "TaskActivity" e LEFT JOIN "User" u ON u."id" = e."user_id"
+ORDER BY e."id";
+DROP TABLE "TaskActivity";`
+	_, err := tx.Exec(queryStr)
+	return err
+}
+
+// A deleted task or category keeps its row with deleted_at set until it is purged. Tasks deleted together with their category or parent
+// get the same deleted_at. The task or category that was deleted itself moves its rank key, for a task the one in "CategoryTasks" or
+// of the subtask, to deleted_rank. That takes it out of its list, so that it neither counts for positions nor blocks the key, and lets
+// it return to its position when it is restored
+func migrateSoftDelete(tx *sql.Tx) error {
+	queryStr := `ALTER TABLE "Task" ADD COLUMN IF NOT EXISTS "deleted_at" timestamptz,
+	ADD COLUMN IF NOT EXISTS "deleted_rank" text COLLATE "C",
+	DROP CONSTRAINT IF EXISTS "Task_parent_check",
+	ADD CONSTRAINT "Task_parent_check" CHECK ("parent_task_id" <> "id" AND ("rank" IS NULL OR "parent_task_id" IS NOT NULL)
+		AND ("rank" IS NOT NULL OR "parent_task_id" IS NULL OR "deleted_at" IS NOT NULL));
+ALTER TABLE "Categories" ADD COLUMN IF NOT EXISTS "deleted_at" timestamptz,
+	ADD COLUMN IF NOT EXISTS "deleted_rank" text COLLATE "C",
+	ALTER COLUMN "rank" DROP NOT NULL;
+CREATE INDEX IF NOT EXISTS "Task_deleted_at_idx" ON "Task" ("deleted_at") WHERE "deleted_at" IS NOT NULL;
+CREATE INDEX IF NOT EXISTS "Categories_deleted_at_idx" ON "Categories" ("deleted_at") WHERE "deleted_at" IS NOT NULL;`
+	_, err := tx.Exec(queryStr)
+	return err
+}
+
+func migrateArchive(tx *sql.Tx) error {
+	queryStr := `ALTER TABLE "Task" ADD COLUMN IF NOT EXISTS "archived_at" timestamptz,
+	ADD COLUMN IF NOT EXISTS "archived_rank" text COLLATE "C";
+ALTER TABLE "Categories" ADD COLUMN IF NOT EXISTS "archived_at" timestamptz,
+	ADD COLUMN IF NOT EXISTS "archived_rank" text COLLATE "C";
+ALTER TABLE "User" ADD COLUMN IF NOT EXISTS "auto_archive_days" integer NOT NULL DEFAULT 0 CHECK ("auto_archive_days" >= 0);
+CREATE INDEX IF NOT EXISTS "Task_archived_at_idx" ON "Task" ("archived_at", "id") WHERE "archived_at" IS NOT NULL;
+CREATE INDEX IF NOT EXISTS "Categories_archived_at_idx" ON "Categories" ("archived_at", "id") WHERE "archived_at" IS NOT NULL;
+CREATE INDEX IF NOT EXISTS "Task_completed_at_idx" ON "Task" ("completed_at")
+	WHERE "state" = 'done' AND "archived_at" IS NULL AND "parent_task_id" IS NULL;`
+	_, err := tx.Exec(queryStr)
+	return err
+}
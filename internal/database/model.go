@@ -1,13 +1,42 @@
 package database
 
+import "time"
+
+// Order is the 1-based position of the task in its category and derived from the rank key. Clients send the position they want a task at.
+// Due is null if the task has no due date. An all-day due date is midnight in Timezone, the IANA time zone due dates of the task are shown in.
+// A recurring task is an occurrence of the series Series_id, which repeats by the RRULE Recurrence. Occurrence counts the occurrences of the series from 1.
+// Completed_at is the time the task was last marked as done and null while it is not done.
+// Priority is one of none, low, medium, high and urgent.
+// Comment_count counts the comments on the task that are not deleted.
+// Archived_at is the time the task was archived and null while it is not archived. An archived task has no position, its Order is 0.
+// A subtask has the id of its parent task in Parent_task_id and its Order is its position among the subtasks of the parent. Tasks returned with
+// their subtasks carry them in Subtasks together with their checklist and the progress of both
 type Task struct {
-	Id         int64  `json:"id"`
-	Belongs_to int64  `json:"belongs_to"`
-	Order      int64  `json:"order"`
-	Title      string `json:"title"`
-	Details    string `json:"details"`
-	State      int64  `json:"state"`
-	Due        string `json:"due"`
+	Id             int64           `json:"id"`
+	Belongs_to     int64           `json:"belongs_to"`
+	Order          int64           `json:"order"`
+	Rank           string          `json:"rank"`
+	Title          string          `json:"title"`
+	Details        string          `json:"details"`
+	State          TaskState       `json:"state"`
+	Priority       TaskPriority    `json:"priority"`
+	Due            *time.Time      `json:"due"`
+	All_day        bool            `json:"all_day"`
+	Timezone       string          `json:"timezone"`
+	Version        int64           `json:"version"`
+	Created_at     time.Time       `json:"created_at"`
+	Series_id      *int64          `json:"series_id"`
+	Occurrence     int64           `json:"occurrence,omitempty"`
+	Recurrence     string          `json:"recurrence"`
+	Completed_at   *time.Time      `json:"completed_at"`
+	Archived_at    *time.Time      `json:"archived_at"`
+	Parent_task_id *int64          `json:"parent_task_id"`
+	Labels         []TaskLabel     `json:"labels"`
+	Assignees      []TaskAssignee  `json:"assignees"`
+	Comment_count  int64           `json:"comment_count"`
+	Subtasks       []Task          `json:"subtasks,omitempty"`
+	Checklist      []ChecklistItem `json:"checklist,omitempty"`
+	Progress       *Progress       `json:"progress,omitempty"`
 }
 
 type User struct {
@@ -16,9 +45,62 @@ type User struct {
 	Password string `json:"password" binding:"min=2,required"`
 }
 
+// Order is the 1-based position of the category in its workspace and derived from the rank key. Archived_at is the time the category
+// was archived and null while it is not archived
 type Categories struct {
-	Id         int64  `json:"id"`
-	Belongs_to int64  `json:"belongs_to"`
-	Name       string `json:"name"`
-	Order      int64  `json:"order"`
+	Id           int64      `json:"id"`
+	Belongs_to   int64      `json:"belongs_to"`
+	Workspace_id int64      `json:"workspace_id"`
+	Name         string     `json:"name"`
+	Order        int64      `json:"order"`
+	Rank         string     `json:"rank"`
+	Version      int64      `json:"version"`
+	Archived_at  *time.Time `json:"archived_at"`
+}
+
+// Roles of a user in a workspace, ordered from most to least privileged
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+type Workspace struct {
+	Id       int64  `json:"id"`
+	Name     string `json:"name" binding:"max=100"`
+	Personal bool   `json:"personal"`
+	Role     string `json:"role"`
+}
+
+type WorkspaceMember struct {
+	Workspace_id int64  `json:"workspace_id"`
+	User_id      int64  `json:"user_id"`
+	Username     string `json:"username"`
+	Role         string `json:"role"`
+}
+
+type WorkspaceInvitation struct {
+	Id             int64     `json:"id"`
+	Workspace_id   int64     `json:"workspace_id"`
+	Workspace_name string    `json:"workspace_name"`
+	Username       string    `json:"username"`
+	Invited_by     string    `json:"invited_by"`
+	Role           string    `json:"role"`
+	Created_at     time.Time `json:"created_at"`
+}
+
+// An invitation link that grants a role on a category to everyone redeeming it. The token is only known when the invitation is created, the database stores its hash
+type CategoryInvitation struct {
+	Id               int64     `json:"id"`
+	Category_id      int64     `json:"category_id"`
+	Role             string    `json:"role"`
+	Token            string    `json:"token,omitempty"`
+	Url              string    `json:"url,omitempty"`
+	Created_by       string    `json:"created_by"`
+	Created_at       time.Time `json:"created_at"`
+	Expires_at       time.Time `json:"expires_at"`
+	Expires_in_hours int64     `json:"expires_in_hours,omitempty"`
+	Single_use       bool      `json:"single_use"`
+	Use_count        int64     `json:"use_count"`
+	Revoked          bool      `json:"revoked"`
 }
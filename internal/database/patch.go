@@ -0,0 +1,221 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrNullField error = errors.New("field cannot be null")
+
+// Optional is a field of a partial update. Set records whether the field was present in the JSON body at all and Null whether it was explicitly null
+type Optional[T any] struct {
+	Set   bool
+	Null  bool
+	Value T
+}
+
+func (o *Optional[T]) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if string(data) == "null" {
+		o.Null = true
+		return nil
+	}
+	return json.Unmarshal(data, &o.Value)
+}
+
+// Returns the value of the field, or the zero value if it was null
+func (o Optional[T]) Get() T {
+	var zero T
+	if o.Null {
+		return zero
+	}
+	return o.Value
+}
+
+// A partial update of a task. Absent fields are left untouched, null clears title, details and due. belongs_to and order move the task.
+// A version other than 0 makes the update conditional on the stored version. The due date fields are applied by PatchTask as a whole, see DueFields.
+// The recurrence belongs to the series of the task and is applied by the service, as is a Scope of ScopeSeries
+type TaskPatch struct {
+	Title      Optional[string]       `json:"title"`
+	Details    Optional[string]       `json:"details"`
+	State      Optional[TaskState]    `json:"state"`
+	Priority   Optional[TaskPriority] `json:"priority"`
+	Due        Optional[time.Time]    `json:"due"`
+	All_day    Optional[bool]         `json:"all_day"`
+	Timezone   Optional[string]       `json:"timezone"`
+	Belongs_to Optional[int64]        `json:"belongs_to"`
+	Order      Optional[int64]        `json:"order"`
+	Recurrence Optional[string]       `json:"recurrence"`
+	Version    int64                  `json:"version"`
+	Scope      string                 `json:"-"`
+}
+
+// Returns an error wrapping ErrNullField if a field that cannot be cleared is null
+func (p TaskPatch) Validate() error {
+	for name, null := range map[string]bool{"state": p.State.Null, "priority": p.Priority.Null, "belongs_to": p.Belongs_to.Null, "order": p.Order.Null, "all_day": p.All_day.Null} {
+		if null {
+			return fmt.Errorf("%s: %w", name, ErrNullField)
+		}
+	}
+	if p.State.Set && !p.State.Value.Valid() {
+		return fmt.Errorf("%w: %q", ErrInvalidState, p.State.Value)
+	}
+	if p.Timezone.Set && !p.Timezone.Null && !ValidTimezone(p.Timezone.Value) {
+		return fmt.Errorf("%w: %s", ErrInvalidTimezone, p.Timezone.Value)
+	}
+	if p.Recurrence.Get() != "" {
+		_, err := parseRecurrence(p.Recurrence.Value)
+		return err
+	}
+	return nil
+}
+
+// Reports whether the patch changes one of the due date fields
+func (p TaskPatch) DueFields() bool {
+	return p.Due.Set || p.All_day.Set || p.Timezone.Set
+}
+
+// Applies the due date fields of the patch to the task. A null time zone resets it to the one of the user
+func (p TaskPatch) ApplyDue(task *Task) {
+	if p.Due.Set {
+		task.Due = nil
+		if !p.Due.Null {
+			due := p.Due.Value
+			task.Due = &due
+		}
+	}
+	if p.All_day.Set {
+		task.All_day = p.All_day.Value
+	}
+	if p.Timezone.Set {
+		task.Timezone = p.Timezone.Get()
+	}
+}
+
+// Sets all due date fields of the patch to the ones of the task, which already has the patch applied and is normalized
+func (p *TaskPatch) CompleteDue(task Task) {
+	p.Due = Optional[time.Time]{Set: true, Null: task.Due == nil}
+	if task.Due != nil {
+		p.Due.Value = *task.Due
+	}
+	p.All_day = Optional[bool]{Set: true, Value: task.All_day}
+	p.Timezone = Optional[string]{Set: true, Value: task.Timezone}
+}
+
+// Reports whether the patch moves the task to another category or position
+func (p TaskPatch) Moves() bool {
+	return p.Belongs_to.Set || p.Order.Set
+}
+
+// A partial update of a category. null clears the name, order moves the category
+type CategoryPatch struct {
+	Name    Optional[string] `json:"name"`
+	Order   Optional[int64]  `json:"order"`
+	Version int64            `json:"version"`
+}
+
+func (p CategoryPatch) Validate() error {
+	if p.Order.Null {
+		return fmt.Errorf("order: %w", ErrNullField)
+	}
+	return nil
+}
+
+// A partial update of a checklist item. null clears the text, order moves the item within its checklist
+type ChecklistPatch struct {
+	Text    Optional[string] `json:"text"`
+	Done    Optional[bool]   `json:"done"`
+	Order   Optional[int64]  `json:"order"`
+	Version int64            `json:"version"`
+}
+
+func (p ChecklistPatch) Validate() error {
+	for name, null := range map[string]bool{"done": p.Done.Null, "order": p.Order.Null} {
+		if null {
+			return fmt.Errorf("%s: %w", name, ErrNullField)
+		}
+	}
+	return nil
+}
+
+// Collects the assignments of an UPDATE statement for the fields that are set
+type updateBuilder struct {
+	columns []string
+	args    []any
+}
+
+func (b *updateBuilder) set(column string, value any) {
+	b.args = append(b.args, value)
+	b.columns = append(b.columns, fmt.Sprintf("%s = $%d", column, len(b.args)))
+}
+
+// Builds the statement for the table. It increments the version of the row and only matches if the stored version equals the expected one, unless that is 0
+func (b *updateBuilder) query(table string, id int64, version int64) (string, []any) {
+	args := append(b.args, id, version)
+	return fmt.Sprintf(`UPDATE "%s" SET %s, version = version + 1 WHERE id = $%d AND ($%d = 0 OR version = $%d)`,
+		table, strings.Join(b.columns, ", "), len(args)-1, len(args), len(args)), args
+}
+
+// Runs the statement and tells apart a missing row from a version conflict if no row was affected
+func (b *updateBuilder) exec(table string, id int64, version int64) error {
+	query, args := b.query(table, id, version)
+	result, err := dbInstance.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", table, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return missingOrConflict(dbInstance.db, table, id)
+	}
+	return nil
+}
+
+// Updates only the fields of the task that are set in the patch in a single statement, so concurrent changes to other fields are kept.
+// Returns ErrNoResult if the task does not exist and ErrVersionConflict if the patch is based on an outdated version
+func PatchTask(taskId int64, patch TaskPatch) error {
+	var b updateBuilder
+	if patch.Title.Set {
+		b.set("title", patch.Title.Get())
+	}
+	if patch.Details.Set {
+		b.set("details", patch.Details.Get())
+	}
+	if patch.State.Set {
+		b.setState(patch.State.Get())
+	}
+	if patch.Priority.Set {
+		b.set("priority", patch.Priority.Get())
+	}
+	// The due date fields depend on each other, so they are always written together. The caller has to complete them with CompleteDue
+	if patch.DueFields() {
+		var due *time.Time
+		if patch.Due.Set && !patch.Due.Null {
+			due = &patch.Due.Value
+		}
+		b.set("due", due)
+		b.set("all_day", patch.All_day.Value)
+		b.set("due_timezone", patch.Timezone.Value)
+	}
+	if len(b.columns) == 0 {
+		return nil
+	}
+	return b.exec("Task", taskId, patch.Version)
+}
+
+// Updates only the fields of the category that are set in the patch. Returns ErrNoResult if the category does not exist and ErrVersionConflict if the patch is based on an outdated version
+func PatchCategory(categoryId int64, patch CategoryPatch) error {
+	var b updateBuilder
+	if patch.Name.Set {
+		b.set("name", patch.Name.Get())
+	}
+	if len(b.columns) == 0 {
+		return nil
+	}
+	return b.exec("Categories", categoryId, patch.Version)
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var ErrInvalidPriority error = errors.New("invalid task priority")
+
+// The priority of a task. It is stored as a number from 0 for none to 4 for urgent, so that it sorts by urgency, and sent by its name
+type TaskPriority int16
+
+const (
+	PriorityNone TaskPriority = iota
+	PriorityLow
+	PriorityMedium
+	PriorityHigh
+	PriorityUrgent
+)
+
+// The names of the priorities, indexed by their value
+var priorityNames = []string{"none", "low", "medium", "high", "urgent"}
+
+func (p TaskPriority) Valid() bool {
+	return p >= PriorityNone && p <= PriorityUrgent
+}
+
+func (p TaskPriority) String() string {
+	if !p.Valid() {
+		return fmt.Sprintf("TaskPriority(%d)", int16(p))
+	}
+	return priorityNames[p]
+}
+
+func (p TaskPriority) MarshalJSON() ([]byte, error) {
+	if !p.Valid() {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidPriority, int16(p))
+	}
+	return json.Marshal(priorityNames[p])
+}
+
+// Accepts the names of the priorities only
+func (p *TaskPriority) UnmarshalJSON(data []byte) error {
+	var name string
+	err := json.Unmarshal(data, &name)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidPriority, data)
+	}
+	i := slices.Index(priorityNames, name)
+	if i < 0 {
+		return fmt.Errorf("%w: %q", ErrInvalidPriority, name)
+	}
+	*p = TaskPriority(i)
+	return nil
+}
+
+func (p TaskPriority) Value() (driver.Value, error) {
+	return int64(p), nil
+}
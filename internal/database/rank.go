@@ -0,0 +1,339 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"todolist/internal/rank"
+)
+
+// A list of rows ordered by their rank keys. scope is the column shared by all rows of one list and parent the table the scope references.
+// Rows without a rank are not part of any list, like the category rows of subtasks. missing selects the rows that lost their key although
+// they belong to the list and stray the rows that have a key although they don't, both found by CheckRanks and fixed by rebalancing
+type rankedList struct {
+	table   string
+	id      string
+	scope   string
+	parent  string
+	missing string
+	stray   string
+}
+
+var (
+	categoryList = rankedList{table: "Categories", id: "id", scope: "workspace_id", parent: "Workspace",
+		missing: `rank IS NULL AND deleted_rank IS NULL AND archived_rank IS NULL`}
+	taskList = rankedList{table: "CategoryTasks", id: "task_id", scope: "category_id", parent: "Categories",
+		missing: `rank IS NULL AND task_id IN (SELECT id FROM "Task" WHERE parent_task_id IS NULL AND deleted_rank IS NULL AND archived_rank IS NULL)`,
+		stray:   `rank IS NOT NULL AND task_id IN (SELECT id FROM "Task" WHERE parent_task_id IS NOT NULL)`}
+	subtaskList   = rankedList{table: "Task", id: "id", scope: "parent_task_id", parent: "Task"}
+	checklistList = rankedList{table: "ChecklistItems", id: "id", scope: "task_id", parent: "Task"}
+)
+
+var rankedLists = []rankedList{categoryList, taskList, subtaskList, checklistList}
+
+// Locks the parent row of the list until the end of the transaction, so that concurrent insertions and moves into the list don't compute the same key
+func (l rankedList) lock(tx *sql.Tx, scopeId int64) error {
+	var id int64
+	err := tx.QueryRow(fmt.Sprintf(`SELECT id FROM "%s" WHERE id = $1 FOR NO KEY UPDATE`, l.parent), scopeId).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoResult
+		}
+		return fmt.Errorf("query error: %v", err)
+	}
+	return nil
+}
+
+// Returns a key that places a row at the 1-based position in the list. The row exclude, if it already is in the list, is not counted
+func (l rankedList) keyAt(q querier, scopeId int64, position int64, exclude int64) (string, error) {
+	query := fmt.Sprintf(`SELECT rank FROM "%s" WHERE %s = $1 AND %s <> $2 AND rank IS NOT NULL ORDER BY rank LIMIT 2 OFFSET $3`, l.table, l.scope, l.id)
+	keys, err := queryStrings(q, query, scopeId, exclude, max(position-2, 0))
+	if err != nil {
+		return "", err
+	}
+
+	var before, after string
+	switch {
+	case position <= 1:
+		if len(keys) > 0 {
+			after = keys[0]
+		}
+	case len(keys) == 0:
+		// The position is behind the end of the list
+		query = fmt.Sprintf(`SELECT rank FROM "%s" WHERE %s = $1 AND %s <> $2 AND rank IS NOT NULL ORDER BY rank DESC LIMIT 1`, l.table, l.scope, l.id)
+		last, err := queryStrings(q, query, scopeId, exclude)
+		if err != nil {
+			return "", err
+		}
+		if len(last) > 0 {
+			before = last[0]
+		}
+	default:
+		before = keys[0]
+		if len(keys) > 1 {
+			after = keys[1]
+		}
+	}
+	key, err := rank.Between(before, after)
+	if err != nil {
+		return "", fmt.Errorf("failed to compute rank between %q and %q in %s %d: %w", before, after, l.scope, scopeId, err)
+	}
+	return key, nil
+}
+
+// Returns the key for a row returning to the list with the key it had before it left. The key is kept unless another row took it in the
+// meantime, then the row is placed right behind that one. Either way it returns to its old position among the rows that were there before
+func (l rankedList) restoreKey(q querier, scopeId int64, key string) (string, error) {
+	query := fmt.Sprintf(`SELECT rank FROM "%s" WHERE %s = $1 AND rank >= $2 ORDER BY rank LIMIT 2`, l.table, l.scope)
+	keys, err := queryStrings(q, query, scopeId, key)
+	if err != nil {
+		return "", err
+	}
+	if len(keys) == 0 || keys[0] != key {
+		return key, nil
+	}
+	var after string
+	if len(keys) > 1 {
+		after = keys[1]
+	}
+	key, err = rank.Between(key, after)
+	if err != nil {
+		return "", fmt.Errorf("failed to compute rank behind %q in %s %d: %w", keys[0], l.scope, scopeId, err)
+	}
+	return key, nil
+}
+
+// Assigns evenly spread keys to the rows in the given order. The unique constraint on the keys is deferred, as old and new keys may collide until all rows are updated
+func (l rankedList) assign(tx *sql.Tx, ids []int64) error {
+	_, err := tx.Exec(fmt.Sprintf(`SET CONSTRAINTS "%s_rank_key" DEFERRED`, l.table))
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`UPDATE "%s" SET rank = $1 WHERE %s = $2`, l.table, l.id)
+	for i, key := range rank.Spread(len(ids)) {
+		_, err = tx.Exec(query, key, ids[i])
+		if err != nil {
+			return fmt.Errorf("failed to update rank: %v", err)
+		}
+	}
+	return nil
+}
+
+// Spreads the keys of one list evenly again without changing the order of its rows. Stray rows leave the list and rows missing their key
+// are appended to it
+func (l rankedList) rebalance(tx *sql.Tx, scopeId int64) error {
+	err := l.lock(tx, scopeId)
+	if err != nil {
+		return err
+	}
+	if l.stray != "" {
+		_, err = tx.Exec(fmt.Sprintf(`UPDATE "%s" SET rank = NULL WHERE %s = $1 AND %s`, l.table, l.scope, l.stray), scopeId)
+		if err != nil {
+			return fmt.Errorf("failed to remove stray rows: %v", err)
+		}
+	}
+	members := "rank IS NOT NULL"
+	if l.missing != "" {
+		members = fmt.Sprintf("(rank IS NOT NULL OR %s)", l.missing)
+	}
+	query := fmt.Sprintf(`SELECT %s FROM "%s" WHERE %s = $1 AND %s ORDER BY rank NULLS LAST, %s`, l.id, l.table, l.scope, members, l.id)
+	ids, err := queryIds(tx, query, scopeId)
+	if err != nil {
+		return err
+	}
+	return l.assign(tx, ids)
+}
+
+// Rebalances every list of this kind containing a key longer than maxLength, each in its own transaction. Returns the number of rebalanced lists
+func (l rankedList) rebalanceLong(maxLength int) (int, error) {
+	query := fmt.Sprintf(`SELECT DISTINCT %s FROM "%s" WHERE length(rank) > $1`, l.scope, l.table)
+	scopes, err := queryIds(dbInstance.db, query, maxLength)
+	if err != nil {
+		return 0, err
+	}
+	for i, scopeId := range scopes {
+		tx, err := dbInstance.db.Begin()
+		if err != nil {
+			return i, err
+		}
+		err = l.rebalance(tx, scopeId)
+		if err != nil {
+			tx.Rollback()
+			return i, fmt.Errorf("failed to rebalance %s %d: %w", l.scope, scopeId, err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(scopes), nil
+}
+
+// Spreads the keys of all lists that contain keys longer than maxLength. Returns the number of rebalanced lists
+func RebalanceRanks(maxLength int) (int, error) {
+	var total int
+	for _, l := range rankedLists {
+		n, err := l.rebalanceLong(maxLength)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// A problem with the ordering of one row found by CheckRanks
+type RankIssue struct {
+	Table    string `json:"table"`
+	Scope    string `json:"scope"`
+	Scope_id int64  `json:"scope_id"`
+	Id       int64  `json:"id"`
+	Problem  string `json:"problem"`
+}
+
+// Reports whether rebalancing the list of the row fixes the issue
+func (i RankIssue) Repairable() bool {
+	return i.Scope_id != 0
+}
+
+// Finds duplicate, malformed and overly long keys in every list of this kind
+func (l rankedList) check(q querier) ([]RankIssue, error) {
+	query := fmt.Sprintf(`SELECT %s, %s, rank FROM "%s" WHERE rank IS NOT NULL ORDER BY %s, rank, %s`, l.scope, l.id, l.table, l.scope, l.id)
+	rows, err := q.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	var issues []RankIssue
+	var previousScope int64
+	var previousKey string
+	for rows.Next() {
+		var scopeId, id int64
+		var key string
+		err = rows.Scan(&scopeId, &id, &key)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		issue := RankIssue{Table: l.table, Scope: l.scope, Scope_id: scopeId, Id: id}
+		switch {
+		case scopeId == previousScope && key == previousKey:
+			issue.Problem = fmt.Sprintf("duplicate rank %q", key)
+		case !rank.Valid(key):
+			issue.Problem = fmt.Sprintf("malformed rank %q", key)
+		case len(key) > rank.MaxLength:
+			issue.Problem = fmt.Sprintf("rank longer than %d characters", rank.MaxLength)
+		}
+		if issue.Problem != "" {
+			issues = append(issues, issue)
+		}
+		previousScope, previousKey = scopeId, key
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+
+	for _, misplaced := range []struct{ condition, problem string }{
+		{l.missing, "row of the list has no rank"},
+		{l.stray, "row outside of the list has a rank"},
+	} {
+		if misplaced.condition == "" {
+			continue
+		}
+		query = fmt.Sprintf(`SELECT %s, %s FROM "%s" WHERE %s ORDER BY %s, %s`, l.scope, l.id, l.table, misplaced.condition, l.scope, l.id)
+		rows, err := q.Query(query)
+		if err != nil {
+			return nil, fmt.Errorf("query error: %v", err)
+		}
+		for rows.Next() {
+			issue := RankIssue{Table: l.table, Scope: l.scope, Problem: misplaced.problem}
+			err = rows.Scan(&issue.Scope_id, &issue.Id)
+			if err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("scan error: %v", err)
+			}
+			issues = append(issues, issue)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, fmt.Errorf("rows error: %v", err)
+		}
+	}
+	return issues, nil
+}
+
+// Checks the ordering of all categories, tasks, subtasks and checklist items. Besides the keys of every list and the rows missing from or
+// straying into a list it finds tasks that are in no category, which can't be shown anywhere, tasks that are in several categories, which
+// are ordered in more than one list, and subtasks in another category than their parent, which are ordered in another scope than its
+// subtasks and moved by another owner's operations
+func CheckRanks() ([]RankIssue, error) {
+	var issues []RankIssue
+	for _, l := range rankedLists {
+		listIssues, err := l.check(dbInstance.db)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, listIssues...)
+	}
+
+	query := `SELECT t.id FROM "Task" t WHERE NOT EXISTS (SELECT 1 FROM "CategoryTasks" a WHERE a.task_id = t.id) ORDER BY t.id`
+	orphans, err := queryIds(dbInstance.db, query)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range orphans {
+		issues = append(issues, RankIssue{Table: "Task", Id: id, Problem: "task is in no category"})
+	}
+
+	query = `SELECT task_id FROM "CategoryTasks" GROUP BY task_id HAVING count(*) > 1 ORDER BY task_id`
+	duplicates, err := queryIds(dbInstance.db, query)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range duplicates {
+		issues = append(issues, RankIssue{Table: "CategoryTasks", Id: id, Problem: "task is in more than one category"})
+	}
+
+	query = `
+	SELECT t.id FROM "Task" t JOIN "CategoryTasks" a ON a.task_id = t.id JOIN "CategoryTasks" pa ON pa.task_id = t.parent_task_id
+	WHERE a.category_id <> pa.category_id ORDER BY t.id`
+	strays, err := queryIds(dbInstance.db, query)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range strays {
+		issues = append(issues, RankIssue{Table: "CategoryTasks", Id: id, Problem: "subtask is in another category than its parent"})
+	}
+	return issues, nil
+}
+
+// Rebalances every list that contains one of the repairable issues. Returns the number of rebalanced lists
+func RepairRanks(issues []RankIssue) (int, error) {
+	lists := make(map[string]rankedList)
+	for _, l := range rankedLists {
+		lists[l.table] = l
+	}
+	repaired := map[RankIssue]bool{}
+	for _, issue := range issues {
+		list, ok := lists[issue.Table]
+		key := RankIssue{Table: issue.Table, Scope_id: issue.Scope_id}
+		if !ok || !issue.Repairable() || repaired[key] {
+			continue
+		}
+		tx, err := dbInstance.db.Begin()
+		if err != nil {
+			return len(repaired), err
+		}
+		err = list.rebalance(tx, issue.Scope_id)
+		if err != nil {
+			tx.Rollback()
+			return len(repaired), fmt.Errorf("failed to rebalance %s %d: %w", list.scope, issue.Scope_id, err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			return len(repaired), err
+		}
+		repaired[key] = true
+	}
+	return len(repaired), nil
+}
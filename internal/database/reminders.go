@@ -0,0 +1,78 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// Reminders can fire at most this many minutes, 30 days, before the due date
+const MaxReminderOffset = 30 * 24 * 60
+
+// A reminder for a user with write access to an open task. It fires Offset_minutes before the due date
+type Reminder struct {
+	Task_id        int64
+	Title          string
+	Due            time.Time
+	All_day        bool
+	User_id        int64
+	Username       string
+	Email          string
+	Offset_minutes int64
+}
+
+// Returns the reminders that fired until now but not before now minus lateness and were not sent for the current due date of their task.
+// Reminders are sent to the owners and editors of the category of the task at the offsets of their settings
+func GetDueReminders(now time.Time, lateness time.Duration) ([]Reminder, error) {
+	query := `
+	SELECT t.id, t.title, t.due, t.due_timezone, t.all_day, u.id, u.username, coalesce(u.email, ''), o.minutes
+	FROM "Task" t JOIN "CategoryTasks" a ON a.task_id = t.id
+		JOIN "CategoryAccess" ca ON ca.category_id = a.category_id JOIN "User" u ON u.id = ca.user_id
+		CROSS JOIN LATERAL unnest(u.reminder_offsets) AS o(minutes)
+	WHERE t.due IS NOT NULL AND t.state NOT IN ` + closedStates + ` AND ca.role IN ('owner', 'editor') AND t.deleted_at IS NULL
+		AND t.archived_at IS NULL
+		AND t.due > $2 AND t.due <= $1 + make_interval(mins => $3)
+		AND t.due - make_interval(mins => o.minutes) <= $1 AND t.due - make_interval(mins => o.minutes) > $2
+		AND NOT EXISTS (
+			SELECT 1 FROM "SentReminders" r
+			WHERE r.task_id = t.id AND r.user_id = u.id AND r.offset_minutes = o.minutes AND r.sent_for_due = t.due
+		)
+	ORDER BY t.due, t.id, u.id`
+	rows, err := dbInstance.db.Query(query, now, now.Add(-lateness), MaxReminderOffset)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	var reminders []Reminder
+	for rows.Next() {
+		var r Reminder
+		var timezone string
+		err = rows.Scan(&r.Task_id, &r.Title, &r.Due, &timezone, &r.All_day, &r.User_id, &r.Username, &r.Email, &r.Offset_minutes)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		r.Due = r.Due.In(location(timezone))
+		reminders = append(reminders, r)
+	}
+	return reminders, rows.Err()
+}
+
+// Records the reminder as sent. Returns false if it already was, e.g. by another server instance, in which case it must not be sent again
+func ClaimReminder(r Reminder) (bool, error) {
+	query := `INSERT INTO "SentReminders" (task_id, user_id, offset_minutes, sent_for_due) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`
+	result, err := dbInstance.db.Exec(query, r.Task_id, r.User_id, r.Offset_minutes, r.Due)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim reminder: %v", err)
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+// Deletes the records of reminders sent for due dates before the given time. Returns the number of deleted records
+func PurgeSentReminders(before time.Time) (int64, error) {
+	result, err := dbInstance.db.Exec(`DELETE FROM "SentReminders" WHERE sent_for_due < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge reminders: %v", err)
+	}
+	return result.RowsAffected()
+}
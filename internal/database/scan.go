@@ -0,0 +1,279 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// Scans a column selected as a JSON array, like the labels and assignees of a task
+type jsonArray[T any] []T
+
+func (a *jsonArray[T]) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("cannot scan %T into %T", src, a)
+	}
+	return json.Unmarshal(data, a)
+}
+
+// Implemented by both *sql.DB and *sql.Tx so that a query can run inside or outside of a transaction
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// The columns scanned by scanTask. Queries using them alias "Task" as t and "CategoryTasks" as a. The position is not among them, it is set
+// by setTaskPositions
+const taskColumns = `t.id, t.title, t.details, t.state, t.priority, t.due, t.all_day, t.due_timezone,
+	coalesce(a.rank, t.rank, ''), a.category_id, t.version, t.created_at,
+	t.series_id, coalesce(t.occurrence, 0), coalesce((SELECT s.rrule FROM "TaskSeries" s WHERE s.id = t.series_id), ''), t.completed_at, t.archived_at, t.parent_task_id,
+	coalesce((SELECT json_agg(json_build_object('id', l.id, 'name', l.name, 'color', l.color) ORDER BY lower(l.name), l.id)
+		FROM "TaskLabels" tl JOIN "Labels" l ON l.id = tl.label_id WHERE tl.task_id = t.id)::text, '[]'),
+	coalesce((SELECT json_agg(json_build_object('id', au.id, 'username', au.username) ORDER BY lower(au.username), au.id)
+		FROM "TaskAssignees" ta JOIN "User" au ON au.id = ta.user_id WHERE ta.task_id = t.id)::text, '[]'),
+	(SELECT count(*) FROM "TaskComments" cm WHERE cm.task_id = t.id AND cm.deleted_at IS NULL)`
+
+// The columns scanned by scanCategory. Queries using them alias "Categories" as c. The position is not among them, it is set by
+// setCategoryPositions
+const categoryColumns = `c.id, c.belongs_to, c.workspace_id, c.name, coalesce(c.rank, ''), c.version, c.archived_at`
+
+// Scans taskColumns followed by the extra columns of the query
+func scanTask(row scanner, extra ...any) (Task, error) {
+	var task Task
+	dest := []any{&task.Id, &task.Title, &task.Details, &task.State, &task.Priority, &task.Due, &task.All_day, &task.Timezone, &task.Rank, &task.Belongs_to, &task.Version, &task.Created_at,
+		&task.Series_id, &task.Occurrence, &task.Recurrence, &task.Completed_at, &task.Archived_at, &task.Parent_task_id, (*jsonArray[TaskLabel])(&task.Labels), (*jsonArray[TaskAssignee])(&task.Assignees), &task.Comment_count}
+	err := row.Scan(append(dest, extra...)...)
+	if err == nil && task.Due != nil {
+		due := task.Due.In(location(task.Timezone))
+		task.Due = &due
+	}
+	return task, err
+}
+
+// Scans categoryColumns followed by the extra columns of the query
+func scanCategory(row scanner, extra ...any) (Categories, error) {
+	var category Categories
+	dest := []any{&category.Id, &category.Belongs_to, &category.Workspace_id, &category.Name, &category.Rank, &category.Version, &category.Archived_at}
+	err := row.Scan(append(dest, extra...)...)
+	return category, err
+}
+
+// Runs a query selecting taskColumns. Returns an empty slice if there are no rows
+func queryTasks(q querier, query string, args ...any) ([]Task, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	tasks := []Task{}
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		tasks = append(tasks, task)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	positioned := make([]*Task, len(tasks))
+	for i := range tasks {
+		positioned[i] = &tasks[i]
+	}
+	err = setTaskPositions(q, positioned...)
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// Runs a query selecting categoryColumns. Returns an empty slice if there are no rows
+func queryCategories(q querier, query string, args ...any) ([]Categories, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	categories := []Categories{}
+	for rows.Next() {
+		category, err := scanCategory(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		categories = append(categories, category)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	positioned := make([]*Categories, len(categories))
+	for i := range categories {
+		positioned[i] = &categories[i]
+	}
+	err = setCategoryPositions(q, positioned...)
+	if err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+// Runs a query selecting taskColumns for a single task. Returns ErrNoResult if there is no row
+func queryTask(q querier, query string, args ...any) (Task, error) {
+	task, err := scanTask(q.QueryRow(query, args...))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Task{}, ErrNoResult
+		}
+		return Task{}, fmt.Errorf("query error: %v", err)
+	}
+	err = setTaskPositions(q, &task)
+	if err != nil {
+		return Task{}, err
+	}
+	return task, nil
+}
+
+func queryCategory(q querier, query string, args ...any) (Categories, error) {
+	category, err := scanCategory(q.QueryRow(query, args...))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Categories{}, ErrNoResult
+		}
+		return Categories{}, fmt.Errorf("query error: %v", err)
+	}
+	err = setCategoryPositions(q, &category)
+	if err != nil {
+		return Categories{}, err
+	}
+	return category, nil
+}
+
+// Sets the Order of the tasks to their 1-based position in their category, for subtasks among the subtasks of their parent. All lists the
+// tasks are in are numbered by one query instead of counting the rows in front of every task. A task deleted or archived on its own has
+// left its list and keeps the Order 0
+func setTaskPositions(q querier, tasks ...*Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	var categoryIds, parentIds []int64
+	for _, task := range tasks {
+		if task.Parent_task_id != nil {
+			parentIds = append(parentIds, *task.Parent_task_id)
+		} else {
+			categoryIds = append(categoryIds, task.Belongs_to)
+		}
+	}
+	query := `
+	SELECT task_id, row_number() OVER (PARTITION BY category_id ORDER BY rank) FROM "CategoryTasks"
+	WHERE category_id = ANY($1) AND rank IS NOT NULL
+	UNION ALL
+	SELECT id, row_number() OVER (PARTITION BY parent_task_id ORDER BY rank) FROM "Task"
+	WHERE parent_task_id = ANY($2) AND rank IS NOT NULL`
+	positions, err := queryPositions(q, query, categoryIds, parentIds)
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		task.Order = positions[task.Id]
+	}
+	return nil
+}
+
+// Sets the Order of the categories to their 1-based position in their workspace like setTaskPositions. Deleted and archived categories have
+// no rank and keep the Order 0
+func setCategoryPositions(q querier, categories ...*Categories) error {
+	if len(categories) == 0 {
+		return nil
+	}
+	workspaceIds := make([]int64, len(categories))
+	for i, category := range categories {
+		workspaceIds[i] = category.Workspace_id
+	}
+	query := `
+	SELECT id, row_number() OVER (PARTITION BY workspace_id ORDER BY rank) FROM "Categories"
+	WHERE workspace_id = ANY($1) AND rank IS NOT NULL`
+	positions, err := queryPositions(q, query, workspaceIds)
+	if err != nil {
+		return err
+	}
+	for _, category := range categories {
+		category.Order = positions[category.Id]
+	}
+	return nil
+}
+
+// Runs a query selecting ids and their positions
+func queryPositions(q querier, query string, args ...any) (map[int64]int64, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	positions := map[int64]int64{}
+	for rows.Next() {
+		var id, position int64
+		err = rows.Scan(&id, &position)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		positions[id] = position
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return positions, nil
+}
+
+// Runs a query selecting a single text column
+func queryStrings(q querier, query string, args ...any) ([]string, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		err = rows.Scan(&value)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// Runs a query selecting a single id column
+func queryIds(q querier, query string, args ...any) ([]int64, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
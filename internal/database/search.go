@@ -0,0 +1,78 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+var ErrEmptySearch error = errors.New("the search contains no words")
+
+// A task found by SearchTasks. The highlights contain the matched words enclosed in <mark> and </mark>, the rest of the text is not escaped
+type TaskSearchResult struct {
+	Task
+	Score             float32 `json:"score"`
+	Title_highlight   string  `json:"title_highlight"`
+	Details_highlight string  `json:"details_highlight"`
+	Workspace_id      int64   `json:"workspace_id"`
+	Category_name     string  `json:"category_name"`
+}
+
+// Turns the words of the search into a tsquery that matches tasks containing all of them, each also as the prefix of a longer word
+func prefixQuery(search string) (string, error) {
+	words := strings.FieldsFunc(strings.ToLower(search), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return "", ErrEmptySearch
+	}
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+	return strings.Join(words, " & "), nil
+}
+
+// Searches the titles and details of the tasks the user can access, best matches first. Matches in the title weigh more than in the details.
+// A workspace id other than 0 restricts the search to that workspace
+func SearchTasks(username string, search string, workspaceId int64, limit int) ([]TaskSearchResult, error) {
+	tsquery, err := prefixQuery(search)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+	SELECT ` + taskColumns + `, ts_rank(t.search, q) AS score,
+		ts_headline('simple', coalesce(t.title, ''), q, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true'),
+		ts_headline('simple', coalesce(t.details, ''), q, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5'),
+		c.workspace_id, coalesce(c.name, '')
+	FROM "Task" t JOIN "CategoryTasks" a ON a.task_id = t.id JOIN "Categories" c ON c.id = a.category_id
+		JOIN "CategoryAccess" ca ON ca.category_id = a.category_id JOIN "User" u ON u.id = ca.user_id,
+		to_tsquery('simple', $2) q
+	WHERE u.username = $1 AND t.search @@ q AND ($3 = 0 OR c.workspace_id = $3) AND t.deleted_at IS NULL
+		AND t.archived_at IS NULL AND c.archived_at IS NULL
+	ORDER BY score DESC, t.id
+	LIMIT $4`
+	rows, err := dbInstance.db.Query(query, username, tsquery, workspaceId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	results := []TaskSearchResult{}
+	for rows.Next() {
+		var result TaskSearchResult
+		result.Task, err = scanTask(rows, &result.Score, &result.Title_highlight, &result.Details_highlight, &result.Workspace_id, &result.Category_name)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		results = append(results, result)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	tasks := make([]*Task, len(results))
+	for i := range results {
+		tasks[i] = &results[i].Task
+	}
+	return results, setTaskPositions(dbInstance.db, tasks...)
+}
@@ -0,0 +1,299 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"todolist/internal/rrule"
+)
+
+var ErrInvalidRecurrence error = errors.New("invalid recurrence")
+
+// Scopes of a change to a recurring task: only the task itself or the series with all its open occurrences
+const (
+	ScopeOccurrence = "occurrence"
+	ScopeSeries     = "series"
+)
+
+// The rule and the template of the occurrences of a recurring task. Dtstart is the due date of the first occurrence, Occurrences counts the
+// tasks created for the series and Last_due is the due date of the latest one
+type TaskSeries struct {
+	Id          int64
+	Rrule       string
+	Dtstart     time.Time
+	Title       string
+	Details     string
+	All_day     bool
+	Timezone    string
+	Occurrences int64
+	Last_due    time.Time
+}
+
+// Checks the recurrence of a task as sent by a client. A recurring task needs a due date, which is the first occurrence of the series,
+// and a rule that repeats from it
+func (t Task) ValidateRecurrence() error {
+	if t.Recurrence == "" {
+		return nil
+	}
+	if t.Due == nil {
+		return fmt.Errorf("%w: a recurring task needs a due date", ErrInvalidRecurrence)
+	}
+	_, err := parseSeriesRule(t.Recurrence, *t.Due, t.Timezone)
+	return err
+}
+
+// Parses an RRULE and returns its canonical form
+func parseRecurrence(recurrence string) (string, error) {
+	rule, err := rrule.Parse(recurrence)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidRecurrence, err)
+	}
+	return rule.String(), nil
+}
+
+// Parses the RRULE of a series starting at start in the time zone and returns its canonical form. A rule without a second occurrence
+// within the search horizon of rrule, like the 30th of February, is rejected, as every completion would search it in vain
+func parseSeriesRule(recurrence string, start time.Time, timezone string) (string, error) {
+	rule, err := rrule.Parse(recurrence)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidRecurrence, err)
+	}
+	if !rule.Repeats(start.In(location(timezone))) {
+		return "", fmt.Errorf("%w: the rule has no occurrence after %s", ErrInvalidRecurrence, start.Format(time.DateOnly))
+	}
+	return rule.String(), nil
+}
+
+// Returns the due date of the occurrence following the latest one. It is computed in the time zone of the series, so that an occurrence
+// keeps its local time of day across daylight saving time changes. Returns false if the series has ended
+func (s TaskSeries) NextDue() (time.Time, bool, error) {
+	rule, err := rrule.Parse(s.Rrule)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%w: series %d: %v", ErrInvalidRecurrence, s.Id, err)
+	}
+	loc := location(s.Timezone)
+	next, ok := rule.Next(s.Dtstart.In(loc), s.Last_due.In(loc))
+	return next, ok, nil
+}
+
+// Returns the series or ErrNoResult if it does not exist
+func GetTaskSeries(seriesId int64) (TaskSeries, error) {
+	return getTaskSeries(dbInstance.db, seriesId, "")
+}
+
+func getTaskSeries(q querier, seriesId int64, lock string) (TaskSeries, error) {
+	var s TaskSeries
+	var title, details sql.NullString
+	query := `SELECT id, rrule, dtstart, title, details, all_day, due_timezone, occurrences, last_due FROM "TaskSeries" WHERE id = $1 ` + lock
+	err := q.QueryRow(query, seriesId).Scan(&s.Id, &s.Rrule, &s.Dtstart, &title, &details, &s.All_day, &s.Timezone, &s.Occurrences, &s.Last_due)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return TaskSeries{}, ErrNoResult
+		}
+		return TaskSeries{}, fmt.Errorf("query error: %v", err)
+	}
+	s.Title, s.Details = title.String, details.String
+	return s, nil
+}
+
+// Makes the task the first occurrence of a new series with the task as template. Returns the id of the series
+func createSeries(tx *sql.Tx, taskId int64, recurrence string) (int64, error) {
+	var due sql.NullTime
+	var timezone string
+	err := tx.QueryRow(`SELECT due, due_timezone FROM "Task" WHERE id = $1`, taskId).Scan(&due, &timezone)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoResult
+		}
+		return 0, fmt.Errorf("query error: %v", err)
+	}
+	if !due.Valid {
+		return 0, fmt.Errorf("%w: a recurring task needs a due date", ErrInvalidRecurrence)
+	}
+	recurrence, err = parseSeriesRule(recurrence, due.Time, timezone)
+	if err != nil {
+		return 0, err
+	}
+	query := `INSERT INTO "TaskSeries" (rrule, dtstart, title, details, all_day, due_timezone, last_due)
+	SELECT $1, due, title, details, all_day, due_timezone, due FROM "Task" WHERE id = $2
+	RETURNING id`
+	var seriesId int64
+	err = tx.QueryRow(query, recurrence, taskId).Scan(&seriesId)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create series: %v", err)
+	}
+	_, err = tx.Exec(`UPDATE "Task" SET series_id = $1, occurrence = 1 WHERE id = $2`, seriesId, taskId)
+	return seriesId, err
+}
+
+// Starts a series with the task as its first occurrence. Returns ErrInvalidRecurrence if the task has no due date
+func StartSeries(taskId int64, recurrence string) error {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockVersion(tx, "Task", taskId, 0)
+	if err != nil {
+		return err
+	}
+	_, err = createSeries(tx, taskId, recurrence)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE "Task" SET version = version + 1 WHERE id = $1`, taskId)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Replaces the rule of the series. The following occurrences are computed with the new rule from the start of the series
+func SetSeriesRule(seriesId int64, recurrence string) error {
+	series, err := GetTaskSeries(seriesId)
+	if err != nil {
+		return err
+	}
+	recurrence, err = parseSeriesRule(recurrence, series.Dtstart, series.Timezone)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(dbInstance.db.Exec(`UPDATE "TaskSeries" SET rrule = $1 WHERE id = $2`, recurrence, seriesId))
+}
+
+// Ends the series, so that completing its occurrences creates no new ones. The occurrences remain as ordinary tasks
+func EndSeries(seriesId int64) error {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE "Task" SET series_id = NULL, occurrence = NULL, version = version + 1 WHERE series_id = $1`, seriesId)
+	if err != nil {
+		return fmt.Errorf("failed to end series: %v", err)
+	}
+	err = expectOneRow(tx.Exec(`DELETE FROM "TaskSeries" WHERE id = $1`, seriesId))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Applies the title and details of the patch to the template of the series and to its open occurrences except the task exclude,
+// which the caller patches itself
+func PatchSeries(seriesId int64, patch TaskPatch, exclude int64) error {
+	var b updateBuilder
+	if patch.Title.Set {
+		b.set("title", patch.Title.Get())
+	}
+	if patch.Details.Set {
+		b.set("details", patch.Details.Get())
+	}
+	if len(b.columns) == 0 {
+		return nil
+	}
+
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	args := append(b.args, seriesId)
+	query := fmt.Sprintf(`UPDATE "TaskSeries" SET %s WHERE id = $%d`, strings.Join(b.columns, ", "), len(args))
+	err = expectOneRow(tx.Exec(query, args...))
+	if err != nil {
+		return err
+	}
+	args = append(b.args, seriesId, exclude)
+	query = fmt.Sprintf(`UPDATE "Task" SET %s, version = version + 1 WHERE series_id = $%d AND id <> $%d AND deleted_at IS NULL AND state NOT IN `+closedStates,
+		strings.Join(b.columns, ", "), len(args)-1, len(args))
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update occurrences: %v", err)
+	}
+	return tx.Commit()
+}
+
+// Creates the occurrence after the latest one of the series from its template with the priority and the assignees of the latest one, due at
+// the given time and at the position order in the category. Returns ErrNoResult if the series does not exist or another occurrence was
+// created since it was read, so that completing the same occurrence twice creates only one successor
+func AddOccurrence(series TaskSeries, due time.Time, categoryId int64, order int64) (Task, error) {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return Task{}, err
+	}
+	defer tx.Rollback()
+
+	current, err := getTaskSeries(tx, series.Id, "FOR UPDATE")
+	if err != nil {
+		return Task{}, err
+	}
+	if current.Occurrences != series.Occurrences {
+		return Task{}, ErrNoResult
+	}
+	err = taskList.lock(tx, categoryId)
+	if err != nil {
+		return Task{}, err
+	}
+	key, err := taskList.keyAt(tx, categoryId, order, 0)
+	if err != nil {
+		return Task{}, err
+	}
+
+	query := `
+	WITH rows AS (
+		INSERT INTO "Task" (title, details, state, due, all_day, due_timezone, series_id, occurrence, priority)
+		VALUES ($1, $2, 'todo', $3, $4, $5, $6, $7,
+			coalesce((SELECT o.priority FROM "Task" o WHERE o.series_id = $6 ORDER BY o.occurrence DESC LIMIT 1), 0))
+		RETURNING id
+	)
+	INSERT INTO "CategoryTasks" ("category_id", "rank", "task_id")
+	SELECT $8, $9, id FROM rows
+	RETURNING task_id`
+	var taskId int64
+	err = tx.QueryRow(query, current.Title, current.Details, due, current.All_day, current.Timezone,
+		current.Id, current.Occurrences+1, categoryId, key).Scan(&taskId)
+	if err != nil {
+		return Task{}, fmt.Errorf("failed to insert occurrence: %v", err)
+	}
+	query = `
+	INSERT INTO "TaskAssignees" (task_id, user_id)
+	SELECT $1, ta.user_id FROM "TaskAssignees" ta JOIN "Task" o ON o.id = ta.task_id WHERE o.series_id = $2 AND o.occurrence = $3`
+	_, err = tx.Exec(query, taskId, current.Id, current.Occurrences)
+	if err != nil {
+		return Task{}, fmt.Errorf("failed to copy assignees: %v", err)
+	}
+	_, err = tx.Exec(`UPDATE "TaskSeries" SET occurrences = occurrences + 1, last_due = $1 WHERE id = $2`, due, current.Id)
+	if err != nil {
+		return Task{}, fmt.Errorf("failed to update series: %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return Task{}, err
+	}
+	return GetTaskById(taskId)
+}
+
+// Returns the tasks among the ids that are open occurrences of a series
+func GetOpenSeriesTasks(taskIds []int64) ([]Task, error) {
+	query := `
+	SELECT ` + taskColumns + `
+	FROM "Task" t JOIN "CategoryTasks" a ON a.task_id = t.id
+	WHERE t.id = ANY($1) AND t.series_id IS NOT NULL AND t.deleted_at IS NULL AND t.state NOT IN ` + closedStates
+	return queryTasks(dbInstance.db, query, taskIds)
+}
+
+// Returns the open occurrences of the series except the task exclude, which PatchSeries changes along with the series
+func GetOpenOccurrences(seriesId int64, exclude int64) ([]Task, error) {
+	query := `
+	SELECT ` + taskColumns + `
+	FROM "Task" t JOIN "CategoryTasks" a ON a.task_id = t.id
+	WHERE t.series_id = $1 AND t.id <> $2 AND t.deleted_at IS NULL AND t.state NOT IN ` + closedStates
+	return queryTasks(dbInstance.db, query, seriesId, exclude)
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Settings a user can change for themselves. Reminders about due tasks are sent the given numbers of minutes before the due date,
+// by email only if the user has an email address. Done tasks the user can see are archived after Auto_archive_days days unless another
+// user who can see them waits longer, 0 never archives them
+type UserSettings struct {
+	Timezone          string  `json:"timezone"`
+	Email             string  `json:"email"`
+	Reminder_offsets  []int64 `json:"reminder_offsets"`
+	Auto_archive_days int64   `json:"auto_archive_days"`
+}
+
+// A partial update of the settings. Absent fields are left untouched
+type SettingsPatch struct {
+	Timezone          Optional[string]  `json:"timezone"`
+	Email             Optional[string]  `json:"email"`
+	Reminder_offsets  Optional[[]int64] `json:"reminder_offsets"`
+	Auto_archive_days Optional[int64]   `json:"auto_archive_days"`
+}
+
+// Returns the settings of the user or ErrNoResult if the user does not exist
+func GetUserSettings(username string) (UserSettings, error) {
+	var settings UserSettings
+	var offsets string
+	query := `SELECT timezone, coalesce(email, ''), array_to_json(reminder_offsets)::text, auto_archive_days FROM "User" WHERE username = $1`
+	err := dbInstance.db.QueryRow(query, username).Scan(&settings.Timezone, &settings.Email, &offsets, &settings.Auto_archive_days)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserSettings{}, ErrNoResult
+		}
+		return UserSettings{}, fmt.Errorf("query error: %v", err)
+	}
+	err = json.Unmarshal([]byte(offsets), &settings.Reminder_offsets)
+	if err != nil {
+		return UserSettings{}, fmt.Errorf("scan error: %v", err)
+	}
+	return settings, nil
+}
+
+// Updates the settings that are set in the patch. Returns ErrNoResult if the user does not exist
+func PatchUserSettings(username string, patch SettingsPatch) error {
+	var b updateBuilder
+	if patch.Timezone.Set {
+		b.set("timezone", patch.Timezone.Get())
+	}
+	if patch.Email.Set {
+		var email any
+		if patch.Email.Get() != "" {
+			email = patch.Email.Get()
+		}
+		b.set("email", email)
+	}
+	if patch.Reminder_offsets.Set {
+		offsets := patch.Reminder_offsets.Get()
+		if offsets == nil {
+			offsets = []int64{}
+		}
+		b.set("reminder_offsets", offsets)
+	}
+	if patch.Auto_archive_days.Set {
+		b.set("auto_archive_days", patch.Auto_archive_days.Get())
+	}
+	if len(b.columns) == 0 {
+		return nil
+	}
+	args := append(b.args, username)
+	query := fmt.Sprintf(`UPDATE "User" SET %s WHERE username = $%d`, strings.Join(b.columns, ", "), len(args))
+	return expectOneRow(dbInstance.db.Exec(query, args...))
+}
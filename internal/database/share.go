@@ -0,0 +1,174 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Inserts an invitation link for a category. The token itself is not stored, only its hash
+func AddCategoryInvitation(invitation CategoryInvitation, tokenHash string, createdBy int64) (CategoryInvitation, error) {
+	query := `
+	INSERT INTO "CategoryInvitations" ("category_id", "token_hash", "role", "created_by", "expires_at", "single_use")
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at`
+	err := dbInstance.db.QueryRow(query, invitation.Category_id, tokenHash, invitation.Role, createdBy, invitation.Expires_at, invitation.Single_use).Scan(&invitation.Id, &invitation.Created_at)
+	if err != nil {
+		return CategoryInvitation{}, fmt.Errorf("failed to insert invitation: %v", err)
+	}
+	return invitation, nil
+}
+
+const categoryInvitationSelect = `
+	SELECT i.id, i.category_id, i.role, COALESCE(u.username, ''), i.created_at, i.expires_at, i.single_use, i.use_count, i.revoked_at IS NOT NULL
+	FROM "CategoryInvitations" i LEFT JOIN "User" u ON u.id = i.created_by`
+
+// Returns all invitations of a category that are neither revoked, expired nor used up
+func GetOutstandingCategoryInvitations(categoryId int64) ([]CategoryInvitation, error) {
+	query := categoryInvitationSelect + `
+	WHERE i.category_id = $1 AND i.revoked_at IS NULL AND i.expires_at > now() AND NOT (i.single_use AND i.use_count > 0)
+	ORDER BY i.created_at`
+	rows, err := dbInstance.db.Query(query, categoryId)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	var invitations []CategoryInvitation
+	for rows.Next() {
+		invitation, err := scanCategoryInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, invitation)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return invitations, nil
+}
+
+// Returns the invitation with the given id or ErrNoResult
+func GetCategoryInvitationById(invitationId int64) (CategoryInvitation, error) {
+	return queryCategoryInvitation(categoryInvitationSelect+` WHERE i.id = $1`, invitationId)
+}
+
+// Returns the invitation belonging to a token hash or ErrNoResult
+func GetCategoryInvitationByTokenHash(tokenHash string) (CategoryInvitation, error) {
+	return queryCategoryInvitation(categoryInvitationSelect+` WHERE i.token_hash = $1`, tokenHash)
+}
+
+func queryCategoryInvitation(query string, arg any) (CategoryInvitation, error) {
+	invitation, err := scanCategoryInvitation(dbInstance.db.QueryRow(query, arg))
+	if errors.Is(err, sql.ErrNoRows) {
+		return CategoryInvitation{}, ErrNoResult
+	}
+	return invitation, err
+}
+
+func scanCategoryInvitation(row scanner) (CategoryInvitation, error) {
+	var i CategoryInvitation
+	err := row.Scan(&i.Id, &i.Category_id, &i.Role, &i.Created_by, &i.Created_at, &i.Expires_at, &i.Single_use, &i.Use_count, &i.Revoked)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return CategoryInvitation{}, err
+		}
+		return CategoryInvitation{}, fmt.Errorf("scan error: %v", err)
+	}
+	return i, nil
+}
+
+// Returns ErrNoResult if there is no such invitation
+func RevokeCategoryInvitation(invitationId int64) error {
+	query := `UPDATE "CategoryInvitations" SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL`
+	return expectOneRow(dbInstance.db.Exec(query, invitationId))
+}
+
+// Consumes one use of a valid invitation and records the granted role in "CategoryShares". An existing share is never downgraded and a
+// user who already has the role of the invitation or a higher one on the category doesn't use it up. Returns ErrNoResult if the token
+// does not belong to a usable invitation
+func RedeemCategoryInvitation(tokenHash string, userId int64) (CategoryInvitation, error) {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return CategoryInvitation{}, err
+	}
+
+	var invitation CategoryInvitation
+	query := `
+	SELECT id, category_id, role FROM "CategoryInvitations"
+	WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > now() AND NOT (single_use AND use_count > 0)
+	FOR UPDATE`
+	err = tx.QueryRow(query, tokenHash).Scan(&invitation.Id, &invitation.Category_id, &invitation.Role)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return CategoryInvitation{}, ErrNoResult
+		}
+		return CategoryInvitation{}, fmt.Errorf("failed to redeem invitation: %v", err)
+	}
+
+	var covered bool
+	query = `
+	SELECT EXISTS (SELECT 1 FROM "CategoryAccess" WHERE category_id = $1 AND user_id = $2 AND (role IN ('owner', 'editor') OR role = $3))`
+	err = tx.QueryRow(query, invitation.Category_id, userId, invitation.Role).Scan(&covered)
+	if err != nil {
+		tx.Rollback()
+		return CategoryInvitation{}, fmt.Errorf("query error: %v", err)
+	}
+	if covered {
+		return invitation, tx.Commit()
+	}
+
+	_, err = tx.Exec(`UPDATE "CategoryInvitations" SET use_count = use_count + 1 WHERE id = $1`, invitation.Id)
+	if err != nil {
+		tx.Rollback()
+		return CategoryInvitation{}, fmt.Errorf("failed to redeem invitation: %v", err)
+	}
+	query = `
+	INSERT INTO "CategoryShares" ("category_id", "user_id", "role") VALUES ($1, $2, $3)
+	ON CONFLICT ("category_id", "user_id") DO UPDATE
+	SET role = CASE WHEN "CategoryShares".role = 'editor' THEN 'editor' ELSE EXCLUDED.role END`
+	_, err = tx.Exec(query, invitation.Category_id, userId, invitation.Role)
+	if err != nil {
+		tx.Rollback()
+		return CategoryInvitation{}, fmt.Errorf("failed to insert share: %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return CategoryInvitation{}, err
+	}
+	return invitation, nil
+}
+
+// Returns the categories shared directly with a user that do not belong to one of the user's workspaces and are not archived
+func GetCategoriesSharedWith(username string) []Categories {
+	query := `
+	SELECT ` + categoryColumns + `
+	FROM "CategoryShares" s JOIN "User" u ON u.id = s.user_id JOIN "Categories" c ON c.id = s.category_id
+	WHERE u.username = $1 AND c.deleted_at IS NULL AND c.archived_at IS NULL
+	AND NOT EXISTS (SELECT 1 FROM "WorkspaceMembers" m WHERE m.workspace_id = c.workspace_id AND m.user_id = u.id)`
+	categories, err := queryCategories(dbInstance.db, query, username)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return categories
+}
+
+// Returns the tasks of the categories returned by GetCategoriesSharedWith
+func GetTasksSharedWith(username string) []Task {
+	query := `
+	SELECT ` + taskColumns + `
+	FROM 
+		"CategoryShares" s JOIN "User" u ON u.id = s.user_id JOIN "Categories" c ON c.id = s.category_id JOIN "CategoryTasks" a ON c.id = a.category_id JOIN "Task" t ON a.task_id = t.id
+	WHERE 
+		u.username = $1 AND t.deleted_at IS NULL AND t.archived_at IS NULL AND c.archived_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM "WorkspaceMembers" m WHERE m.workspace_id = c.workspace_id AND m.user_id = u.id);
+	`
+	tasks, err := queryTasks(dbInstance.db, query, username)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return tasks
+}
@@ -0,0 +1,184 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var ErrInvalidState error = errors.New("invalid task state")
+
+// The state of a task. Done and cancelled tasks are closed, all others are open
+type TaskState string
+
+const (
+	StateTodo       TaskState = "todo"
+	StateInProgress TaskState = "in_progress"
+	StateBlocked    TaskState = "blocked"
+	StateDone       TaskState = "done"
+	StateCancelled  TaskState = "cancelled"
+)
+
+var TaskStates = []TaskState{StateTodo, StateInProgress, StateBlocked, StateDone, StateCancelled}
+
+// The closed states in SQL, for conditions like t.state NOT IN closedStates
+const closedStates = `('done', 'cancelled')`
+
+func (s TaskState) Valid() bool {
+	return slices.Contains(TaskStates, s)
+}
+
+func (s TaskState) Closed() bool {
+	return s == StateDone || s == StateCancelled
+}
+
+// Accepts the state names and, for clients written against the numeric states, 0 for todo and 1 for done
+func (s *TaskState) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "0":
+		*s = StateTodo
+		return nil
+	case "1":
+		*s = StateDone
+		return nil
+	}
+	var name string
+	err := json.Unmarshal(data, &name)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidState, data)
+	}
+	*s = TaskState(name)
+	return nil
+}
+
+// The states a task may change to from each state. Staying in the same state is always allowed
+type StateTransitions map[TaskState][]TaskState
+
+// The transitions of workspaces that did not configure their own
+var DefaultTransitions = StateTransitions{
+	StateTodo:       {StateInProgress, StateBlocked, StateDone, StateCancelled},
+	StateInProgress: {StateTodo, StateBlocked, StateDone, StateCancelled},
+	StateBlocked:    {StateTodo, StateInProgress, StateCancelled},
+	StateDone:       {StateTodo, StateInProgress},
+	StateCancelled:  {StateTodo},
+}
+
+func (t StateTransitions) Allows(from TaskState, to TaskState) bool {
+	return from == to || slices.Contains(t[from], to)
+}
+
+// Returns an error wrapping ErrInvalidState if the transitions name an unknown state
+func (t StateTransitions) Validate() error {
+	for from, targets := range t {
+		if !from.Valid() {
+			return fmt.Errorf("%w: %q", ErrInvalidState, from)
+		}
+		for _, to := range targets {
+			if !to.Valid() {
+				return fmt.Errorf("%w: %q", ErrInvalidState, to)
+			}
+		}
+	}
+	return nil
+}
+
+// Parses the transitions stored for a workspace. An empty value means the workspace uses the default transitions
+func parseTransitions(stored string) (StateTransitions, error) {
+	if stored == "" {
+		return DefaultTransitions, nil
+	}
+	var transitions StateTransitions
+	err := json.Unmarshal([]byte(stored), &transitions)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transitions %s: %v", stored, err)
+	}
+	return transitions, nil
+}
+
+// Returns the transitions of the workspace or ErrNoResult if it does not exist
+func GetStateTransitions(workspaceId int64) (StateTransitions, error) {
+	var stored string
+	err := dbInstance.db.QueryRow(`SELECT coalesce(state_transitions::text, '') FROM "Workspace" WHERE id = $1`, workspaceId).Scan(&stored)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoResult
+		}
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	return parseTransitions(stored)
+}
+
+// Stores the transitions of the workspace. nil restores the default transitions
+func SetStateTransitions(workspaceId int64, transitions StateTransitions) error {
+	var stored any
+	if transitions != nil {
+		b, err := json.Marshal(transitions)
+		if err != nil {
+			return err
+		}
+		stored = string(b)
+	}
+	return expectOneRow(dbInstance.db.Exec(`UPDATE "Workspace" SET state_transitions = $1::jsonb WHERE id = $2`, stored, workspaceId))
+}
+
+// The transitions of a workspace as sent by a client. Null transitions restore the default ones
+type WorkspaceTransitions struct {
+	Workspace_id int64            `json:"workspace_id"`
+	Transitions  StateTransitions `json:"transitions"`
+}
+
+// The current state of a task and the transitions of the workspace it belongs to
+type TaskStateRules struct {
+	State       TaskState
+	Transitions StateTransitions
+}
+
+// Returns the state and the transitions of each of the tasks that exists
+func GetTaskStateRules(taskIds []int64) (map[int64]TaskStateRules, error) {
+	query := `
+	SELECT t.id, t.state, coalesce(w.state_transitions::text, '')
+	FROM "Task" t JOIN "CategoryTasks" a ON a.task_id = t.id JOIN "Categories" c ON c.id = a.category_id JOIN "Workspace" w ON w.id = c.workspace_id
+	WHERE t.id = ANY($1)`
+	rows, err := dbInstance.db.Query(query, taskIds)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	rules := make(map[int64]TaskStateRules)
+	for rows.Next() {
+		var id int64
+		var rule TaskStateRules
+		var stored string
+		err = rows.Scan(&id, &rule.State, &stored)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		rule.Transitions, err = parseTransitions(stored)
+		if err != nil {
+			return nil, err
+		}
+		rules[id] = rule
+	}
+	return rules, rows.Err()
+}
+
+// Checks the fields of a task as sent by a client. An empty state is allowed and means todo for a new task and the current state otherwise
+func (t Task) Validate() error {
+	if t.State != "" && !t.State.Valid() {
+		return fmt.Errorf("%w: %q", ErrInvalidState, t.State)
+	}
+	err := t.ValidateDue()
+	if err != nil {
+		return err
+	}
+	return t.ValidateRecurrence()
+}
+
+// Sets the state and keeps completed_at in step: it is set when the task becomes done and cleared when it is reopened
+func (b *updateBuilder) setState(state TaskState) {
+	b.set("state", state)
+	b.columns = append(b.columns, fmt.Sprintf("completed_at = CASE WHEN $%d = 'done' THEN coalesce(completed_at, now()) END", len(b.args)))
+}
@@ -0,0 +1,353 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var ErrSubtaskMove error = errors.New("a subtask stays in the category of its parent task")
+
+// An item of the checklist of a task. Order is the 1-based position in the checklist and derived from the rank key
+type ChecklistItem struct {
+	Id      int64  `json:"id"`
+	Task_id int64  `json:"task_id"`
+	Text    string `json:"text" binding:"max=500"`
+	Done    bool   `json:"done"`
+	Order   int64  `json:"order"`
+	Rank    string `json:"rank"`
+	Version int64  `json:"version"`
+}
+
+// How many of the subtasks and checklist items of a task are done. Cancelled subtasks are not counted
+type Progress struct {
+	Done  int64 `json:"done"`
+	Total int64 `json:"total"`
+}
+
+// The columns scanned by scanChecklistItem. Queries using them alias "ChecklistItems" as i
+const checklistColumns = `i.id, i.task_id, i.text, i.done,
+	(SELECT count(*) FROM "ChecklistItems" pos WHERE pos.task_id = i.task_id AND pos.rank <= i.rank), i.rank, i.version`
+
+// Selects the ids of all subtasks below the task $1, however deeply nested
+const subtreeQuery = `WITH RECURSIVE tree AS (
+	SELECT id FROM "Task" WHERE parent_task_id = $1
+	UNION ALL
+	SELECT c.id FROM "Task" c JOIN tree ON c.parent_task_id = tree.id
+)`
+
+func scanChecklistItem(row scanner) (ChecklistItem, error) {
+	var item ChecklistItem
+	err := row.Scan(&item.Id, &item.Task_id, &item.Text, &item.Done, &item.Order, &item.Rank, &item.Version)
+	return item, err
+}
+
+// Inserts the task as a subtask of the parent at the position given by its order among the other subtasks. The subtask belongs to the
+// category of the parent and is archived with it. Returns ErrNoResult if the parent does not exist
+func AddSubtask(parentId int64, task Task) (Task, error) {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return Task{}, err
+	}
+	defer tx.Rollback()
+
+	err = subtaskList.lock(tx, parentId)
+	if err != nil {
+		return Task{}, err
+	}
+	var categoryId int64
+	err = tx.QueryRow(`SELECT category_id FROM "CategoryTasks" WHERE task_id = $1`, parentId).Scan(&categoryId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Task{}, ErrNoResult
+		}
+		return Task{}, fmt.Errorf("query error: %v", err)
+	}
+	key, err := subtaskList.keyAt(tx, parentId, task.Order, 0)
+	if err != nil {
+		return Task{}, err
+	}
+
+	query := `
+	WITH rows AS (
+		INSERT INTO "Task" (title, details, state, due, all_day, due_timezone, completed_at, parent_task_id, rank, priority, archived_at)
+		VALUES ($1, $2, $3, $4, $5, $6, CASE WHEN $3 = 'done' THEN now() END, $7, $8, $10, (SELECT archived_at FROM "Task" WHERE id = $7))
+		RETURNING id
+	)
+	INSERT INTO "CategoryTasks" ("category_id", "task_id")
+	SELECT $9, id FROM rows
+	RETURNING task_id`
+	var taskId int64
+	err = tx.QueryRow(query, task.Title, task.Details, task.State, task.Due, task.All_day, task.Timezone, parentId, key, categoryId, task.Priority).Scan(&taskId)
+	if err != nil {
+		return Task{}, fmt.Errorf("failed to insert subtask: %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return Task{}, err
+	}
+	return GetTaskById(taskId)
+}
+
+// Moves a subtask to a position among the subtasks of its parent and increments its version. If version is not 0 the subtask is only
+// moved if the stored version still matches
+func MoveSubtask(taskId int64, toOrder int64, version int64) error {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockVersion(tx, "Task", taskId, version)
+	if err != nil {
+		return err
+	}
+	var parentId sql.NullInt64
+	err = tx.QueryRow(`SELECT parent_task_id FROM "Task" WHERE id = $1`, taskId).Scan(&parentId)
+	if err != nil {
+		return fmt.Errorf("query error: %v", err)
+	}
+	if !parentId.Valid {
+		return fmt.Errorf("task %d is not a subtask", taskId)
+	}
+	err = subtaskList.lock(tx, parentId.Int64)
+	if err != nil {
+		return err
+	}
+	key, err := subtaskList.keyAt(tx, parentId.Int64, toOrder, taskId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE "Task" SET rank = $1, version = version + 1 WHERE id = $2`, key, taskId)
+	if err != nil {
+		return fmt.Errorf("failed to move subtask: %v", err)
+	}
+	return tx.Commit()
+}
+
+// Gives the task the key in the category and moves its subtasks along. Returns ErrSubtaskMove for a subtask, ErrArchived for an archived
+// task and ErrNoResult if the task does not exist
+func moveTaskTree(q querier, taskId int64, categoryId int64, key string) error {
+	var subtask, archived bool
+	err := q.QueryRow(`SELECT parent_task_id IS NOT NULL, archived_at IS NOT NULL FROM "Task" WHERE id = $1`, taskId).Scan(&subtask, &archived)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoResult
+		}
+		return fmt.Errorf("query error: %v", err)
+	}
+	if subtask {
+		return ErrSubtaskMove
+	}
+	if archived {
+		return ErrArchived
+	}
+	err = expectOneRow(q.Exec(`UPDATE "CategoryTasks" SET category_id = $1, rank = $2 WHERE task_id = $3`, categoryId, key, taskId))
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(subtreeQuery+` UPDATE "CategoryTasks" SET category_id = $2 WHERE task_id IN (SELECT id FROM tree)`, taskId, categoryId)
+	if err != nil {
+		return fmt.Errorf("failed to move subtasks: %v", err)
+	}
+	return nil
+}
+
+// Returns the task with its subtasks, checklists and progress or ErrNoResult if it does not exist
+func GetTaskTree(taskId int64) (Task, error) {
+	task, err := GetTaskById(taskId)
+	if err != nil {
+		return Task{}, err
+	}
+	query := subtreeQuery + `
+	SELECT ` + taskColumns + `
+	FROM tree JOIN "Task" t ON t.id = tree.id JOIN "CategoryTasks" a ON a.task_id = t.id
+	WHERE t.deleted_at IS NULL`
+	subtasks, err := queryTasks(dbInstance.db, query, taskId)
+	if err != nil {
+		return Task{}, err
+	}
+	tasks := append([]Task{task}, subtasks...)
+	ids := make([]int64, len(tasks))
+	for i, t := range tasks {
+		ids[i] = t.Id
+	}
+	items, err := GetChecklistItems(ids)
+	if err != nil {
+		return Task{}, err
+	}
+	return NestSubtasks(tasks, items)[0], nil
+}
+
+// Puts every task whose parent is among the tasks into the subtasks of the parent, ordered by their position, and attaches the checklist
+// items and the progress. Returns the tasks without a parent among the tasks in their original order
+func NestSubtasks(tasks []Task, items []ChecklistItem) []Task {
+	present := make(map[int64]bool, len(tasks))
+	for _, task := range tasks {
+		present[task.Id] = true
+	}
+	children := make(map[int64][]Task)
+	var roots []Task
+	for _, task := range tasks {
+		if task.Parent_task_id != nil && present[*task.Parent_task_id] {
+			children[*task.Parent_task_id] = append(children[*task.Parent_task_id], task)
+		} else {
+			roots = append(roots, task)
+		}
+	}
+	checklists := make(map[int64][]ChecklistItem)
+	for _, item := range items {
+		checklists[item.Task_id] = append(checklists[item.Task_id], item)
+	}
+
+	var nest func(task Task) Task
+	nest = func(task Task) Task {
+		subtasks := children[task.Id]
+		slices.SortFunc(subtasks, func(a, b Task) int { return cmp.Compare(a.Order, b.Order) })
+		var progress Progress
+		for _, subtask := range subtasks {
+			if subtask.State != StateCancelled {
+				progress.Total++
+			}
+			if subtask.State == StateDone {
+				progress.Done++
+			}
+			task.Subtasks = append(task.Subtasks, nest(subtask))
+		}
+		task.Checklist = checklists[task.Id]
+		for _, item := range task.Checklist {
+			progress.Total++
+			if item.Done {
+				progress.Done++
+			}
+		}
+		if progress.Total > 0 {
+			task.Progress = &progress
+		}
+		return task
+	}
+
+	nested := make([]Task, len(roots))
+	for i, task := range roots {
+		nested[i] = nest(task)
+	}
+	return nested
+}
+
+// Returns the checklist items of the tasks ordered by task and position. Returns an empty slice if there are none
+func GetChecklistItems(taskIds []int64) ([]ChecklistItem, error) {
+	query := `SELECT ` + checklistColumns + ` FROM "ChecklistItems" i WHERE i.task_id = ANY($1) ORDER BY i.task_id, i.rank`
+	rows, err := dbInstance.db.Query(query, taskIds)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	items := []ChecklistItem{}
+	for rows.Next() {
+		item, err := scanChecklistItem(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// Returns the checklist item or ErrNoResult if it does not exist
+func GetChecklistItem(itemId int64) (ChecklistItem, error) {
+	item, err := scanChecklistItem(dbInstance.db.QueryRow(`SELECT `+checklistColumns+` FROM "ChecklistItems" i WHERE i.id = $1`, itemId))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ChecklistItem{}, ErrNoResult
+		}
+		return ChecklistItem{}, fmt.Errorf("query error: %v", err)
+	}
+	return item, nil
+}
+
+// Inserts the item at the position given by its order in the checklist of its task. Returns ErrNoResult if the task does not exist
+func AddChecklistItem(item ChecklistItem) (ChecklistItem, error) {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return ChecklistItem{}, err
+	}
+	defer tx.Rollback()
+
+	err = checklistList.lock(tx, item.Task_id)
+	if err != nil {
+		return ChecklistItem{}, err
+	}
+	key, err := checklistList.keyAt(tx, item.Task_id, item.Order, 0)
+	if err != nil {
+		return ChecklistItem{}, err
+	}
+	query := `INSERT INTO "ChecklistItems" (task_id, text, done, rank) VALUES ($1, $2, $3, $4) RETURNING id`
+	err = tx.QueryRow(query, item.Task_id, item.Text, item.Done, key).Scan(&item.Id)
+	if err != nil {
+		return ChecklistItem{}, fmt.Errorf("failed to insert checklist item: %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return ChecklistItem{}, err
+	}
+	return GetChecklistItem(item.Id)
+}
+
+// Updates only the fields of the item that are set in the patch, a new order moves it within its checklist. Returns ErrNoResult if the
+// item does not exist and ErrVersionConflict if the patch is based on an outdated version
+func PatchChecklistItem(itemId int64, patch ChecklistPatch) error {
+	var b updateBuilder
+	if patch.Text.Set {
+		b.set("text", patch.Text.Get())
+	}
+	if patch.Done.Set {
+		b.set("done", patch.Done.Value)
+	}
+	if len(b.columns) == 0 && !patch.Order.Set {
+		return nil
+	}
+
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockVersion(tx, "ChecklistItems", itemId, patch.Version)
+	if err != nil {
+		return err
+	}
+	if patch.Order.Set {
+		var taskId int64
+		err = tx.QueryRow(`SELECT task_id FROM "ChecklistItems" WHERE id = $1`, itemId).Scan(&taskId)
+		if err != nil {
+			return fmt.Errorf("query error: %v", err)
+		}
+		err = checklistList.lock(tx, taskId)
+		if err != nil {
+			return err
+		}
+		key, err := checklistList.keyAt(tx, taskId, patch.Order.Value, itemId)
+		if err != nil {
+			return err
+		}
+		b.set("rank", key)
+	}
+	args := append(b.args, itemId)
+	query := fmt.Sprintf(`UPDATE "ChecklistItems" SET %s, version = version + 1 WHERE id = $%d`, strings.Join(b.columns, ", "), len(args))
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update checklist item: %v", err)
+	}
+	return tx.Commit()
+}
+
+// Deletes a checklist item. If item.Version is not 0 the item is only deleted if the stored version still matches
+func DeleteChecklistItem(item ChecklistItem) error {
+	return deleteVersioned("ChecklistItems", item.Id, item.Version)
+}
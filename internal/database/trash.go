@@ -0,0 +1,298 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Returned when a task is restored while its category or parent task is still deleted
+var ErrDeletedParent error = errors.New("the category or parent task of the task is deleted")
+
+// A task in the trash. Subtasks deleted together with it are restored with it and not listed on their own
+type DeletedTask struct {
+	Task
+	Deleted_at time.Time `json:"deleted_at"`
+}
+
+// A category in the trash. Task_count counts the tasks deleted together with it, which are restored with it
+type DeletedCategory struct {
+	Categories
+	Deleted_at time.Time `json:"deleted_at"`
+	Task_count int64     `json:"task_count"`
+}
+
+// The deleted categories and tasks of a workspace, most recently deleted first
+type Trash struct {
+	Categories []DeletedCategory `json:"categories"`
+	Tasks      []DeletedTask     `json:"tasks"`
+}
+
+// Moves a task with its subtasks to the trash and increments its version. If task.Version is not 0 the task is only deleted if the
+// stored version still matches
+func DeleteTask(task Task) error {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockVersion(tx, "Task", task.Id, task.Version)
+	if err != nil {
+		return err
+	}
+	err = deleteTask(tx, task.Id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Marks the task and its subtasks as deleted at the same time. The task itself leaves its list by moving its key to deleted_rank, the
+// subtasks keep theirs, as nothing can be added to the subtasks of a deleted task. An archived task is taken out of the archive with its
+// subtasks, so that it returns to its list when it is restored, while a subtask stays archived with its parent. Returns ErrNoResult if
+// the task is already deleted
+func deleteTask(q querier, taskId int64) error {
+	query := `
+	WITH root AS (
+		UPDATE "Task" t SET deleted_at = now(),
+			deleted_rank = coalesce(t.rank, (SELECT a.rank FROM "CategoryTasks" a WHERE a.task_id = t.id), t.archived_rank),
+			rank = NULL, archived_at = CASE WHEN t.parent_task_id IS NULL THEN NULL ELSE t.archived_at END, archived_rank = NULL,
+			version = version + 1
+		WHERE t.id = $1 AND t.deleted_at IS NULL
+		RETURNING t.id
+	)
+	UPDATE "CategoryTasks" SET rank = NULL WHERE task_id IN (SELECT id FROM root)`
+	err := expectOneRow(q.Exec(query, taskId))
+	if err != nil {
+		return err
+	}
+	query = subtreeQuery + `
+	UPDATE "Task" SET deleted_at = coalesce(deleted_at, now()), archived_at = (SELECT archived_at FROM "Task" WHERE id = $1)
+	WHERE id IN (SELECT id FROM tree)`
+	_, err = q.Exec(query, taskId)
+	if err != nil {
+		return fmt.Errorf("failed to delete subtasks: %v", err)
+	}
+	return nil
+}
+
+// Moves a category with its tasks to the trash and increments its version. An archived category is taken out of the archive. If
+// category.Version is not 0 the category is only deleted if the stored version still matches
+func DeleteCategory(category Categories) error {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockVersion(tx, "Categories", category.Id, category.Version)
+	if err != nil {
+		return err
+	}
+	query := `
+	UPDATE "Categories" SET deleted_at = now(), deleted_rank = coalesce(rank, archived_rank), rank = NULL, archived_at = NULL, archived_rank = NULL,
+		version = version + 1
+	WHERE id = $1 AND deleted_at IS NULL`
+	err = expectOneRow(tx.Exec(query, category.Id))
+	if err != nil {
+		return err
+	}
+	query = `UPDATE "Task" SET deleted_at = now() WHERE deleted_at IS NULL AND id IN (SELECT task_id FROM "CategoryTasks" WHERE category_id = $1)`
+	_, err = tx.Exec(query, category.Id)
+	if err != nil {
+		return fmt.Errorf("failed to delete tasks: %v", err)
+	}
+	return tx.Commit()
+}
+
+// Takes a task out of the trash together with the subtasks deleted with it and puts it back at its old position. Returns ErrNoResult if
+// the task is not in the trash, also if it was deleted with its category or parent, and ErrDeletedParent if those are deleted
+func RestoreTask(taskId int64) error {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var deletedRank sql.NullString
+	var parentId sql.NullInt64
+	var categoryId int64
+	var parentDeleted bool
+	query := `
+	SELECT t.deleted_rank, t.parent_task_id, a.category_id, c.deleted_at IS NOT NULL OR p.deleted_at IS NOT NULL
+	FROM "Task" t JOIN "CategoryTasks" a ON a.task_id = t.id JOIN "Categories" c ON c.id = a.category_id
+		LEFT JOIN "Task" p ON p.id = t.parent_task_id
+	WHERE t.id = $1 AND t.deleted_at IS NOT NULL
+	FOR UPDATE OF t`
+	err = tx.QueryRow(query, taskId).Scan(&deletedRank, &parentId, &categoryId, &parentDeleted)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoResult
+		}
+		return fmt.Errorf("query error: %v", err)
+	}
+	if !deletedRank.Valid {
+		return ErrNoResult
+	}
+	if parentDeleted {
+		return ErrDeletedParent
+	}
+
+	_, err = tx.Exec(subtreeQuery+`
+	UPDATE "Task" SET deleted_at = NULL
+	WHERE id IN (SELECT id FROM tree) AND deleted_at = (SELECT deleted_at FROM "Task" WHERE id = $1)`, taskId)
+	if err != nil {
+		return fmt.Errorf("failed to restore subtasks: %v", err)
+	}
+	if parentId.Valid {
+		err = subtaskList.lock(tx, parentId.Int64)
+		if err != nil {
+			return err
+		}
+		key, err := subtaskList.restoreKey(tx, parentId.Int64, deletedRank.String)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE "Task" SET rank = $1 WHERE id = $2`, key, taskId)
+		if err != nil {
+			return fmt.Errorf("failed to restore subtask: %v", err)
+		}
+	} else {
+		err = taskList.lock(tx, categoryId)
+		if err != nil {
+			return err
+		}
+		key, err := taskList.restoreKey(tx, categoryId, deletedRank.String)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE "CategoryTasks" SET rank = $1 WHERE task_id = $2`, key, taskId)
+		if err != nil {
+			return fmt.Errorf("failed to restore task: %v", err)
+		}
+	}
+	_, err = tx.Exec(`UPDATE "Task" SET deleted_at = NULL, deleted_rank = NULL, version = version + 1 WHERE id = $1`, taskId)
+	if err != nil {
+		return fmt.Errorf("failed to restore task: %v", err)
+	}
+	return tx.Commit()
+}
+
+// Takes a category out of the trash together with the tasks deleted with it and puts it back at its old position in its workspace.
+// Returns ErrNoResult if the category is not in the trash
+func RestoreCategory(categoryId int64) error {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var workspaceId int64
+	var deletedRank string
+	query := `SELECT workspace_id, deleted_rank FROM "Categories" WHERE id = $1 AND deleted_at IS NOT NULL FOR UPDATE`
+	err = tx.QueryRow(query, categoryId).Scan(&workspaceId, &deletedRank)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoResult
+		}
+		return fmt.Errorf("query error: %v", err)
+	}
+	err = categoryList.lock(tx, workspaceId)
+	if err != nil {
+		return err
+	}
+	key, err := categoryList.restoreKey(tx, workspaceId, deletedRank)
+	if err != nil {
+		return err
+	}
+
+	query = `
+	UPDATE "Task" SET deleted_at = NULL
+	WHERE deleted_at = (SELECT deleted_at FROM "Categories" WHERE id = $1)
+		AND id IN (SELECT task_id FROM "CategoryTasks" WHERE category_id = $1)`
+	_, err = tx.Exec(query, categoryId)
+	if err != nil {
+		return fmt.Errorf("failed to restore tasks: %v", err)
+	}
+	query = `UPDATE "Categories" SET rank = $1, deleted_at = NULL, deleted_rank = NULL, version = version + 1 WHERE id = $2`
+	_, err = tx.Exec(query, key, categoryId)
+	if err != nil {
+		return fmt.Errorf("failed to restore category: %v", err)
+	}
+	return tx.Commit()
+}
+
+// Returns the deleted categories of the workspace and the deleted tasks that can be restored, which are those whose category and parent
+// are not deleted
+func GetTrash(workspaceId int64) (Trash, error) {
+	query := `
+	SELECT ` + categoryColumns + `, c.deleted_at, (
+		SELECT count(*) FROM "CategoryTasks" a JOIN "Task" t ON t.id = a.task_id
+		WHERE a.category_id = c.id AND t.parent_task_id IS NULL AND t.deleted_at = c.deleted_at)
+	FROM "Categories" c
+	WHERE c.workspace_id = $1 AND c.deleted_at IS NOT NULL
+	ORDER BY c.deleted_at DESC, c.id`
+	rows, err := dbInstance.db.Query(query, workspaceId)
+	if err != nil {
+		return Trash{}, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	trash := Trash{Categories: []DeletedCategory{}, Tasks: []DeletedTask{}}
+	for rows.Next() {
+		var category DeletedCategory
+		category.Categories, err = scanCategory(rows, &category.Deleted_at, &category.Task_count)
+		if err != nil {
+			return Trash{}, fmt.Errorf("scan error: %v", err)
+		}
+		trash.Categories = append(trash.Categories, category)
+	}
+	if err = rows.Err(); err != nil {
+		return Trash{}, fmt.Errorf("rows error: %v", err)
+	}
+
+	query = `
+	SELECT ` + taskColumns + `, t.deleted_at
+	FROM "Task" t JOIN "CategoryTasks" a ON a.task_id = t.id JOIN "Categories" c ON c.id = a.category_id
+		LEFT JOIN "Task" p ON p.id = t.parent_task_id
+	WHERE c.workspace_id = $1 AND c.deleted_at IS NULL AND t.deleted_rank IS NOT NULL AND p.deleted_at IS NULL
+	ORDER BY t.deleted_at DESC, t.id`
+	rows, err = dbInstance.db.Query(query, workspaceId)
+	if err != nil {
+		return Trash{}, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var task DeletedTask
+		task.Task, err = scanTask(rows, &task.Deleted_at)
+		if err != nil {
+			return Trash{}, fmt.Errorf("scan error: %v", err)
+		}
+		trash.Tasks = append(trash.Tasks, task)
+	}
+	if err = rows.Err(); err != nil {
+		return Trash{}, fmt.Errorf("rows error: %v", err)
+	}
+	return trash, nil
+}
+
+// Deletes the categories and tasks that were moved to the trash before the given time for good, tasks together with their subtasks and
+// categories together with their tasks. Returns the number of deleted categories and tasks
+func PurgeTrash(before time.Time) (int64, error) {
+	var count int64
+	for _, table := range []string{"Categories", "Task"} {
+		result, err := dbInstance.db.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE deleted_at < $1`, table), before)
+		if err != nil {
+			return count, fmt.Errorf("failed to purge %s: %v", table, err)
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return count, err
+		}
+		count += rows
+	}
+	return count, nil
+}
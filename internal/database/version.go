@@ -0,0 +1,55 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Returned by conditional writes when the row exists but has a different version than the one the client based its change on
+var ErrVersionConflict error = errors.New("the entity was changed in the meantime")
+
+// Tells apart why a write conditioned on id and version affected no row: ErrNoResult if the row does not exist and ErrVersionConflict otherwise
+func missingOrConflict(q querier, table string, id int64) error {
+	var exists bool
+	err := q.QueryRow(fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM "%s" WHERE id = $1)`, table), id).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("query error: %v", err)
+	}
+	if !exists {
+		return ErrNoResult
+	}
+	return ErrVersionConflict
+}
+
+// Locks the row and checks its version. An expected version of 0 skips the check
+func lockVersion(tx *sql.Tx, table string, id int64, expected int64) error {
+	var version int64
+	err := tx.QueryRow(fmt.Sprintf(`SELECT version FROM "%s" WHERE id = $1 FOR UPDATE`, table), id).Scan(&version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoResult
+		}
+		return fmt.Errorf("query error: %v", err)
+	}
+	if expected != 0 && version != expected {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// Deletes the row if its version matches the expected one, unless that is 0
+func deleteVersioned(table string, id int64, expected int64) error {
+	result, err := dbInstance.db.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE id = $1 AND ($2 = 0 OR version = $2)`, table), id, expected)
+	if err != nil {
+		return fmt.Errorf("failed to delete from %s: %v", table, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return missingOrConflict(dbInstance.db, table, id)
+	}
+	return nil
+}
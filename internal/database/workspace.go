@@ -0,0 +1,387 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Postgres error code for unique_violation
+const uniqueViolation = "23505"
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
+
+// Creates the personal workspace of a freshly registered user inside the transaction that inserts the user
+func createPersonalWorkspace(tx *sql.Tx, userId int64, username string) error {
+	var workspaceId int64
+	query := `INSERT INTO "Workspace" ("name", "personal", "created_by") VALUES ($1, true, $2) RETURNING id`
+	err := tx.QueryRow(query, username, userId).Scan(&workspaceId)
+	if err != nil {
+		return fmt.Errorf("failed to insert personal workspace: %v", err)
+	}
+	query = `INSERT INTO "WorkspaceMembers" ("workspace_id", "user_id", "role") VALUES ($1, $2, $3)`
+	_, err = tx.Exec(query, workspaceId, userId, RoleOwner)
+	if err != nil {
+		return fmt.Errorf("failed to insert workspace owner: %v", err)
+	}
+	return nil
+}
+
+// Returns the id of the personal workspace of a user or ErrNoResult if the user does not exist
+func GetPersonalWorkspaceId(username string) (int64, error) {
+	query := `SELECT w.id FROM "Workspace" w JOIN "User" u ON u.id = w.created_by WHERE w.personal AND u.username = $1`
+	var workspaceId int64
+	err := dbInstance.db.QueryRow(query, username).Scan(&workspaceId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoResult
+		}
+		return 0, fmt.Errorf("query error: %v", err)
+	}
+	return workspaceId, nil
+}
+
+// Returns all workspaces the user is a member of together with the user's role in them, the personal workspace first
+func GetWorkspacesByUsername(username string) ([]Workspace, error) {
+	query := `
+	SELECT w.id, w.name, w.personal, m.role
+	FROM "Workspace" w JOIN "WorkspaceMembers" m ON m.workspace_id = w.id JOIN "User" u ON u.id = m.user_id
+	WHERE u.username = $1
+	ORDER BY w.personal DESC, w.name, w.id`
+	rows, err := dbInstance.db.Query(query, username)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	var workspaces []Workspace
+	for rows.Next() {
+		var workspace Workspace
+		err := rows.Scan(&workspace.Id, &workspace.Name, &workspace.Personal, &workspace.Role)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		workspaces = append(workspaces, workspace)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return workspaces, nil
+}
+
+// Returns the workspace with the given id or ErrNoResult. The Role field is left empty
+func GetWorkspaceById(workspaceId int64) (Workspace, error) {
+	query := `SELECT w.id, w.name, w.personal FROM "Workspace" w WHERE w.id = $1`
+	var workspace Workspace
+	err := dbInstance.db.QueryRow(query, workspaceId).Scan(&workspace.Id, &workspace.Name, &workspace.Personal)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Workspace{}, ErrNoResult
+		}
+		return Workspace{}, fmt.Errorf("query error: %v", err)
+	}
+	return workspace, nil
+}
+
+// Returns the role of the user in a workspace or ErrNoResult if the user is not a member
+func GetWorkspaceRole(workspaceId int64, username string) (string, error) {
+	query := `SELECT m.role FROM "WorkspaceMembers" m JOIN "User" u ON u.id = m.user_id WHERE m.workspace_id = $1 AND u.username = $2`
+	return queryRole(query, workspaceId, username)
+}
+
+// Returns the role of the user for a category, granted either by the workspace owning it or by a share, or ErrNoResult if the user has no access to it
+// or it is deleted
+func GetRoleByCategoryId(category_id int64, username string) (string, error) {
+	query := `
+	SELECT ca.role
+	FROM "CategoryAccess" ca JOIN "User" u ON u.id = ca.user_id JOIN "Categories" c ON c.id = ca.category_id
+	WHERE ca.category_id = $1 AND u.username = $2 AND c.deleted_at IS NULL`
+	return queryRole(query, category_id, username)
+}
+
+// Returns the role of the user for the category of the task or ErrNoResult if the user has no access to it or the task is deleted
+func GetRoleByTaskId(task_id int64, username string) (string, error) {
+	query := `
+	SELECT ca.role
+	FROM "CategoryTasks" a JOIN "CategoryAccess" ca ON ca.category_id = a.category_id JOIN "User" u ON u.id = ca.user_id
+		JOIN "Task" t ON t.id = a.task_id
+	WHERE a.task_id = $1 AND u.username = $2 AND t.deleted_at IS NULL`
+	return queryRole(query, task_id, username)
+}
+
+// Returns the roles of the user for the categories of the tasks, keyed by task id. Tasks the user has no access to and deleted tasks are missing
+// from the map
+func GetRolesByTaskIds(task_ids []int64, username string) (map[int64]string, error) {
+	query := `
+	SELECT a.task_id, ca.role
+	FROM "CategoryTasks" a JOIN "CategoryAccess" ca ON ca.category_id = a.category_id JOIN "User" u ON u.id = ca.user_id
+		JOIN "Task" t ON t.id = a.task_id
+	WHERE a.task_id = ANY($1) AND u.username = $2 AND t.deleted_at IS NULL`
+	return queryRoles(query, task_ids, username)
+}
+
+// Returns the roles of the user for the categories, keyed by category id. Categories the user has no access to and deleted categories are missing
+// from the map
+func GetRolesByCategoryIds(category_ids []int64, username string) (map[int64]string, error) {
+	query := `
+	SELECT ca.category_id, ca.role
+	FROM "CategoryAccess" ca JOIN "User" u ON u.id = ca.user_id JOIN "Categories" c ON c.id = ca.category_id
+	WHERE ca.category_id = ANY($1) AND u.username = $2 AND c.deleted_at IS NULL`
+	return queryRoles(query, category_ids, username)
+}
+
+// Returns the role of the user for the category of a deleted task or ErrNoResult if the user has no access to it or the task is not deleted
+func GetRoleByDeletedTaskId(task_id int64, username string) (string, error) {
+	query := `
+	SELECT ca.role
+	FROM "CategoryTasks" a JOIN "CategoryAccess" ca ON ca.category_id = a.category_id JOIN "User" u ON u.id = ca.user_id
+		JOIN "Task" t ON t.id = a.task_id
+	WHERE a.task_id = $1 AND u.username = $2 AND t.deleted_at IS NOT NULL`
+	return queryRole(query, task_id, username)
+}
+
+// Returns the role of the user for a deleted category or ErrNoResult if the user has no access to it or it is not deleted
+func GetRoleByDeletedCategoryId(category_id int64, username string) (string, error) {
+	query := `
+	SELECT ca.role
+	FROM "CategoryAccess" ca JOIN "User" u ON u.id = ca.user_id JOIN "Categories" c ON c.id = ca.category_id
+	WHERE ca.category_id = $1 AND u.username = $2 AND c.deleted_at IS NOT NULL`
+	return queryRole(query, category_id, username)
+}
+
+func queryRoles(query string, ids []int64, username string) (map[int64]string, error) {
+	rows, err := dbInstance.db.Query(query, ids, username)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	roles := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var role string
+		err = rows.Scan(&id, &role)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		roles[id] = role
+	}
+	return roles, rows.Err()
+}
+
+func queryRole(query string, id int64, username string) (string, error) {
+	var role string
+	err := dbInstance.db.QueryRow(query, id, username).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoResult
+		}
+		return "", fmt.Errorf("query error: %v", err)
+	}
+	return role, nil
+}
+
+// Inserts a new shared workspace and makes the user with the given id its owner
+func AddWorkspace(workspace Workspace, ownerId int64) (Workspace, error) {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return Workspace{}, err
+	}
+	query := `INSERT INTO "Workspace" ("name", "personal", "created_by") VALUES ($1, false, $2) RETURNING id`
+	err = tx.QueryRow(query, workspace.Name, ownerId).Scan(&workspace.Id)
+	if err != nil {
+		tx.Rollback()
+		return Workspace{}, fmt.Errorf("failed to insert workspace: %v", err)
+	}
+	query = `INSERT INTO "WorkspaceMembers" ("workspace_id", "user_id", "role") VALUES ($1, $2, $3)`
+	_, err = tx.Exec(query, workspace.Id, ownerId, RoleOwner)
+	if err != nil {
+		tx.Rollback()
+		return Workspace{}, fmt.Errorf("failed to insert workspace owner: %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return Workspace{}, err
+	}
+	workspace.Personal = false
+	workspace.Role = RoleOwner
+	return workspace, nil
+}
+
+func UpdateWorkspace(workspace Workspace) error {
+	query := `UPDATE "Workspace" SET name = $1 WHERE id = $2`
+	return expectOneRow(dbInstance.db.Exec(query, workspace.Name, workspace.Id))
+}
+
+// Deletes a workspace. Its categories, tasks, members and invitations are removed by the cascading foreign keys
+func DeleteWorkspace(workspaceId int64) error {
+	query := `DELETE FROM "Workspace" WHERE id = $1`
+	return expectOneRow(dbInstance.db.Exec(query, workspaceId))
+}
+
+func GetWorkspaceMembers(workspaceId int64) ([]WorkspaceMember, error) {
+	query := `
+	SELECT m.workspace_id, m.user_id, u.username, m.role
+	FROM "WorkspaceMembers" m JOIN "User" u ON u.id = m.user_id
+	WHERE m.workspace_id = $1
+	ORDER BY u.username`
+	rows, err := dbInstance.db.Query(query, workspaceId)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	var members []WorkspaceMember
+	for rows.Next() {
+		var member WorkspaceMember
+		err := rows.Scan(&member.Workspace_id, &member.User_id, &member.Username, &member.Role)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		members = append(members, member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return members, nil
+}
+
+func CountWorkspaceOwners(workspaceId int64) (int64, error) {
+	query := `SELECT count(*) FROM "WorkspaceMembers" WHERE workspace_id = $1 AND role = $2`
+	var count int64
+	err := dbInstance.db.QueryRow(query, workspaceId, RoleOwner).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("query error: %v", err)
+	}
+	return count, nil
+}
+
+// Returns ErrNoResult if the user is not a member of the workspace
+func UpdateWorkspaceMemberRole(workspaceId int64, userId int64, role string) error {
+	query := `UPDATE "WorkspaceMembers" SET role = $1 WHERE workspace_id = $2 AND user_id = $3`
+	return expectOneRow(dbInstance.db.Exec(query, role, workspaceId, userId))
+}
+
+// Returns ErrNoResult if the user is not a member of the workspace
+func RemoveWorkspaceMember(workspaceId int64, userId int64) error {
+	query := `DELETE FROM "WorkspaceMembers" WHERE workspace_id = $1 AND user_id = $2`
+	return expectOneRow(dbInstance.db.Exec(query, workspaceId, userId))
+}
+
+// Inserts an invitation for a user into a workspace. Returns ErrAlreadyExists if the user was already invited
+func AddWorkspaceInvitation(workspaceId int64, invitedUserId int64, invitedById int64, role string) (int64, error) {
+	query := `INSERT INTO "WorkspaceInvitations" ("workspace_id", "invited_user", "invited_by", "role") VALUES ($1, $2, $3, $4) RETURNING id`
+	var invitationId int64
+	err := dbInstance.db.QueryRow(query, workspaceId, invitedUserId, invitedById, role).Scan(&invitationId)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, ErrAlreadyExists
+		}
+		return 0, fmt.Errorf("failed to insert invitation: %v", err)
+	}
+	return invitationId, nil
+}
+
+const invitationSelect = `
+	SELECT i.id, i.workspace_id, w.name, iu.username, COALESCE(bu.username, ''), i.role, i.created_at
+	FROM "WorkspaceInvitations" i
+	JOIN "Workspace" w ON w.id = i.workspace_id
+	JOIN "User" iu ON iu.id = i.invited_user
+	LEFT JOIN "User" bu ON bu.id = i.invited_by`
+
+// Returns the pending invitations addressed to a user
+func GetInvitationsByUsername(username string) ([]WorkspaceInvitation, error) {
+	return queryInvitations(invitationSelect+` WHERE iu.username = $1 ORDER BY i.created_at`, username)
+}
+
+// Returns the pending invitations of a workspace
+func GetInvitationsByWorkspaceId(workspaceId int64) ([]WorkspaceInvitation, error) {
+	return queryInvitations(invitationSelect+` WHERE i.workspace_id = $1 ORDER BY i.created_at`, workspaceId)
+}
+
+// Returns the invitation with the given id or ErrNoResult
+func GetWorkspaceInvitationById(invitationId int64) (WorkspaceInvitation, error) {
+	invitations, err := queryInvitations(invitationSelect+` WHERE i.id = $1`, invitationId)
+	if err != nil {
+		return WorkspaceInvitation{}, err
+	}
+	if len(invitations) == 0 {
+		return WorkspaceInvitation{}, ErrNoResult
+	}
+	return invitations[0], nil
+}
+
+func queryInvitations(query string, arg any) ([]WorkspaceInvitation, error) {
+	rows, err := dbInstance.db.Query(query, arg)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	var invitations []WorkspaceInvitation
+	for rows.Next() {
+		var i WorkspaceInvitation
+		err := rows.Scan(&i.Id, &i.Workspace_id, &i.Workspace_name, &i.Username, &i.Invited_by, &i.Role, &i.Created_at)
+		if err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		invitations = append(invitations, i)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return invitations, nil
+}
+
+// Turns the invitation into a membership of the invited user. Returns ErrNoResult if there is no such invitation for this user
+func AcceptWorkspaceInvitation(invitationId int64, userId int64) error {
+	tx, err := dbInstance.db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	var workspaceId int64
+	var role string
+	query := `DELETE FROM "WorkspaceInvitations" WHERE id = $1 AND invited_user = $2 RETURNING workspace_id, role`
+	err = tx.QueryRow(query, invitationId, userId).Scan(&workspaceId, &role)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoResult
+		}
+		return fmt.Errorf("failed to delete invitation: %v", err)
+	}
+	query = `INSERT INTO "WorkspaceMembers" ("workspace_id", "user_id", "role") VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`
+	_, err = tx.Exec(query, workspaceId, userId, role)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to insert workspace member: %v", err)
+	}
+	return tx.Commit()
+}
+
+// Returns ErrNoResult if there is no such invitation
+func DeleteWorkspaceInvitation(invitationId int64) error {
+	query := `DELETE FROM "WorkspaceInvitations" WHERE id = $1`
+	return expectOneRow(dbInstance.db.Exec(query, invitationId))
+}
+
+// Turns the result of a single row UPDATE or DELETE into ErrNoResult if no row was affected
+func expectOneRow(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoResult
+	}
+	return nil
+}
@@ -1,8 +1,33 @@
 const BASE_URL = "http://localhost:8080/tasks/"
+const API_URL = "http://localhost:8080/api/v1/"
+
+// The states of a task and their labels
+const STATE_NAMES = {
+    todo: "Offen",
+    in_progress: "In Arbeit",
+    blocked: "Blockiert",
+    done: "Erledigt",
+    cancelled: "Abgebrochen"
+};
+
+// The priorities of a task and their labels
+const PRIORITY_NAMES = {
+    none: "Keine Priorität",
+    low: "Niedrig",
+    medium: "Mittel",
+    high: "Hoch",
+    urgent: "Dringend"
+};
+
+// The selected workspace is kept in the query string. 0 selects the personal workspace
+const currentWorkspace = parseInt(new URLSearchParams(location.search).get("workspace")) || 0;
 
 document.addEventListener('DOMContentLoaded', () => {
 
+    loadWorkspaces();
+    syncTimezone();
     loadTasksAndCategories();
+    initSearch();
 
     document.getElementById('category-form').addEventListener('submit', (e) => {
         e.preventDefault();
@@ -11,7 +36,8 @@ document.addEventListener('DOMContentLoaded', () => {
 
         let rqbody = {
             order: 1,
-            name: categoryTitle
+            name: categoryTitle,
+            workspace_id: currentWorkspace
         }
 
         let request = new Request(BASE_URL + "addCategory", {
@@ -33,75 +59,35 @@ document.addEventListener('DOMContentLoaded', () => {
         })
     });
 
+    // Only the edited field is sent, so concurrent edits of other fields are not overwritten
     document.addEventListener('change', (e) => {
         if (e.target.classList.contains('editable')) {
-            let state = 0;
-            if (e.target.parentElement.style.textDecoration == "line-through") {
-                state = 1;
-            }
-            let belongs_to = parseInt(e.target.parentElement.parentElement.id.replace("c", ""));
-            let rqbody = {
-                id: parseInt(e.target.parentElement.id.replace("t", "")),
-                title: e.target.parentElement.querySelector(".task-title-input").value,
-                details: e.target.parentElement.querySelector(".task-details-input").value,     
-                due: e.target.parentElement.querySelector(".task-due-input").value,
-                state: state,
-                belongs_to: belongs_to
-            }
-    
-            let request = new Request(BASE_URL + "updateTask", {
-                body: JSON.stringify(rqbody),
-                method: "POST",
-                headers: {
-                    "Content-Type": "application/json"
-                }
-            });
-            fetch(request).then(response => {
-                if (!response.ok) {
-                    throw new Error("Network resposne was not ok");
-                }
-                return response.json();
-            }).then(() => {
+            let fields = {};
+            if (e.target.classList.contains('task-title-input')) {
+                fields.title = e.target.value;
+            } else if (e.target.classList.contains('task-details-input')) {
+                fields.details = e.target.value;
+            } else if (e.target.classList.contains('task-due-input')) {
+                fields.due = toDue(e.target.value);
+            } else if (e.target.classList.contains('task-recurrence-input')) {
+                fields.recurrence = e.target.value.trim() || null;
+            } else if (e.target.classList.contains('task-priority-select')) {
+                fields.priority = e.target.value;
+            } else if (e.target.classList.contains('task-state-select')) {
+                setState(e.target.parentElement, e.target.value);
                 return;
-            }).catch(() => {
-                alert("Fehler beim ändern!");
-            })
+            } else {
+                return;
+            }
+            patchTask(parseInt(e.target.parentElement.id.replace("t", "")), fields)
+            .catch(handleWriteError)
         }
     });
 
     document.addEventListener('click', (e) => {
-        if (e.target.classList.contains('editable')) {
-            let state = 0;
-            if (e.target.parentElement.style.textDecoration == "line-through") {
-                state = 1;
-            }
-            let belongs_to = parseInt(e.target.parentElement.parentElement.id.replace("c", ""));
-            let rqbody = {
-                id: parseInt(e.target.parentElement.id.replace("t", "")),
-                title: e.target.parentElement.querySelector(".task-title-input").value,
-                details: e.target.parentElement.querySelector(".task-details-input").value,     
-                due: e.target.parentElement.querySelector(".task-due-input").value,
-                state: state,
-                belongs_to: belongs_to
-            }
-    
-            let request = new Request(BASE_URL + "updateTask", {
-                body: JSON.stringify(rqbody),
-                method: "POST",
-                headers: {
-                    "Content-Type": "application/json"
-                }
-            });
-            fetch(request).then(response => {
-                if (!response.ok) {
-                    throw new Error("Network resposne was not ok");
-                }
-                return response.json();
-            }).then(() => {
-                return;
-            }).catch(() => {
-                alert("Fehler beim ändern!");
-            })
+        if (e.target.classList.contains('complete')) {
+            const todoItem = e.target.parentElement;
+            setState(todoItem, todoItem.querySelector('.task-state-select').value == 'done' ? 'todo' : 'done');
         }
     });
     
@@ -184,7 +170,7 @@ document.addEventListener('DOMContentLoaded', () => {
                         id: parseInt(draggedItem.id.replace("c", "")),
                         order: categoryArray.indexOf(draggedItem) + 1
                     }
-                    let request = new Request(BASE_URL + "relocateCategory", {
+                    let request = new Request(BASE_URL + "relocateCategory?workspace=" + currentWorkspace, {
                         body: JSON.stringify(rqbody),
                         method: "POST",
                         headers: {
@@ -203,37 +189,12 @@ document.addEventListener('DOMContentLoaded', () => {
                     })
                 break;
                 case 'todo-item draggable hidden':
-                    let state = 0;
-                    if (draggedItem.style.textDecoration == "line-through") {
-                        state = 1;
-                    }
+                    // The task keeps its id, so only the position has to be sent
                     let taskArray = Array.from(draggedItem.parentElement.querySelectorAll('.todo-item'));
-                    let rqbodytask = {
-                        id: parseInt(draggedItem.id.replace("t", "")),
-                        title: draggedItem.querySelector(".task-title-input").value,
-                        details: draggedItem.querySelector(".task-details-input").value,     
-                        due: draggedItem.querySelector(".task-due-input").value,
-                        state: state,
+                    patchTask(parseInt(draggedItem.id.replace("t", "")), {
                         belongs_to: parseInt(draggedItem.parentElement.id.replace("c", "")),
                         order: taskArray.indexOf(draggedItem) + 1
-                    }
-                    let requesttask = new Request(BASE_URL + "relocateTask", {
-                        body: JSON.stringify(rqbodytask),
-                        method: "POST",
-                        headers: {
-                            "Content-Type": "application/json"
-                        }
-                    });
-                    fetch(requesttask).then(response => {
-                        if (!response.ok) {
-                            throw new Error("Network resposne was not ok");
-                        }
-                        return response.json();
-                    }).then((taskdata) => {
-                        location.reload();
-                    }).catch(() => {
-                        alert("Fehler beim ändern!");
-                    })
+                    }).catch(handleWriteError)
                 break;
             }
             
@@ -348,8 +309,8 @@ function addCategory(categorydata) {
                 belongs_to: categorydata.id,
                 title: "",
                 order: 1,
-                state: 0,
-                due: "",
+                state: "todo",
+                due: null,
                 details: ""
             }),
             method: "POST",
@@ -387,6 +348,7 @@ function addTodoItem(categoryContainer, taskdata) {
     todoItem.className = 'todo-item draggable';
     todoItem.draggable = true;
     todoItem.id = "t" + taskdata.id;
+    todoItem.dataset.version = taskdata.version;
 
     const dragHandle = document.createElement('span');
     dragHandle.className = 'drag-handle';
@@ -407,22 +369,41 @@ function addTodoItem(categoryContainer, taskdata) {
     const dueDateInput = document.createElement('input');
     dueDateInput.type = 'datetime-local';
     dueDateInput.className = 'editable task-due-input';
-    dueDateInput.value = taskdata.due;
+    dueDateInput.value = toDateTimeLocal(taskdata.due);
+
+    const recurrenceInput = document.createElement('input');
+    recurrenceInput.type = 'text';
+    recurrenceInput.placeholder = 'Wiederholung (z.B. FREQ=WEEKLY)';
+    recurrenceInput.title = 'iCalendar RRULE, gilt für die ganze Serie';
+    recurrenceInput.className = 'editable task-recurrence-input';
+    recurrenceInput.value = taskdata.recurrence || '';
+
+    const stateSelect = document.createElement('select');
+    stateSelect.className = 'editable task-state-select';
+    Object.entries(STATE_NAMES).forEach(([state, name]) => {
+        const option = document.createElement('option');
+        option.value = state;
+        option.textContent = name;
+        stateSelect.appendChild(option);
+    });
+    stateSelect.value = taskdata.state;
+    stateSelect.dataset.current = taskdata.state;
+
+    const prioritySelect = document.createElement('select');
+    prioritySelect.className = 'editable task-priority-select';
+    Object.entries(PRIORITY_NAMES).forEach(([priority, name]) => {
+        const option = document.createElement('option');
+        option.value = priority;
+        option.textContent = name;
+        prioritySelect.appendChild(option);
+    });
+    prioritySelect.value = taskdata.priority || 'none';
 
     const completeButton = document.createElement('button');
     completeButton.textContent = 'Erledigt';
     completeButton.className = 'complete editable';
 
-    if (taskdata.state != 0) {
-        todoItem.style.textDecoration = 'line-through';
-    }
-    completeButton.onclick = () => {
-        if (todoItem.style.textDecoration === 'line-through') {
-            todoItem.style.textDecoration = 'none';
-        } else {
-            todoItem.style.textDecoration = 'line-through';
-        }
-    };
+    showState(todoItem, taskdata.state);
 
     const deleteButton = document.createElement('button');
     deleteButton.textContent = 'Löschen';
@@ -432,7 +413,8 @@ function addTodoItem(categoryContainer, taskdata) {
         let rqbody = {
             belongs_to: taskdata.belongs_to,
             id: taskdata.id,
-            order: taskArray.indexOf(deleteButton.parentElement) + 1
+            order: taskArray.indexOf(deleteButton.parentElement) + 1,
+            version: parseInt(todoItem.dataset.version)
         };
         let request = new Request(BASE_URL + "deleteTask", {
             body: JSON.stringify(rqbody),
@@ -443,6 +425,9 @@ function addTodoItem(categoryContainer, taskdata) {
         });
         fetch(request)
         .then(response => {
+            if (response.status == 409) {
+                throw new ConflictError();
+            }
             if (!response.ok) {
                 throw new Error("Network response was not ok");
             }
@@ -450,10 +435,7 @@ function addTodoItem(categoryContainer, taskdata) {
         .then(() => {
             categoryContainer.removeChild(todoItem);
         })
-        .catch(error => {
-            console.error('Error:', error);
-            alert("Fehler beim Hinzufügen der Aufgabe!");
-        });
+        .catch(handleWriteError);
         
     }
 
@@ -461,12 +443,108 @@ function addTodoItem(categoryContainer, taskdata) {
     todoItem.appendChild(titleInput);
     todoItem.appendChild(detailsInput);
     todoItem.appendChild(dueDateInput);
+    todoItem.appendChild(recurrenceInput);
+    todoItem.appendChild(prioritySelect);
+    todoItem.appendChild(stateSelect);
     todoItem.appendChild(completeButton);
     todoItem.appendChild(deleteButton);
 
+    if (taskdata.progress) {
+        const progress = document.createElement('span');
+        progress.className = 'task-progress';
+        progress.textContent = taskdata.progress.done + "/" + taskdata.progress.total;
+        todoItem.insertBefore(progress, stateSelect);
+    }
+    for (const label of taskdata.labels || []) {
+        const chip = document.createElement('span');
+        chip.className = 'task-label';
+        chip.textContent = label.name;
+        chip.style.backgroundColor = label.color;
+        todoItem.insertBefore(chip, stateSelect);
+    }
+    if (taskdata.comment_count > 0) {
+        const comments = document.createElement('span');
+        comments.className = 'task-comments';
+        comments.textContent = taskdata.comment_count == 1 ? "1 Kommentar" : taskdata.comment_count + " Kommentare";
+        todoItem.insertBefore(comments, stateSelect);
+    }
+    for (const assignee of taskdata.assignees || []) {
+        const chip = document.createElement('span');
+        chip.className = 'task-assignee';
+        chip.textContent = "@" + assignee.username;
+        todoItem.insertBefore(chip, stateSelect);
+    }
+    if (taskdata.subtasks || taskdata.checklist) {
+        todoItem.appendChild(createSteps(taskdata));
+    }
+
     categoryContainer.appendChild(todoItem);
 }
 
+// Lists the subtasks and checklist items of a task below it. Ticking one off changes it right away and reloads the list for the new progress
+function createSteps(taskdata) {
+    const steps = document.createElement('div');
+    steps.className = 'task-steps';
+
+    (taskdata.subtasks || []).forEach(subtask => {
+        const step = createStep(subtask.title, subtask.state == 'done', done => patchTask(subtask.id, {state: done ? 'done' : 'todo'}));
+        step.id = "t" + subtask.id;
+        step.dataset.version = subtask.version;
+        if (subtask.progress) {
+            step.querySelector('label').append(" (" + subtask.progress.done + "/" + subtask.progress.total + ")");
+        }
+        steps.appendChild(step);
+    });
+    (taskdata.checklist || []).forEach(item => {
+        const step = createStep(item.text, item.done, done => patchChecklistItem(item, {done: done}));
+        step.classList.add('checklist-item');
+        steps.appendChild(step);
+    });
+    return steps;
+}
+
+function createStep(text, done, change) {
+    const step = document.createElement('div');
+    step.className = 'task-step';
+    const checkbox = document.createElement('input');
+    checkbox.type = 'checkbox';
+    checkbox.checked = done;
+    checkbox.onchange = () => {
+        change(checkbox.checked)
+        .then(() => loadTasksAndCategories())
+        .catch(error => {
+            checkbox.checked = !checkbox.checked;
+            handleWriteError(error);
+        });
+    };
+    const label = document.createElement('label');
+    label.textContent = text;
+    step.appendChild(checkbox);
+    step.appendChild(label);
+    return step;
+}
+
+// Sends a partial update of a checklist item, which only applies if the item still has the version it was loaded with
+function patchChecklistItem(item, fields) {
+    let request = new Request(API_URL + "checklist/" + item.id, {
+        body: JSON.stringify(fields),
+        method: "PATCH",
+        headers: {
+            "Content-Type": "application/json",
+            "If-Match": '"' + item.version + '"'
+        }
+    });
+    return fetch(request).then(response => {
+        if (response.status == 409 || response.status == 412) {
+            throw new ConflictError();
+        }
+        if (!response.ok) {
+            throw new Error("Network response was not ok");
+        }
+        return response.json();
+    });
+}
+
 function renderTodoList(data) {
     const container = document.getElementById('categories');
     container.innerHTML = '';
@@ -494,8 +572,169 @@ function renderTodoList(data) {
     });
 }
 
+// Thrown if the server rejects a change because someone else changed the entity in the meantime
+class ConflictError extends Error {}
+
+// Thrown when the workspace does not allow a state change or open blockers prevent it. blockers lists the tasks the task waits for
+class TransitionError extends Error {
+    constructor(blockers) {
+        super();
+        this.blockers = blockers || [];
+    }
+}
+
+// Closed tasks are struck through
+function showState(todoItem, state) {
+    todoItem.style.textDecoration = (state == 'done' || state == 'cancelled') ? 'line-through' : 'none';
+}
+
+// Changes the state of a task. If the workspace does not allow the change, the previous state is shown again.
+// Closing a recurring task creates its next occurrence, so the list is reloaded
+function setState(todoItem, state) {
+    const stateSelect = todoItem.querySelector('.task-state-select');
+    const previous = stateSelect.dataset.current;
+    patchTask(parseInt(todoItem.id.replace("t", "")), {state: state})
+    .then(task => {
+        stateSelect.value = task.state;
+        stateSelect.dataset.current = task.state;
+        showState(todoItem, task.state);
+        if (task.series_id && (state == 'done' || state == 'cancelled')) {
+            loadTasksAndCategories();
+        }
+    })
+    .catch(error => {
+        stateSelect.value = previous;
+        showState(todoItem, previous);
+        handleWriteError(error);
+    });
+}
+
+// Offers to reload the page after a conflicting change, every other error is only reported
+function handleWriteError(error) {
+    if (error instanceof TransitionError) {
+        if (error.blockers.length > 0) {
+            alert("Diese Aufgabe wartet noch auf: " + error.blockers.map(blocker => blocker.title).join(", "));
+        } else {
+            alert("Dieser Statuswechsel ist in diesem Arbeitsbereich nicht erlaubt!");
+        }
+        return;
+    }
+    if (error instanceof ConflictError) {
+        if (confirm("Die Aufgabe wurde in der Zwischenzeit geändert. Seite neu laden?")) {
+            location.reload();
+        }
+        return;
+    }
+    console.error('Error:', error);
+    alert("Fehler beim ändern!");
+}
+
+// Sends a partial update of a task. Only the given fields are changed and only if the task still has the version it was loaded with
+function patchTask(taskId, fields) {
+    let todoItem = document.getElementById("t" + taskId);
+    let request = new Request(API_URL + "tasks/" + taskId, {
+        body: JSON.stringify(fields),
+        method: "PATCH",
+        headers: {
+            "Content-Type": "application/json",
+            "If-Match": '"' + todoItem.dataset.version + '"'
+        }
+    });
+    return fetch(request).then(response => {
+        if (response.status == 409 || response.status == 412) {
+            throw new ConflictError();
+        }
+        if (response.status == 422) {
+            return response.json().then(body => {
+                throw new TransitionError(body.blockers);
+            });
+        }
+        if (!response.ok) {
+            throw new Error("Network response was not ok");
+        }
+        return response.json();
+    }).then(task => {
+        todoItem.dataset.version = task.version;
+        return task;
+    });
+}
+
+// Converts the value of a datetime-local input, which is in the time zone of the browser, to RFC 3339. An empty input clears the due date
+function toDue(value) {
+    if (value === "") {
+        return null;
+    }
+    return new Date(value).toISOString();
+}
+
+// Converts an RFC 3339 due date to the local time a datetime-local input expects
+function toDateTimeLocal(due) {
+    if (!due) {
+        return "";
+    }
+    const date = new Date(due);
+    date.setMinutes(date.getMinutes() - date.getTimezoneOffset());
+    return date.toISOString().substring(0, 16);
+}
+
+// Stores the time zone of the browser as the user's time zone, so that all-day due dates fall on the right day
+function syncTimezone() {
+    const timezone = Intl.DateTimeFormat().resolvedOptions().timeZone;
+    fetch(API_URL + "settings")
+        .then(response => {
+            if (!response.ok) {
+                throw new Error("Network response was not ok");
+            }
+            return response.json();
+        })
+        .then(settings => {
+            if (!timezone || settings.timezone === timezone) {
+                return;
+            }
+            return fetch(API_URL + "settings", {
+                body: JSON.stringify({timezone: timezone}),
+                method: "PATCH",
+                headers: {
+                    "Content-Type": "application/json"
+                }
+            });
+        })
+        .catch(error => {
+            console.error('Error:', error);
+        });
+}
+
+function loadWorkspaces() {
+    const select = document.getElementById('workspace-select');
+    select.onchange = () => {
+        location.search = "?workspace=" + select.value;
+    };
+
+    fetch("http://localhost:8080/workspaces/get")
+        .then(response => {
+            if (!response.ok) {
+                throw new Error("Network response was not ok");
+            }
+            return response.json();
+        })
+        .then(workspaces => {
+            (workspaces || []).forEach(workspace => {
+                const option = document.createElement('option');
+                option.value = workspace.id;
+                option.textContent = workspace.personal ? "Persönlich" : workspace.name;
+                if (workspace.id === currentWorkspace || (currentWorkspace === 0 && workspace.personal)) {
+                    option.selected = true;
+                }
+                select.appendChild(option);
+            });
+        })
+        .catch(error => {
+            console.error('Error:', error);
+        });
+}
+
 function loadTasksAndCategories() {
-    const URL = "http://localhost:8080/tasks/get";
+    const URL = "http://localhost:8080/tasks/get?workspace=" + currentWorkspace;
     
     fetch(URL)
         .then(response => {
@@ -506,9 +745,89 @@ function loadTasksAndCategories() {
         })
         .then(data => {
             renderTodoList(data);
+            if (location.hash) {
+                showTask(location.hash.substring(1));
+            }
         })
         .catch(error => {
             console.error('Error:', error);
             alert("Fehler beim Laden der Aufgaben!");
         });
 }
+
+// Searches while typing and lists the best matches below the search field
+function initSearch() {
+    const input = document.getElementById('search-input');
+    const results = document.getElementById('search-results');
+    let timeout = null;
+
+    input.addEventListener('input', () => {
+        clearTimeout(timeout);
+        if (input.value.trim() === '') {
+            results.innerHTML = '';
+            return;
+        }
+        timeout = setTimeout(() => {
+            fetch(API_URL + "search?limit=10&q=" + encodeURIComponent(input.value))
+            .then(response => {
+                if (!response.ok) {
+                    throw new Error("Network response was not ok");
+                }
+                return response.json();
+            })
+            .then(tasks => {
+                results.innerHTML = '';
+                tasks.forEach(task => {
+                    const item = document.createElement('div');
+                    item.className = 'search-result';
+                    appendHighlighted(item, task.title_highlight || task.title);
+                    const category = document.createElement('small');
+                    category.textContent = task.category_name;
+                    item.appendChild(category);
+                    item.onclick = () => {
+                        results.innerHTML = '';
+                        openTask(task);
+                    };
+                    results.appendChild(item);
+                });
+            })
+            .catch(error => {
+                console.error('Error:', error);
+            });
+        }, 250);
+    });
+}
+
+// Appends the highlight of a search result. The text is never parsed as HTML, only the <mark> tags of the server become elements
+function appendHighlighted(element, highlight) {
+    highlight.split(/(<mark>.*?<\/mark>)/).forEach(part => {
+        if (part.startsWith('<mark>') && part.endsWith('</mark>')) {
+            const mark = document.createElement('mark');
+            mark.textContent = part.slice(6, -7);
+            element.appendChild(mark);
+        } else {
+            element.appendChild(document.createTextNode(part));
+        }
+    });
+}
+
+// Shows the task if it is on this page and otherwise opens the workspace it is listed in. Shared categories are listed in the personal workspace
+function openTask(task) {
+    if (document.getElementById("t" + task.id)) {
+        showTask("t" + task.id);
+        return;
+    }
+    const member = Array.from(document.getElementById('workspace-select').options).some(option => parseInt(option.value) === task.workspace_id);
+    location.href = "?workspace=" + (member ? task.workspace_id : 0) + "#t" + task.id;
+}
+
+function showTask(elementId) {
+    const todoItem = document.getElementById(elementId);
+    if (!todoItem) {
+        return;
+    }
+    todoItem.scrollIntoView({behavior: "smooth", block: "center"});
+    // A style instead of a class, as the drag and drop handlers compare the class names
+    todoItem.style.outline = '2px solid #f0c040';
+    setTimeout(() => todoItem.style.outline = '', 2000);
+}
@@ -0,0 +1,22 @@
+package jobs
+
+import (
+	"log"
+	"time"
+	"todolist/internal/database"
+)
+
+// Archives the tasks that have been done for longer than the auto-archive days of the users who can see them
+func AutoArchive(interval time.Duration) Job {
+	return Job{
+		Name:     "auto archive",
+		Interval: interval,
+		Run: func() error {
+			count, err := database.AutoArchiveTasks()
+			if count > 0 {
+				log.Printf("Archived %d done tasks\n", count)
+			}
+			return err
+		},
+	}
+}
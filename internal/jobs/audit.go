@@ -0,0 +1,22 @@
+package jobs
+
+import (
+	"log"
+	"time"
+	"todolist/internal/database"
+)
+
+// Deletes the entries of the audit log that are older than the retention period
+func AuditRetention(interval time.Duration, retention time.Duration) Job {
+	return Job{
+		Name:     "audit retention",
+		Interval: interval,
+		Run: func() error {
+			count, err := database.PurgeAuditLog(time.Now().Add(-retention))
+			if count > 0 {
+				log.Printf("Deleted %d audit log entries older than %v\n", count, retention)
+			}
+			return err
+		},
+	}
+}
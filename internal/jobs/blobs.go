@@ -0,0 +1,47 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+	"todolist/internal/database"
+	"todolist/internal/storage"
+)
+
+// The number of blobs deleted per query
+const blobBatch = 100
+
+// Deletes the blobs of deleted attachments from the store. A blob that can't be deleted stays queued and is tried again at the next run
+func BlobCleanup(interval time.Duration, store storage.BlobStore) Job {
+	return Job{
+		Name:     "blob cleanup",
+		Interval: interval,
+		Run: func() error {
+			count := 0
+			for {
+				keys, err := database.GetOrphanedBlobs(blobBatch)
+				if err != nil {
+					return err
+				}
+				for _, key := range keys {
+					err = store.Delete(context.Background(), key)
+					if err != nil {
+						return err
+					}
+					err = database.RemoveOrphanedBlob(key)
+					if err != nil {
+						return err
+					}
+					count++
+				}
+				if len(keys) < blobBatch {
+					break
+				}
+			}
+			if count > 0 {
+				log.Printf("Deleted %d blobs of deleted attachments\n", count)
+			}
+			return nil
+		},
+	}
+}
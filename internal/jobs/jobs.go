@@ -0,0 +1,33 @@
+// Package jobs runs periodic maintenance work next to the HTTP server
+package jobs
+
+import (
+	"log"
+	"time"
+)
+
+// A job is run once per interval. Errors are logged and the job is run again at the next interval
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func() error
+}
+
+// Starts every job in its own goroutine. The first run happens after one interval
+func Start(jobs ...Job) {
+	for _, job := range jobs {
+		go loop(job)
+	}
+}
+
+func loop(job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		started := time.Now()
+		err := job.Run()
+		if err != nil {
+			log.Printf("job %s failed after %v: %v\n", job.Name, time.Since(started), err)
+		}
+	}
+}
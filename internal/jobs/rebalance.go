@@ -0,0 +1,23 @@
+package jobs
+
+import (
+	"log"
+	"time"
+	"todolist/internal/database"
+	"todolist/internal/rank"
+)
+
+// Spreads the rank keys of lists whose keys grew too long from repeated insertions at the same position
+func Rebalance(interval time.Duration) Job {
+	return Job{
+		Name:     "rebalance",
+		Interval: interval,
+		Run: func() error {
+			count, err := database.RebalanceRanks(rank.MaxLength)
+			if count > 0 {
+				log.Printf("Rebalanced the rank keys of %d lists\n", count)
+			}
+			return err
+		},
+	}
+}
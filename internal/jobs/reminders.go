@@ -0,0 +1,53 @@
+package jobs
+
+import (
+	"log"
+	"time"
+	"todolist/internal/database"
+	"todolist/internal/notify"
+)
+
+// Reminders that should have fired longer ago, e.g. while the server was down, are skipped
+const reminderLateness = 24 * time.Hour
+
+// Records of sent reminders are kept this long after the due date they were sent for
+const reminderRetention = 30 * 24 * time.Hour
+
+// Sends the reminders that fired since the last run. Every reminder is recorded in the database before it is sent, so it is sent at most
+// once, also across restarts and server instances
+func Reminders(interval time.Duration, notifier notify.Notifier) Job {
+	return Job{
+		Name:     "reminders",
+		Interval: interval,
+		Run: func() error {
+			now := time.Now()
+			reminders, err := database.GetDueReminders(now, reminderLateness)
+			if err != nil {
+				return err
+			}
+			for _, reminder := range reminders {
+				claimed, err := database.ClaimReminder(reminder)
+				if err != nil {
+					return err
+				}
+				if !claimed {
+					continue
+				}
+				err = notifier.Notify(notify.Notification{
+					Task_id:        reminder.Task_id,
+					Title:          reminder.Title,
+					Due:            reminder.Due,
+					All_day:        reminder.All_day,
+					Offset_minutes: reminder.Offset_minutes,
+					Username:       reminder.Username,
+					Email:          reminder.Email,
+				})
+				if err != nil {
+					log.Printf("Failed to send the reminder about task %d to %s: %v\n", reminder.Task_id, reminder.Username, err)
+				}
+			}
+			_, err = database.PurgeSentReminders(now.Add(-reminderRetention))
+			return err
+		},
+	}
+}
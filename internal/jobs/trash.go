@@ -0,0 +1,22 @@
+package jobs
+
+import (
+	"log"
+	"time"
+	"todolist/internal/database"
+)
+
+// Deletes the categories and tasks that have been in the trash for longer than the retention period for good
+func TrashPurge(interval time.Duration, retention time.Duration) Job {
+	return Job{
+		Name:     "trash purge",
+		Interval: interval,
+		Run: func() error {
+			count, err := database.PurgeTrash(time.Now().Add(-retention))
+			if count > 0 {
+				log.Printf("Purged %d categories and tasks deleted more than %v ago\n", count, retention)
+			}
+			return err
+		},
+	}
+}
@@ -0,0 +1,61 @@
+package notify
+
+import (
+	"fmt"
+	"mime"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Sends plain text emails
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// A Mailer sending through an SMTP server. Without a username no authentication is used. From defaults to the username
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(to string, subject string, body string) error {
+	from := m.From
+	if from == "" {
+		from = m.Username
+	}
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	headers := []string{
+		"From: " + from,
+		"To: " + to,
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+	}
+	message := strings.Join(headers, "\r\n") + "\r\n\r\n" + strings.ReplaceAll(body, "\n", "\r\n") + "\r\n"
+	err := smtp.SendMail(m.Host+":"+m.Port, auth, from, []string{to}, []byte(message))
+	if err != nil {
+		return fmt.Errorf("failed to send email to %s: %v", to, err)
+	}
+	return nil
+}
+
+// Sends notifications by email. Users without an email address are skipped
+type Email struct {
+	Mailer Mailer
+}
+
+func (e Email) Notify(n Notification) error {
+	if n.Email == "" {
+		return nil
+	}
+	return e.Mailer.Send(n.Email, n.Subject(), n.Message())
+}
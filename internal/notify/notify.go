@@ -0,0 +1,91 @@
+// Package notify delivers reminders about due tasks to users, e.g. by email or to a webhook
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// A reminder for one user about one task. Due is in the time zone the task is shown in, Offset_minutes is how long before the due date the reminder fires
+type Notification struct {
+	Task_id        int64     `json:"task_id"`
+	Title          string    `json:"title"`
+	Due            time.Time `json:"due"`
+	All_day        bool      `json:"all_day"`
+	Offset_minutes int64     `json:"offset_minutes"`
+	Username       string    `json:"username"`
+	Email          string    `json:"-"`
+}
+
+// Delivers notifications. Implementations may skip a notification they can't deliver, like an email to a user without address
+type Notifier interface {
+	Notify(Notification) error
+}
+
+// Returns the subject of a notification
+func (n Notification) Subject() string {
+	title := n.Title
+	if strings.TrimSpace(title) == "" {
+		title = "Todo ohne Titel"
+	}
+	return "Erinnerung: " + title
+}
+
+// Returns the text of a notification
+func (n Notification) Message() string {
+	title := n.Title
+	if strings.TrimSpace(title) == "" {
+		title = "Todo ohne Titel"
+	}
+	if n.All_day {
+		return fmt.Sprintf("Das Todo „%s“ ist am %s fällig.", title, n.Due.Format("02.01.2006"))
+	}
+	return fmt.Sprintf("Das Todo „%s“ ist am %s um %s Uhr fällig.", title, n.Due.Format("02.01.2006"), n.Due.Format("15:04"))
+}
+
+// Writes notifications to the server log
+type Log struct{}
+
+func (Log) Notify(n Notification) error {
+	log.Printf("Reminder for %s about task %d: %s\n", n.Username, n.Task_id, n.Message())
+	return nil
+}
+
+// Delivers every notification with all notifiers. A failing notifier doesn't stop the others, their errors are joined
+type Multi []Notifier
+
+func (m Multi) Notify(n Notification) error {
+	var errs []error
+	for _, notifier := range m {
+		errs = append(errs, notifier.Notify(n))
+	}
+	return errors.Join(errs...)
+}
+
+// Builds the notifiers configured in the environment. Notifications are always logged, SMTP_HOST enables emails and
+// REMINDER_WEBHOOK_URL a webhook
+func FromEnv() Notifier {
+	notifiers := Multi{Log{}}
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		mailer := &SMTPMailer{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+		}
+		notifiers = append(notifiers, Email{Mailer: mailer})
+	}
+	if url := os.Getenv("REMINDER_WEBHOOK_URL"); url != "" {
+		notifiers = append(notifiers, NewWebhook(url))
+	}
+	return notifiers
+}
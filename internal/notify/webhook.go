@@ -0,0 +1,38 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Posts every notification as JSON to a URL. Any status other than 2xx is an error
+type Webhook struct {
+	Url    string
+	Client *http.Client
+}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{Url: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (w *Webhook) Notify(n Notification) error {
+	body, err := json.Marshal(struct {
+		Notification
+		Message string `json:"message"`
+	}{n, n.Message()})
+	if err != nil {
+		return err
+	}
+	response, err := w.Client.Post(w.Url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook failed: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook failed with status %s", response.Status)
+	}
+	return nil
+}
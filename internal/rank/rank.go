@@ -0,0 +1,110 @@
+// Package rank implements lexicographic ordering keys. A key is a base 62 fraction without the leading "0." and without
+// trailing zeros, so comparing two keys byte by byte compares their values. A key can always be generated between two others,
+// which lets a single row be moved without renumbering its siblings
+package rank
+
+import (
+	"errors"
+	"strings"
+)
+
+// The digits in ascending byte order. Columns holding keys must compare bytes, e.g. with COLLATE "C"
+const digits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+const base = len(digits)
+
+// Keys longer than this should be spread out again by rebalancing
+const MaxLength = 16
+
+var ErrInvalidKey error = errors.New("invalid rank key")
+var ErrNotAscending error = errors.New("rank keys are not in ascending order")
+
+// Reports whether the key consists of digits only and does not end with a zero
+func Valid(key string) bool {
+	if key == "" || key[len(key)-1] == digits[0] {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if strings.IndexByte(digits, key[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a key between before and after. An empty before stands for the start and an empty after for the end of the list
+func Between(before string, after string) (string, error) {
+	if (before != "" && !Valid(before)) || (after != "" && !Valid(after)) {
+		return "", ErrInvalidKey
+	}
+	if after != "" && before >= after {
+		return "", ErrNotAscending
+	}
+	return midpoint(before, after), nil
+}
+
+// Computes the key in the middle of a and b, where an empty b stands for 1. Digits missing at the end of a count as zeros
+func midpoint(a string, b string) string {
+	if b != "" {
+		n := 0
+		for n < len(b) && digitAt(a, n) == b[n] {
+			n++
+		}
+		if n > 0 {
+			return b[:n] + midpoint(a[min(n, len(a)):], b[n:])
+		}
+	}
+	low := 0
+	if a != "" {
+		low = strings.IndexByte(digits, a[0])
+	}
+	high := base
+	if b != "" {
+		high = strings.IndexByte(digits, b[0])
+	}
+	if high-low > 1 {
+		return string(digits[(low+high+1)/2])
+	}
+	// The first digits are adjacent, so the key has to become longer than one of them
+	if len(b) > 1 {
+		return b[:1]
+	}
+	rest := ""
+	if a != "" {
+		rest = a[1:]
+	}
+	return string(digits[low]) + midpoint(rest, "")
+}
+
+func digitAt(key string, i int) byte {
+	if i < len(key) {
+		return key[i]
+	}
+	return digits[0]
+}
+
+// Returns n ascending keys of equal length that are spread evenly, leaving room for insertions between all of them
+func Spread(n int) []string {
+	width, space := 1, uint64(base)
+	// Leave at least base-1 free keys between two neighbours
+	for space/uint64(n+1) < uint64(base) {
+		width++
+		space *= uint64(base)
+	}
+	step := space / uint64(n+1)
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = format(uint64(i+1)*step, width)
+	}
+	return keys
+}
+
+// Formats the value as a key of the given width and strips the trailing zeros
+func format(value uint64, width int) string {
+	b := make([]byte, width)
+	for i := width - 1; i >= 0; i-- {
+		b[i] = digits[value%uint64(base)]
+		value /= uint64(base)
+	}
+	return strings.TrimRight(string(b), digits[:1])
+}
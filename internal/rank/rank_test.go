@@ -0,0 +1,122 @@
+package rank
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"V", true},
+		{"0V", true},
+		{"zzz", true},
+		{"", false},
+		{"V0", false},
+		{"0", false},
+		{"V-", false},
+		{"ä", false},
+	}
+	for _, tt := range tests {
+		if got := Valid(tt.key); got != tt.want {
+			t.Errorf("Valid(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestBetween(t *testing.T) {
+	tests := []struct {
+		before, after string
+		want          string
+		wantErr       error
+	}{
+		{before: "", after: "", want: "V"},
+		{before: "", after: "V", want: "G"},
+		{before: "V", after: "", want: "l"},
+		{before: "A", after: "C", want: "B"},
+		{before: "A", after: "B", want: "AV"},
+		{before: "A", after: "A1", want: "A0V"},
+		{before: "z", after: "", want: "zV"},
+		{before: "", after: "1", want: "0V"},
+		{before: "AV", after: "B", want: "Al"},
+		{before: "A", after: "BV", want: "B"},
+		{before: "B", after: "A", wantErr: ErrNotAscending},
+		{before: "A", after: "A", wantErr: ErrNotAscending},
+		{before: "A0", after: "", wantErr: ErrInvalidKey},
+		{before: "", after: "-", wantErr: ErrInvalidKey},
+	}
+	for _, tt := range tests {
+		got, err := Between(tt.before, tt.after)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Between(%q, %q) error = %v, want %v", tt.before, tt.after, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Between(%q, %q) = %q, %v, want %q", tt.before, tt.after, got, err, tt.want)
+		}
+	}
+}
+
+// Inserting again and again at the same place must always find a valid key strictly between the neighbours
+func TestBetweenRepeatedly(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		before, after string
+		// Whether the next key goes in front of the new key, keeping the lower neighbour, or behind it, keeping the upper one
+		keepBefore bool
+	}{
+		{name: "at the start", keepBefore: true},
+		{name: "at the end"},
+		{name: "in front of the previous key", before: "A", after: "B", keepBefore: true},
+		{name: "behind the previous key", before: "A", after: "B"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			before, after := tt.before, tt.after
+			for i := 0; i < 200; i++ {
+				key, err := Between(before, after)
+				if err != nil {
+					t.Fatalf("step %d: Between(%q, %q): %v", i, before, after, err)
+				}
+				if !Valid(key) || (before != "" && key <= before) || (after != "" && key >= after) {
+					t.Fatalf("step %d: Between(%q, %q) = %q is not a valid key between them", i, before, after, key)
+				}
+				if tt.keepBefore {
+					after = key
+				} else {
+					before = key
+				}
+			}
+		})
+	}
+}
+
+func TestSpread(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 61, 62, 1000, 5000} {
+		keys := Spread(n)
+		if len(keys) != n {
+			t.Fatalf("Spread(%d) returned %d keys", n, len(keys))
+		}
+		if !slices.IsSorted(keys) || len(slices.Compact(slices.Clone(keys))) != n {
+			t.Errorf("Spread(%d) is not strictly ascending", n)
+		}
+		for i, key := range keys {
+			if !Valid(key) || len(key) > MaxLength {
+				t.Fatalf("Spread(%d)[%d] = %q is not a valid short key", n, i, key)
+			}
+			// There is room for a new key in front of every key
+			var before string
+			if i > 0 {
+				before = keys[i-1]
+			}
+			between, err := Between(before, key)
+			if err != nil || len(between) > len(key)+1 {
+				t.Fatalf("Between(%q, %q) = %q, %v after Spread(%d)", before, key, between, err, n)
+			}
+		}
+	}
+}
@@ -0,0 +1,459 @@
+// Package rrule parses recurrence rules of iCalendar (RFC 5545) and computes their occurrences. It supports the frequencies DAILY, WEEKLY,
+// MONTHLY and YEARLY with INTERVAL, COUNT, UNTIL, BYDAY, BYMONTHDAY, BYMONTH and WKST, which covers the rules calendar applications write
+// for repeating tasks. The first occurrence of a series is always its start, all later ones are computed from the rule
+package rrule
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidRule error = errors.New("invalid recurrence rule")
+var ErrUnsupported error = errors.New("unsupported recurrence rule part")
+
+type Frequency int
+
+const (
+	Daily Frequency = iota
+	Weekly
+	Monthly
+	Yearly
+)
+
+var frequencies = map[string]Frequency{"DAILY": Daily, "WEEKLY": Weekly, "MONTHLY": Monthly, "YEARLY": Yearly}
+
+func (f Frequency) String() string {
+	for name, frequency := range frequencies {
+		if frequency == f {
+			return name
+		}
+	}
+	return strconv.Itoa(int(f))
+}
+
+var weekdays = map[string]time.Weekday{"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday}
+
+// A day of the week in BYDAY. N selects the n-th such day of the month or year, counted from the end if negative. 0 selects all of them
+type Weekday struct {
+	Day time.Weekday
+	N   int
+}
+
+func (w Weekday) String() string {
+	name := strings.ToUpper(w.Day.String()[:2])
+	if w.N != 0 {
+		return strconv.Itoa(w.N) + name
+	}
+	return name
+}
+
+// A parsed rule. Interval is at least 1, Count and Until are zero if the series does not end. Until_date marks an UNTIL given as a date,
+// which includes the whole day
+type Rule struct {
+	Freq       Frequency
+	Interval   int
+	Count      int
+	Until      time.Time
+	Until_date bool
+	ByDay      []Weekday
+	ByMonthDay []int
+	ByMonth    []time.Month
+	Wkst       time.Weekday
+}
+
+// An occurrence is searched for at most this many years after the time it has to follow, so that a rule without occurrences, like the
+// 30th of February, ends the search. The span is a whole cycle of the Gregorian calendar, in which every date a rule can select comes up
+const horizonYears = 400
+
+// Parses a rule like "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR". A leading "RRULE:" is ignored
+func Parse(s string) (Rule, error) {
+	r := Rule{Interval: 1, Wkst: time.Monday}
+	s = strings.TrimSpace(s)
+	if len(s) >= 6 && strings.EqualFold(s[:6], "RRULE:") {
+		s = s[6:]
+	}
+	if s == "" {
+		return Rule{}, fmt.Errorf("%w: empty rule", ErrInvalidRule)
+	}
+
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		name = strings.ToUpper(strings.TrimSpace(name))
+		value = strings.ToUpper(strings.TrimSpace(value))
+		if !ok || name == "" || value == "" {
+			return Rule{}, fmt.Errorf("%w: malformed part %q", ErrInvalidRule, part)
+		}
+		if seen[name] {
+			return Rule{}, fmt.Errorf("%w: %s is given twice", ErrInvalidRule, name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "FREQ":
+			frequency, ok := frequencies[value]
+			if !ok {
+				return Rule{}, fmt.Errorf("%w: FREQ=%s", ErrUnsupported, value)
+			}
+			r.Freq = frequency
+		case "INTERVAL":
+			r.Interval, err = parseNumber(value, 1, 1000)
+		case "COUNT":
+			r.Count, err = parseNumber(value, 1, 10000)
+		case "UNTIL":
+			r.Until, r.Until_date, err = parseUntil(value)
+		case "BYDAY":
+			r.ByDay, err = parseList(value, parseWeekday)
+		case "BYMONTHDAY":
+			r.ByMonthDay, err = parseList(value, func(v string) (int, error) {
+				day, err := parseNumber(strings.TrimPrefix(v, "-"), 1, 31)
+				if strings.HasPrefix(v, "-") {
+					day = -day
+				}
+				return day, err
+			})
+		case "BYMONTH":
+			r.ByMonth, err = parseList(value, func(v string) (time.Month, error) {
+				month, err := parseNumber(v, 1, 12)
+				return time.Month(month), err
+			})
+		case "WKST":
+			day, ok := weekdays[value]
+			if !ok {
+				err = fmt.Errorf("unknown weekday %s", value)
+			}
+			r.Wkst = day
+		default:
+			return Rule{}, fmt.Errorf("%w: %s", ErrUnsupported, name)
+		}
+		if err != nil {
+			return Rule{}, fmt.Errorf("%w: %s: %v", ErrInvalidRule, name, err)
+		}
+	}
+
+	if !seen["FREQ"] {
+		return Rule{}, fmt.Errorf("%w: FREQ is missing", ErrInvalidRule)
+	}
+	if seen["COUNT"] && seen["UNTIL"] {
+		return Rule{}, fmt.Errorf("%w: COUNT and UNTIL exclude each other", ErrInvalidRule)
+	}
+	if r.Freq == Weekly && len(r.ByMonthDay) > 0 {
+		return Rule{}, fmt.Errorf("%w: BYMONTHDAY is not allowed with FREQ=WEEKLY", ErrInvalidRule)
+	}
+	for _, day := range r.ByDay {
+		if day.N != 0 && r.Freq != Monthly && r.Freq != Yearly {
+			return Rule{}, fmt.Errorf("%w: BYDAY=%s needs FREQ=MONTHLY or YEARLY", ErrInvalidRule, day)
+		}
+	}
+	return r, nil
+}
+
+func parseNumber(value string, low int, high int) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < low || n > high {
+		return 0, fmt.Errorf("%s is not a number between %d and %d", value, low, high)
+	}
+	return n, nil
+}
+
+func parseList[T any](value string, parse func(string) (T, error)) ([]T, error) {
+	var list []T
+	for _, item := range strings.Split(value, ",") {
+		parsed, err := parse(strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, parsed)
+	}
+	return list, nil
+}
+
+// Parses a weekday with an optional ordinal like MO, 2TU or -1FR
+func parseWeekday(value string) (Weekday, error) {
+	if len(value) < 2 {
+		return Weekday{}, fmt.Errorf("unknown weekday %s", value)
+	}
+	day, ok := weekdays[value[len(value)-2:]]
+	if !ok {
+		return Weekday{}, fmt.Errorf("unknown weekday %s", value)
+	}
+	w := Weekday{Day: day}
+	if ordinal := value[:len(value)-2]; ordinal != "" {
+		n, err := parseNumber(strings.TrimPrefix(strings.TrimPrefix(ordinal, "+"), "-"), 1, 53)
+		if err != nil {
+			return Weekday{}, err
+		}
+		w.N = n
+		if strings.HasPrefix(ordinal, "-") {
+			w.N = -n
+		}
+	}
+	return w, nil
+}
+
+// Parses UNTIL as a UTC date-time like 20240131T235959Z or as a date like 20240131
+func parseUntil(value string) (time.Time, bool, error) {
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t, false, nil
+	}
+	if t, err := time.Parse("20060102", value); err == nil {
+		return t, true, nil
+	}
+	return time.Time{}, false, fmt.Errorf("%s is neither a UTC date-time nor a date", value)
+}
+
+// Formats the rule in a canonical form, so that equal rules are equal strings
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + r.Freq.String()}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		if r.Until_date {
+			parts = append(parts, "UNTIL="+r.Until.Format("20060102"))
+		} else {
+			parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+		}
+	}
+	if len(r.ByDay) > 0 {
+		parts = append(parts, "BYDAY="+join(r.ByDay, Weekday.String))
+	}
+	if len(r.ByMonthDay) > 0 {
+		parts = append(parts, "BYMONTHDAY="+join(r.ByMonthDay, strconv.Itoa))
+	}
+	if len(r.ByMonth) > 0 {
+		parts = append(parts, "BYMONTH="+join(r.ByMonth, func(m time.Month) string { return strconv.Itoa(int(m)) }))
+	}
+	if r.Wkst != time.Monday {
+		parts = append(parts, "WKST="+Weekday{Day: r.Wkst}.String())
+	}
+	return strings.Join(parts, ";")
+}
+
+func join[T any](list []T, format func(T) string) string {
+	s := make([]string, len(list))
+	for i, item := range list {
+		s[i] = format(item)
+	}
+	return strings.Join(s, ",")
+}
+
+// Returns the first occurrence of the series starting at start that lies after the given time. The occurrences have the time of day
+// and the location of start. It returns false if the series ends before or has no occurrence within horizonYears years and one period
+func (r Rule) Next(start time.Time, after time.Time) (time.Time, bool) {
+	if after.Before(start) {
+		return start, true
+	}
+	limit := r.periodStart(after, r.Interval).AddDate(horizonYears, 0, 0)
+	n := 1
+	var next time.Time
+	found := false
+	r.each(start, limit, func(t time.Time) bool {
+		n++
+		if (r.Count > 0 && n > r.Count) || r.ended(t) {
+			return false
+		}
+		if t.After(after) {
+			next, found = t, true
+			return false
+		}
+		return true
+	})
+	return next, found
+}
+
+// Reports whether the series starting at start has a second occurrence when COUNT and UNTIL are left out. A rule that selects no
+// existing day, like the 30th of February, has none
+func (r Rule) Repeats(start time.Time) bool {
+	r.Count, r.Until, r.Until_date = 0, time.Time{}, false
+	_, ok := r.Next(start, start)
+	return ok
+}
+
+// Reports whether the occurrence lies after UNTIL
+func (r Rule) ended(t time.Time) bool {
+	if r.Until.IsZero() {
+		return false
+	}
+	if r.Until_date {
+		y, m, d := t.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC).After(r.Until)
+	}
+	return t.After(r.Until)
+}
+
+// Calls fn with the occurrences after start in ascending order until fn returns false or the periods begin after limit
+func (r Rule) each(start time.Time, limit time.Time, fn func(time.Time) bool) {
+	for offset := 0; !r.periodStart(start, offset).After(limit); offset += r.Interval {
+		candidates := r.candidates(start, offset)
+		for _, t := range candidates {
+			if !t.After(start) {
+				continue
+			}
+			if !fn(t) {
+				return
+			}
+		}
+		// Once a whole period starts after UNTIL, no later period can have an occurrence
+		if len(candidates) > 0 && r.ended(candidates[0]) {
+			return
+		}
+	}
+}
+
+// Returns midnight of the first day of the period that is offset periods after the one of start. Weeks begin on WKST
+func (r Rule) periodStart(start time.Time, offset int) time.Time {
+	y, m, d := start.Date()
+	switch r.Freq {
+	case Daily:
+		return date(y, m, d+offset, start)
+	case Weekly:
+		return date(y, m, d-(int(start.Weekday())-int(r.Wkst)+7)%7+7*offset, start)
+	case Monthly:
+		return date(y, m+time.Month(offset), 1, start)
+	default:
+		return date(y+offset, time.January, 1, start)
+	}
+}
+
+// Returns the sorted occurrences of the period that is offset periods after the one of start
+func (r Rule) candidates(start time.Time, offset int) []time.Time {
+	y, m, d := start.Date()
+	var days []time.Time
+	switch r.Freq {
+	case Daily:
+		day := r.periodStart(start, offset)
+		if r.matchesDay(day) {
+			days = append(days, day)
+		}
+	case Weekly:
+		first := r.periodStart(start, offset)
+		for i := 0; i < 7; i++ {
+			day := date(first.Year(), first.Month(), first.Day()+i, start)
+			if len(r.ByDay) == 0 && day.Weekday() != start.Weekday() {
+				continue
+			}
+			if r.matchesDay(day) {
+				days = append(days, day)
+			}
+		}
+	case Monthly:
+		first := r.periodStart(start, offset)
+		if r.matchesMonth(first.Month()) {
+			days = r.monthDays(first, d)
+		}
+	case Yearly:
+		if len(r.ByMonth) == 0 && len(r.ByMonthDay) == 0 && len(r.ByDay) > 0 {
+			days = r.yearWeekdays(y+offset, start)
+			break
+		}
+		months := r.ByMonth
+		if len(months) == 0 && len(r.ByMonthDay) > 0 {
+			months = []time.Month{time.January, time.February, time.March, time.April, time.May, time.June,
+				time.July, time.August, time.September, time.October, time.November, time.December}
+		} else if len(months) == 0 {
+			months = []time.Month{m}
+		}
+		for _, month := range months {
+			days = append(days, r.monthDays(date(y+offset, month, 1, start), d)...)
+		}
+	}
+
+	occurrences := make([]time.Time, len(days))
+	for i, day := range days {
+		occurrences[i] = time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+	}
+	slices.SortFunc(occurrences, func(a, b time.Time) int { return a.Compare(b) })
+	return slices.CompactFunc(occurrences, time.Time.Equal)
+}
+
+// Returns midnight of the day in the location of ref. Days and months out of range are normalized like in time.Date
+func date(y int, m time.Month, d int, ref time.Time) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, ref.Location())
+}
+
+// Returns the days of the month of first selected by BYMONTHDAY and BYDAY. Without both it is the day of month of the start, if the month has it
+func (r Rule) monthDays(first time.Time, startDay int) []time.Time {
+	length := date(first.Year(), first.Month()+1, 0, first).Day()
+	var days []time.Time
+	for day := 1; day <= length; day++ {
+		t := date(first.Year(), first.Month(), day, first)
+		switch {
+		case len(r.ByMonthDay) > 0:
+			if !slices.ContainsFunc(r.ByMonthDay, func(md int) bool { return md == day || md == day-length-1 }) {
+				continue
+			}
+			if len(r.ByDay) > 0 && !r.matchesOrdinal(t, day, length) {
+				continue
+			}
+		case len(r.ByDay) > 0:
+			if !r.matchesOrdinal(t, day, length) {
+				continue
+			}
+		default:
+			if day != startDay {
+				continue
+			}
+		}
+		days = append(days, t)
+	}
+	return days
+}
+
+// Reports whether the day matches BYDAY, where an ordinal counts the weekday within the month
+func (r Rule) matchesOrdinal(t time.Time, day int, length int) bool {
+	for _, w := range r.ByDay {
+		if w.Day != t.Weekday() {
+			continue
+		}
+		if w.N == 0 || (w.N > 0 && (day-1)/7+1 == w.N) || (w.N < 0 && (length-day)/7+1 == -w.N) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the days of the year selected by BYDAY, where an ordinal counts the weekday within the year
+func (r Rule) yearWeekdays(year int, ref time.Time) []time.Time {
+	length := date(year, time.December, 31, ref).YearDay()
+	var days []time.Time
+	for day := 1; day <= length; day++ {
+		t := date(year, time.January, day, ref)
+		for _, w := range r.ByDay {
+			if w.Day == t.Weekday() && (w.N == 0 || (w.N > 0 && (day-1)/7+1 == w.N) || (w.N < 0 && (length-day)/7+1 == -w.N)) {
+				days = append(days, t)
+				break
+			}
+		}
+	}
+	return days
+}
+
+// Reports whether a day of a DAILY or WEEKLY rule matches BYMONTH, BYMONTHDAY and BYDAY
+func (r Rule) matchesDay(t time.Time) bool {
+	if !r.matchesMonth(t.Month()) {
+		return false
+	}
+	if len(r.ByMonthDay) > 0 {
+		length := date(t.Year(), t.Month()+1, 0, t).Day()
+		if !slices.ContainsFunc(r.ByMonthDay, func(md int) bool { return md == t.Day() || md == t.Day()-length-1 }) {
+			return false
+		}
+	}
+	if len(r.ByDay) > 0 && !slices.ContainsFunc(r.ByDay, func(w Weekday) bool { return w.Day == t.Weekday() }) {
+		return false
+	}
+	return true
+}
+
+func (r Rule) matchesMonth(m time.Month) bool {
+	return len(r.ByMonth) == 0 || slices.Contains(r.ByMonth, m)
+}
@@ -0,0 +1,281 @@
+package rrule
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Returns up to n occurrences of the series including its start, formatted in the location of start
+func occurrences(t *testing.T, rule string, start time.Time, n int) []string {
+	t.Helper()
+	r, err := Parse(rule)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", rule, err)
+	}
+	var list []string
+	for next, ok := start, true; ok && len(list) < n; next, ok = r.Next(start, next) {
+		list = append(list, next.Format("2006-01-02 15:04 MST"))
+	}
+	return list
+}
+
+func TestNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+	utc := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 9, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name  string
+		rule  string
+		start time.Time
+		n     int
+		want  []string
+	}{
+		{
+			name:  "daily with interval",
+			rule:  "FREQ=DAILY;INTERVAL=2",
+			start: utc(2024, time.February, 27),
+			n:     4,
+			want:  []string{"2024-02-27 09:00 UTC", "2024-02-29 09:00 UTC", "2024-03-02 09:00 UTC", "2024-03-04 09:00 UTC"},
+		},
+		{
+			name:  "weekly on several days",
+			rule:  "FREQ=WEEKLY;BYDAY=MO,FR",
+			start: utc(2024, time.January, 1),
+			n:     4,
+			want:  []string{"2024-01-01 09:00 UTC", "2024-01-05 09:00 UTC", "2024-01-08 09:00 UTC", "2024-01-12 09:00 UTC"},
+		},
+		{
+			name:  "weekly without BYDAY keeps the weekday of the start",
+			rule:  "FREQ=WEEKLY",
+			start: utc(2024, time.January, 3),
+			n:     3,
+			want:  []string{"2024-01-03 09:00 UTC", "2024-01-10 09:00 UTC", "2024-01-17 09:00 UTC"},
+		},
+		{
+			// The weeks of WKST=SU start a day earlier, so Sunday and Tuesday fall into different biweekly periods than with Monday
+			name:  "biweekly with WKST=MO",
+			rule:  "FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,SU",
+			start: utc(1997, time.August, 5),
+			n:     4,
+			want:  []string{"1997-08-05 09:00 UTC", "1997-08-10 09:00 UTC", "1997-08-19 09:00 UTC", "1997-08-24 09:00 UTC"},
+		},
+		{
+			name:  "biweekly with WKST=SU",
+			rule:  "FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,SU;WKST=SU",
+			start: utc(1997, time.August, 5),
+			n:     4,
+			want:  []string{"1997-08-05 09:00 UTC", "1997-08-17 09:00 UTC", "1997-08-19 09:00 UTC", "1997-08-31 09:00 UTC"},
+		},
+		{
+			name:  "monthly on the 31st skips shorter months",
+			rule:  "FREQ=MONTHLY",
+			start: utc(2024, time.January, 31),
+			n:     4,
+			want:  []string{"2024-01-31 09:00 UTC", "2024-03-31 09:00 UTC", "2024-05-31 09:00 UTC", "2024-07-31 09:00 UTC"},
+		},
+		{
+			name:  "monthly on the last day",
+			rule:  "FREQ=MONTHLY;BYMONTHDAY=-1",
+			start: utc(2024, time.January, 31),
+			n:     4,
+			want:  []string{"2024-01-31 09:00 UTC", "2024-02-29 09:00 UTC", "2024-03-31 09:00 UTC", "2024-04-30 09:00 UTC"},
+		},
+		{
+			name:  "monthly on the second to last day",
+			rule:  "FREQ=MONTHLY;BYMONTHDAY=-2",
+			start: utc(2023, time.January, 30),
+			n:     3,
+			want:  []string{"2023-01-30 09:00 UTC", "2023-02-27 09:00 UTC", "2023-03-30 09:00 UTC"},
+		},
+		{
+			name:  "monthly on the second Tuesday",
+			rule:  "FREQ=MONTHLY;BYDAY=2TU",
+			start: utc(2024, time.January, 9),
+			n:     3,
+			want:  []string{"2024-01-09 09:00 UTC", "2024-02-13 09:00 UTC", "2024-03-12 09:00 UTC"},
+		},
+		{
+			name:  "monthly on the last Friday",
+			rule:  "FREQ=MONTHLY;BYDAY=-1FR",
+			start: utc(2024, time.January, 26),
+			n:     3,
+			want:  []string{"2024-01-26 09:00 UTC", "2024-02-23 09:00 UTC", "2024-03-29 09:00 UTC"},
+		},
+		{
+			name:  "Friday the 13th",
+			rule:  "FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13",
+			start: utc(2024, time.September, 13),
+			n:     3,
+			want:  []string{"2024-09-13 09:00 UTC", "2024-12-13 09:00 UTC", "2025-06-13 09:00 UTC"},
+		},
+		{
+			name:  "yearly on the 29th of February",
+			rule:  "FREQ=YEARLY",
+			start: utc(2024, time.February, 29),
+			n:     3,
+			want:  []string{"2024-02-29 09:00 UTC", "2028-02-29 09:00 UTC", "2032-02-29 09:00 UTC"},
+		},
+		{
+			name:  "yearly in several months",
+			rule:  "FREQ=YEARLY;BYMONTH=1,7;BYMONTHDAY=1",
+			start: utc(2024, time.January, 1),
+			n:     3,
+			want:  []string{"2024-01-01 09:00 UTC", "2024-07-01 09:00 UTC", "2025-01-01 09:00 UTC"},
+		},
+		{
+			name:  "yearly on the first Monday of the year",
+			rule:  "FREQ=YEARLY;BYDAY=1MO",
+			start: utc(2024, time.January, 1),
+			n:     3,
+			want:  []string{"2024-01-01 09:00 UTC", "2025-01-06 09:00 UTC", "2026-01-05 09:00 UTC"},
+		},
+		{
+			name:  "yearly on Thanksgiving",
+			rule:  "FREQ=YEARLY;BYMONTH=11;BYDAY=4TH",
+			start: utc(2024, time.November, 28),
+			n:     3,
+			want:  []string{"2024-11-28 09:00 UTC", "2025-11-27 09:00 UTC", "2026-11-26 09:00 UTC"},
+		},
+		{
+			name:  "COUNT includes the start",
+			rule:  "FREQ=DAILY;COUNT=3",
+			start: utc(2024, time.January, 1),
+			n:     10,
+			want:  []string{"2024-01-01 09:00 UTC", "2024-01-02 09:00 UTC", "2024-01-03 09:00 UTC"},
+		},
+		{
+			name:  "UNTIL as a date-time excludes a later time of the same day",
+			rule:  "FREQ=DAILY;UNTIL=20240103T080000Z",
+			start: utc(2024, time.January, 1),
+			n:     10,
+			want:  []string{"2024-01-01 09:00 UTC", "2024-01-02 09:00 UTC"},
+		},
+		{
+			name:  "UNTIL as a date includes the whole day",
+			rule:  "FREQ=DAILY;UNTIL=20240103",
+			start: utc(2024, time.January, 1),
+			n:     10,
+			want:  []string{"2024-01-01 09:00 UTC", "2024-01-02 09:00 UTC", "2024-01-03 09:00 UTC"},
+		},
+		{
+			name:  "the local time of day is kept across the start of daylight saving time",
+			rule:  "FREQ=DAILY",
+			start: time.Date(2024, time.March, 30, 9, 0, 0, 0, berlin),
+			n:     3,
+			want:  []string{"2024-03-30 09:00 CET", "2024-03-31 09:00 CEST", "2024-04-01 09:00 CEST"},
+		},
+		{
+			name:  "the local time of day is kept across the end of daylight saving time",
+			rule:  "FREQ=WEEKLY",
+			start: time.Date(2024, time.October, 20, 9, 0, 0, 0, berlin),
+			n:     3,
+			want:  []string{"2024-10-20 09:00 CEST", "2024-10-27 09:00 CET", "2024-11-03 09:00 CET"},
+		},
+		{
+			name:  "a rule without other occurrences only has its start",
+			rule:  "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30",
+			start: utc(2024, time.January, 1),
+			n:     10,
+			want:  []string{"2024-01-01 09:00 UTC"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := occurrences(t, tt.rule, tt.start, tt.n)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("occurrences of %q = %v, want %v", tt.rule, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextAfterLaterTime(t *testing.T) {
+	r, err := Parse("FREQ=MONTHLY;BYMONTHDAY=15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, time.January, 15, 12, 0, 0, 0, time.UTC)
+	next, ok := r.Next(start, time.Date(2024, time.June, 20, 0, 0, 0, 0, time.UTC))
+	want := time.Date(2024, time.July, 15, 12, 0, 0, 0, time.UTC)
+	if !ok || !next.Equal(want) {
+		t.Errorf("Next = %v, %v, want %v", next, ok, want)
+	}
+	next, ok = r.Next(start, time.Date(2023, time.December, 1, 0, 0, 0, 0, time.UTC))
+	if !ok || !next.Equal(start) {
+		t.Errorf("Next before the start = %v, %v, want the start", next, ok)
+	}
+}
+
+func TestRepeats(t *testing.T) {
+	start := time.Date(2024, time.January, 30, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		rule string
+		want bool
+	}{
+		{"FREQ=DAILY", true},
+		{"FREQ=DAILY;COUNT=1", true},
+		{"FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=29", true},
+		{"FREQ=YEARLY;INTERVAL=1000", true},
+		{"FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30", false},
+		{"FREQ=DAILY;BYMONTH=4;BYMONTHDAY=31", false},
+		{"FREQ=MONTHLY;BYMONTH=2", false},
+		{"FREQ=MONTHLY;BYDAY=6MO", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			r, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := r.Repeats(start); got != tt.want {
+				t.Errorf("Repeats = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		rule    string
+		want    string
+		wantErr error
+	}{
+		{rule: "RRULE:freq=weekly;interval=2;byday=mo,fr", want: "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR"},
+		{rule: "FREQ=MONTHLY;INTERVAL=1;BYDAY=-1FR;WKST=MO", want: "FREQ=MONTHLY;BYDAY=-1FR"},
+		{rule: "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=-1;UNTIL=20300101", want: "FREQ=YEARLY;UNTIL=20300101;BYMONTHDAY=-1;BYMONTH=2"},
+		{rule: "FREQ=WEEKLY;WKST=SU;COUNT=5", want: "FREQ=WEEKLY;COUNT=5;WKST=SU"},
+		{rule: "", wantErr: ErrInvalidRule},
+		{rule: "INTERVAL=2", wantErr: ErrInvalidRule},
+		{rule: "FREQ=DAILY;FREQ=WEEKLY", wantErr: ErrInvalidRule},
+		{rule: "FREQ=DAILY;COUNT=2;UNTIL=20240101", wantErr: ErrInvalidRule},
+		{rule: "FREQ=DAILY;INTERVAL=0", wantErr: ErrInvalidRule},
+		{rule: "FREQ=MONTHLY;BYMONTHDAY=32", wantErr: ErrInvalidRule},
+		{rule: "FREQ=WEEKLY;BYMONTHDAY=1", wantErr: ErrInvalidRule},
+		{rule: "FREQ=DAILY;BYDAY=1MO", wantErr: ErrInvalidRule},
+		{rule: "FREQ=DAILY;UNTIL=2024-01-01", wantErr: ErrInvalidRule},
+		{rule: "FREQ=HOURLY", wantErr: ErrUnsupported},
+		{rule: "FREQ=DAILY;BYHOUR=9", wantErr: ErrUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			r, err := Parse(tt.rule)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Parse error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if got := r.String(); got != tt.want {
+				t.Errorf("String = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
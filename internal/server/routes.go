@@ -5,6 +5,7 @@ import (
 	"todolist/internal/auth"
 	"todolist/internal/controller"
 	"todolist/internal/service"
+	"todolist/internal/storage"
 
 	"github.com/gin-gonic/gin"
 )
@@ -14,12 +15,27 @@ var (
 	userController controller.UserController = controller.NewUserController(userService)
 	taskService    service.TaskService       = service.NewTaskService()
 	taskController controller.TaskController = controller.NewTaskController(taskService)
+
+	workspaceService    service.WorkspaceService       = service.NewWorkspaceService()
+	workspaceController controller.WorkspaceController = controller.NewWorkspaceController(workspaceService)
+
+	shareService    service.ShareService       = service.NewShareService()
+	shareController controller.ShareController = controller.NewShareController(shareService)
+
+	apiController controller.APIController = controller.NewAPIController(taskService)
+
+	labelService    service.LabelService       = service.NewLabelService()
+	labelController controller.LabelController = controller.NewLabelController(labelService)
+
+	blobStore            storage.BlobStore               = newBlobStore()
+	attachmentService    service.AttachmentService       = service.NewAttachmentService(blobStore)
+	attachmentController controller.AttachmentController = controller.NewAttachmentController(attachmentService)
 )
 
 func (s *Server) RegisterRoutes() http.Handler {
 	r := gin.Default()
 
-	r.LoadHTMLFiles("internal/frontend/login.html", "internal/frontend/index.html")
+	r.LoadHTMLFiles("internal/frontend/login.html", "internal/frontend/index.html", "internal/frontend/invite.html")
 	r.Static("/static", "internal/frontend/static")
 	r.Static("/tasks/static", "internal/frontend/static")
 
@@ -39,19 +55,108 @@ func (s *Server) RegisterRoutes() http.Handler {
 	})
 	authorized.GET("/get", taskController.GetAllTasksAndCategories)
 
-	authorized.POST("/addTask", taskController.AddTask)
-	authorized.POST("/deleteTask", taskController.DeleteTask)
-	authorized.POST("/updateTask", taskController.UpdateTask)
-	authorized.POST("/relocateTask", taskController.RelocateTask)
+	// The mutation routes below are deprecated in favour of the /api/v1 resources and kept as aliases until the frontend has moved over
+	authorized.POST("/addTask", deprecated("/api/v1/categories/{id}/tasks"), taskController.AddTask)
+	authorized.POST("/deleteTask", deprecated("/api/v1/tasks/{id}"), taskController.DeleteTask)
+	authorized.POST("/updateTask", deprecated("/api/v1/tasks/{id}"), taskController.UpdateTask)
+	authorized.POST("/relocateTask", deprecated("/api/v1/tasks/{id}"), taskController.RelocateTask)
+
+	authorized.POST("/addCategory", deprecated("/api/v1/categories"), taskController.AddCategory)
+	authorized.POST("/updateCategory", deprecated("/api/v1/categories/{id}"), taskController.UpdateCategory)
+	authorized.POST("/deleteCategory", deprecated("/api/v1/categories/{id}"), taskController.DeleteCategory)
+	authorized.POST("/relocateCategory", deprecated("/api/v1/categories/{id}"), taskController.RelocateCategory)
+
+	authorized.POST("/createInvitation", shareController.CreateInvitation)
+	authorized.GET("/invitations", shareController.GetInvitations)
+	authorized.POST("/revokeInvitation", shareController.RevokeInvitation)
+
+	r.GET("/invite/:token", auth.JwtTokenCheck, shareController.ShowInvitation)
+	r.POST("/invite/:token", auth.JwtTokenCheck, shareController.RedeemInvitation)
 
-	authorized.POST("/addCategory", taskController.AddCategory)
-	authorized.POST("/updateCategory", taskController.UpdateCategory)
-	authorized.POST("/deleteCategory", taskController.DeleteCategory)
-	authorized.POST("/relocateCategory", taskController.RelocateCategory)
+	api := r.Group("/api/v1")
+	api.Use(auth.JwtTokenCheck)
+	api.GET("/categories", apiController.ListCategories)
+	api.POST("/categories", apiController.CreateCategory)
+	api.GET("/categories/:id", apiController.GetCategory)
+	api.PATCH("/categories/:id", apiController.PatchCategory)
+	api.DELETE("/categories/:id", apiController.DeleteCategory)
+	api.GET("/categories/:id/tasks", apiController.ListCategoryTasks)
+	api.GET("/categories/:id/history", apiController.GetCategoryHistory)
+	api.POST("/categories/:id/restore", apiController.RestoreCategory)
+	api.PUT("/categories/:id/archive", apiController.ArchiveCategory)
+	api.DELETE("/categories/:id/archive", apiController.UnarchiveCategory)
+	api.POST("/categories/:id/tasks", apiController.CreateTask)
+
+	api.GET("/tasks", apiController.ListTasks)
+	api.GET("/search", apiController.SearchTasks)
+	api.GET("/settings", userController.GetSettings)
+	api.PATCH("/settings", userController.PatchSettings)
+	api.GET("/tasks/:id", apiController.GetTask)
+	api.PATCH("/tasks/:id", apiController.PatchTask)
+	api.DELETE("/tasks/:id", apiController.DeleteTask)
+	api.POST("/tasks/bulk", apiController.BulkTasks)
+	api.POST("/tasks/:id/subtasks", apiController.CreateSubtask)
+	api.POST("/tasks/:id/checklist", apiController.CreateChecklistItem)
+	api.PATCH("/checklist/:id", apiController.PatchChecklistItem)
+	api.DELETE("/checklist/:id", apiController.DeleteChecklistItem)
+	api.GET("/tasks/:id/dependencies", apiController.GetDependencies)
+	api.POST("/tasks/:id/dependencies", apiController.AddDependency)
+	api.DELETE("/tasks/:id/dependencies/:blocker_id", apiController.RemoveDependency)
+	api.PUT("/tasks/:id/assignees/:user_id", apiController.AddAssignee)
+	api.DELETE("/tasks/:id/assignees/:user_id", apiController.RemoveAssignee)
+	api.GET("/tasks/:id/history", apiController.GetTaskHistory)
+	api.POST("/tasks/:id/restore", apiController.RestoreTask)
+	api.GET("/trash", apiController.ListTrash)
+	api.PUT("/tasks/:id/archive", apiController.ArchiveTask)
+	api.DELETE("/tasks/:id/archive", apiController.UnarchiveTask)
+	api.GET("/archive/tasks", apiController.ListArchivedTasks)
+	api.GET("/archive/categories", apiController.ListArchivedCategories)
+	api.GET("/tasks/:id/comments", apiController.GetComments)
+	api.POST("/tasks/:id/comments", apiController.CreateComment)
+	api.PATCH("/comments/:id", apiController.PatchComment)
+	api.DELETE("/comments/:id", apiController.DeleteComment)
+	api.GET("/tasks/:id/attachments", attachmentController.ListAttachments)
+	api.POST("/tasks/:id/attachments", attachmentController.UploadAttachment)
+	api.GET("/attachments/:id", attachmentController.DownloadAttachment)
+	api.DELETE("/attachments/:id", attachmentController.DeleteAttachment)
+	api.PUT("/tasks/:id/labels/:label_id", labelController.AddTaskLabel)
+	api.DELETE("/tasks/:id/labels/:label_id", labelController.RemoveTaskLabel)
+	api.GET("/labels", labelController.ListLabels)
+	api.POST("/labels", labelController.CreateLabel)
+	api.PATCH("/labels/:id", labelController.PatchLabel)
+	api.DELETE("/labels/:id", labelController.DeleteLabel)
+
+	workspaces := r.Group("/workspaces")
+	workspaces.Use(auth.JwtTokenCheck)
+	workspaces.GET("/get", workspaceController.GetWorkspaces)
+	workspaces.POST("/addWorkspace", workspaceController.AddWorkspace)
+	workspaces.POST("/updateWorkspace", workspaceController.UpdateWorkspace)
+	workspaces.POST("/deleteWorkspace", workspaceController.DeleteWorkspace)
+
+	workspaces.GET("/members", workspaceController.GetMembers)
+	workspaces.POST("/updateMember", workspaceController.UpdateMember)
+	workspaces.POST("/removeMember", workspaceController.RemoveMember)
+
+	workspaces.GET("/invitations", workspaceController.GetInvitations)
+	workspaces.POST("/invite", workspaceController.InviteUser)
+	workspaces.POST("/acceptInvitation", workspaceController.AcceptInvitation)
+	workspaces.POST("/declineInvitation", workspaceController.DeclineInvitation)
+
+	workspaces.GET("/transitions", workspaceController.GetStateTransitions)
+	workspaces.POST("/updateTransitions", workspaceController.UpdateStateTransitions)
 
 	return r
 }
 
+// Marks a route as deprecated and points clients to the route replacing it
+func deprecated(successor string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Header("Deprecation", "true")
+		ctx.Header("Link", "<"+successor+">; rel=\"successor-version\"")
+		ctx.Next()
+	}
+}
+
 func (s *Server) healthHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, s.db.Health())
 }
@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
@@ -10,6 +11,9 @@ import (
 	_ "github.com/joho/godotenv/autoload"
 
 	"todolist/internal/database"
+	"todolist/internal/jobs"
+	"todolist/internal/notify"
+	"todolist/internal/storage"
 )
 
 type Server struct {
@@ -26,6 +30,19 @@ func NewServer() *http.Server {
 		db: database.New(),
 	}
 
+	jobs.Start(
+		jobs.Rebalance(time.Hour),
+		jobs.Reminders(time.Minute, notify.FromEnv()),
+		jobs.BlobCleanup(10*time.Minute, blobStore),
+		jobs.AutoArchive(time.Hour),
+	)
+	if retention := retentionDays("AUDIT_RETENTION_DAYS", 365); retention > 0 {
+		jobs.Start(jobs.AuditRetention(time.Hour, retention))
+	}
+	if retention := retentionDays("TRASH_RETENTION_DAYS", 30); retention > 0 {
+		jobs.Start(jobs.TrashPurge(time.Hour, retention))
+	}
+
 	// Declare Server config
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%d", NewServer.port),
@@ -37,3 +54,26 @@ func NewServer() *http.Server {
 
 	return server
 }
+
+// Returns the blob store configured in the environment and exits if the configuration is invalid
+func newBlobStore() storage.BlobStore {
+	store, err := storage.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return store
+}
+
+// Returns the retention period set in days by the environment variable, like how long the audit log or the trash is kept. 0 keeps
+// the entries forever
+func retentionDays(name string, def int) time.Duration {
+	days := def
+	if value := os.Getenv(name); value != "" {
+		var err error
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 0 {
+			log.Fatalf("invalid %s %q", name, value)
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"todolist/internal/database"
+	"todolist/internal/storage"
+	"unicode/utf8"
+)
+
+var (
+	ErrTooLarge          error = errors.New("the file is too large")
+	ErrUnsupportedType   error = errors.New("the file type is not allowed")
+	ErrInvalidAttachment error = errors.New("invalid attachment")
+)
+
+const (
+	defaultAttachmentSize = 10 << 20
+	maxFilename           = 255
+)
+
+// The allowed types by default, all of which http.DetectContentType recognizes
+var defaultAttachmentTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp", "application/pdf", "text/plain"}
+
+// A file uploaded by a client. Content is read from its start and must hold exactly Size bytes
+type Upload struct {
+	Filename string
+	Size     int64
+	Content  io.ReadSeeker
+}
+
+type AttachmentService interface {
+	MaxSize() int64
+	GetAttachments(int64, string) ([]database.Attachment, error)
+	AddAttachment(context.Context, int64, Upload, string) (database.Attachment, error)
+	OpenAttachment(context.Context, int64, string) (database.Attachment, io.ReadCloser, error)
+	DeleteAttachment(int64, string) error
+}
+
+type attachmentService struct {
+	store   storage.BlobStore
+	maxSize int64
+	types   []string
+}
+
+// Returns a service keeping the content of attachments in the store. ATTACHMENT_MAX_BYTES limits the size of a file (default 10 MiB),
+// ATTACHMENT_TYPES lists the allowed MIME types separated by commas
+func NewAttachmentService(store storage.BlobStore) AttachmentService {
+	s := &attachmentService{
+		store:   store,
+		maxSize: defaultAttachmentSize,
+		types:   defaultAttachmentTypes,
+	}
+	if value := os.Getenv("ATTACHMENT_MAX_BYTES"); value != "" {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size < 1 {
+			log.Fatalf("invalid ATTACHMENT_MAX_BYTES %q", value)
+		}
+		s.maxSize = size
+	}
+	if value := os.Getenv("ATTACHMENT_TYPES"); value != "" {
+		s.types = nil
+		for _, t := range strings.Split(value, ",") {
+			s.types = append(s.types, strings.ToLower(strings.TrimSpace(t)))
+		}
+	}
+	return s
+}
+
+func (s *attachmentService) MaxSize() int64 {
+	return s.maxSize
+}
+
+func (s *attachmentService) GetAttachments(task_id int64, username string) ([]database.Attachment, error) {
+	err := authorizeTask(task_id, username, database.RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+	return database.GetAttachments(task_id)
+}
+
+// Stores the upload and attaches it to the task. The type is detected from the content, the one the client claims is ignored
+func (s *attachmentService) AddAttachment(ctx context.Context, task_id int64, upload Upload, username string) (database.Attachment, error) {
+	err := authorizeTask(task_id, username, database.RoleEditor)
+	if err != nil {
+		return database.Attachment{}, err
+	}
+	if upload.Size > s.maxSize {
+		return database.Attachment{}, fmt.Errorf("%w: at most %d bytes are allowed", ErrTooLarge, s.maxSize)
+	}
+	filename := strings.TrimSpace(filepath.Base(strings.ReplaceAll(upload.Filename, `\`, "/")))
+	if filename == "" || filename == "." || filename == "/" || utf8.RuneCountInString(filename) > maxFilename {
+		return database.Attachment{}, fmt.Errorf("%w: the file name must have 1 to %d characters", ErrInvalidAttachment, maxFilename)
+	}
+	contentType, err := detectContentType(upload.Content)
+	if err != nil {
+		return database.Attachment{}, err
+	}
+	if !slices.Contains(s.types, contentType) {
+		return database.Attachment{}, fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+
+	key, err := storage.NewKey()
+	if err != nil {
+		return database.Attachment{}, err
+	}
+	err = s.store.Put(ctx, key, upload.Content, upload.Size, contentType)
+	if err != nil {
+		return database.Attachment{}, err
+	}
+	attachment, err := database.AddAttachment(database.Attachment{
+		Task_id:      task_id,
+		Filename:     filename,
+		Content_type: contentType,
+		Size:         upload.Size,
+		Storage_key:  key,
+		Uploaded_by:  username,
+	})
+	if err != nil {
+		// Nothing refers to the blob yet, so it is removed right away
+		deleteErr := s.store.Delete(context.Background(), key)
+		if deleteErr != nil {
+			log.Printf("Failed to delete the blob %s of a failed upload: %v\n", key, deleteErr)
+		}
+		if errors.Is(err, database.ErrNoResult) {
+			return database.Attachment{}, ErrNotFound
+		}
+		return database.Attachment{}, err
+	}
+	return attachment, nil
+}
+
+// Returns the metadata of the attachment and its content, which the caller has to close
+func (s *attachmentService) OpenAttachment(ctx context.Context, attachment_id int64, username string) (database.Attachment, io.ReadCloser, error) {
+	attachment, err := authorizeAttachment(attachment_id, username, database.RoleViewer)
+	if err != nil {
+		return database.Attachment{}, nil, err
+	}
+	content, err := s.store.Get(ctx, attachment.Storage_key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return database.Attachment{}, nil, fmt.Errorf("content of attachment %d is missing: %w", attachment_id, err)
+		}
+		return database.Attachment{}, nil, err
+	}
+	return attachment, content, nil
+}
+
+// Deletes the attachment. Its content is removed from the blob store by the cleanup job
+func (s *attachmentService) DeleteAttachment(attachment_id int64, username string) error {
+	_, err := authorizeAttachment(attachment_id, username, database.RoleEditor)
+	if err != nil {
+		return err
+	}
+	err = database.DeleteAttachment(attachment_id)
+	if errors.Is(err, database.ErrNoResult) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Sniffs the MIME type from the first bytes of the content without parameters like the charset and rewinds the content
+func detectContentType(content io.ReadSeeker) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(content, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	_, err = content.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+	contentType, _, err := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	if err != nil {
+		return "", err
+	}
+	return contentType, nil
+}
+
+// Returns the attachment if the user has at least the minimum role on its task
+func authorizeAttachment(attachment_id int64, username string, minimum string) (database.Attachment, error) {
+	attachment, err := database.GetAttachment(attachment_id)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			return database.Attachment{}, ErrNotFound
+		}
+		return database.Attachment{}, err
+	}
+	return attachment, authorizeTask(attachment.Task_id, username, minimum)
+}
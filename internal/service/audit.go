@@ -0,0 +1,93 @@
+package service
+
+import (
+	"log"
+	"time"
+	"todolist/internal/database"
+)
+
+// Fields whose change is recorded as a move instead of an update
+var moveFields = []string{"belongs_to", "order"}
+
+// Records the creation of a task or category in the audit log with all of its fields
+func recordCreate(entity string, id int64, actor string, after any) {
+	record(entity, id, database.AuditCreate, actor, nil, after)
+}
+
+// Records the deletion of a task or category in the audit log with the fields it had before
+func recordDelete(entity string, id int64, actor string, before any) {
+	record(entity, id, database.AuditDelete, actor, before, nil)
+}
+
+// Records archiving or unarchiving a task or category in the audit log as a change of archived_at. Nothing is recorded if it did not change
+func recordArchive(entity string, id int64, action string, actor string, before *time.Time, after *time.Time) {
+	record(entity, id, action, actor, map[string]any{"archived_at": before}, map[string]any{"archived_at": after})
+}
+
+// Records the changes between two versions of a task or category in the audit log. A change of the position is recorded as a move,
+// the other fields as an update. Nothing is recorded if nothing changed
+func recordChanges(entity string, id int64, actor string, before any, after any) {
+	changes, err := database.AuditDiff(before, after)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	moved := map[string]database.AuditChange{}
+	for _, field := range moveFields {
+		if change, ok := changes[field]; ok {
+			moved[field] = change
+			delete(changes, field)
+		}
+	}
+	addAuditEntry(entity, id, database.AuditMove, actor, moved)
+	addAuditEntry(entity, id, database.AuditUpdate, actor, changes)
+}
+
+// Records the changes of the tasks since befores were read, like those of a bulk request. A task that no longer exists was deleted. The
+// order of a task that stayed in its category only changes when other tasks move, so it is not recorded
+func recordTaskChanges(befores []database.Task, actor string) {
+	ids := make([]int64, len(befores))
+	for i, before := range befores {
+		ids[i] = before.Id
+	}
+	afters, err := database.GetTasksByIds(ids)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	byId := make(map[int64]database.Task, len(afters))
+	for _, after := range afters {
+		byId[after.Id] = after
+	}
+	for _, before := range befores {
+		after, ok := byId[before.Id]
+		if !ok {
+			recordDelete(database.AuditTask, before.Id, actor, before)
+			continue
+		}
+		if after.Belongs_to == before.Belongs_to {
+			after.Order = before.Order
+		}
+		recordChanges(database.AuditTask, before.Id, actor, before, after)
+	}
+}
+
+func record(entity string, id int64, action string, actor string, before any, after any) {
+	changes, err := database.AuditDiff(before, after)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	addAuditEntry(entity, id, action, actor, changes)
+}
+
+// Appends the entry unless it has no changes. Errors are only logged, as the change itself is already saved
+func addAuditEntry(entity string, id int64, action string, actor string, changes map[string]database.AuditChange) {
+	if len(changes) == 0 {
+		return
+	}
+	err := database.AddAuditEntry(database.AuditEntry{Entity: entity, Entity_id: id, Action: action, Actor: actor, Changes: changes})
+	if err != nil {
+		log.Println(err)
+	}
+}
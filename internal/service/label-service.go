@@ -0,0 +1,144 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"todolist/internal/database"
+)
+
+type LabelService interface {
+	GetLabels(string, int64) ([]database.Label, error)
+	AddLabel(database.Label, string) (database.Label, error)
+	PatchLabel(int64, database.LabelPatch, string) (database.Label, error)
+	DeleteLabel(database.Label, string) error
+	AddTaskLabel(int64, int64, string) error
+	RemoveTaskLabel(int64, int64, string) error
+}
+
+type labelService struct {
+}
+
+func NewLabelService() LabelService {
+	return &labelService{}
+}
+
+// Returns the personal labels of the user and the labels of the workspace. The workspace id 0 returns the labels of all workspaces of the user
+func (s *labelService) GetLabels(username string, workspaceId int64) ([]database.Label, error) {
+	if workspaceId != 0 {
+		_, err := resolveWorkspace(workspaceId, username, database.RoleViewer)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return database.GetLabels(username, workspaceId)
+}
+
+// Adds a label to the workspace given in the label, which needs the editor role, or a personal label if none is given. The workspace id 0
+// selects the personal workspace of the user
+func (s *labelService) AddLabel(label database.Label, username string) (database.Label, error) {
+	err := label.Validate()
+	if err != nil {
+		return database.Label{}, err
+	}
+	if label.Workspace_id != nil {
+		workspaceId, err := resolveWorkspace(*label.Workspace_id, username, database.RoleEditor)
+		if err != nil {
+			return database.Label{}, err
+		}
+		label.Workspace_id = &workspaceId
+	} else {
+		user, err := database.GetUserByUsername(username)
+		if err != nil {
+			return database.Label{}, ErrForbidden
+		}
+		label.User_id = user.Id
+	}
+	return database.AddLabel(label)
+}
+
+func (s *labelService) PatchLabel(label_id int64, patch database.LabelPatch, username string) (database.Label, error) {
+	err := patch.Validate()
+	if err != nil {
+		return database.Label{}, err
+	}
+	_, err = authorizeLabel(label_id, username, database.RoleEditor)
+	if err != nil {
+		return database.Label{}, err
+	}
+	err = database.PatchLabel(label_id, patch)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			return database.Label{}, ErrNotFound
+		}
+		return database.Label{}, err
+	}
+	return database.GetLabel(label_id)
+}
+
+func (s *labelService) DeleteLabel(label database.Label, username string) error {
+	_, err := authorizeLabel(label.Id, username, database.RoleEditor)
+	if err != nil {
+		return err
+	}
+	return database.DeleteLabel(label)
+}
+
+// Puts a label the user can see on a task the user may change. A workspace label can only be put on tasks of its workspace
+func (s *labelService) AddTaskLabel(task_id int64, label_id int64, username string) error {
+	err := authorizeTask(task_id, username, database.RoleEditor)
+	if err != nil {
+		return err
+	}
+	label, err := authorizeLabel(label_id, username, database.RoleViewer)
+	if err != nil {
+		return err
+	}
+	if label.Workspace_id != nil {
+		task, err := database.GetTaskById(task_id)
+		if err != nil {
+			return err
+		}
+		category, err := database.GetCategoryByID(task.Belongs_to)
+		if err != nil {
+			return err
+		}
+		if category.Workspace_id != *label.Workspace_id {
+			return fmt.Errorf("%w: the label belongs to another workspace", database.ErrInvalidLabel)
+		}
+	}
+	return database.AddTaskLabel(task_id, label_id)
+}
+
+// Removes a label from a task the user may change, including personal labels of other users
+func (s *labelService) RemoveTaskLabel(task_id int64, label_id int64, username string) error {
+	err := authorizeTask(task_id, username, database.RoleEditor)
+	if err != nil {
+		return err
+	}
+	err = database.RemoveTaskLabel(task_id, label_id)
+	if errors.Is(err, database.ErrNoResult) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Returns the label if the user has at least the minimum role in its workspace or, for a personal label, is its owner.
+// Returns ErrNotFound for personal labels of other users
+func authorizeLabel(label_id int64, username string, minimum string) (database.Label, error) {
+	label, err := database.GetLabel(label_id)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			return database.Label{}, ErrNotFound
+		}
+		return database.Label{}, err
+	}
+	if label.Workspace_id != nil {
+		_, err = resolveWorkspace(*label.Workspace_id, username, minimum)
+		return label, err
+	}
+	user, err := database.GetUserByUsername(username)
+	if err != nil || user.Id != label.User_id {
+		return database.Label{}, ErrNotFound
+	}
+	return label, nil
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+	"todolist/internal/database"
+)
+
+type ShareService interface {
+	CreateInvitation(database.CategoryInvitation, string) (database.CategoryInvitation, error)
+	GetInvitations(int64, string) ([]database.CategoryInvitation, error)
+	RevokeInvitation(database.CategoryInvitation, string) error
+	GetInvitation(string) (database.CategoryInvitation, error)
+	RedeemInvitation(string, string) (database.CategoryInvitation, error)
+}
+
+var (
+	ErrInvalidShareRole  error = errors.New("a category can only be shared with the role editor or viewer")
+	ErrInvalidExpiry     error = errors.New("an invitation must expire within 1 and 720 hours")
+	ErrInvitationExpired error = errors.New("this invitation has expired")
+	ErrInvitationUsed    error = errors.New("this invitation has already been used")
+	ErrInvitationRevoked error = errors.New("this invitation has been revoked")
+)
+
+const (
+	defaultInvitationHours int64 = 72
+	maxInvitationHours     int64 = 720
+)
+
+type shareService struct {
+}
+
+func NewShareService() ShareService {
+	return &shareService{}
+}
+
+// Only owners of the workspace a category belongs to may share it
+func (s *shareService) checkCategoryOwner(categoryId int64, username string) error {
+	role, err := database.GetRoleByCategoryId(categoryId, username)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			return ErrForbidden
+		}
+		return err
+	}
+	if !hasRole(role, database.RoleOwner) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// Creates an invitation link for a category. The returned invitation is the only place the plain token ever appears
+func (s *shareService) CreateInvitation(invitation database.CategoryInvitation, username string) (database.CategoryInvitation, error) {
+	if invitation.Role != database.RoleEditor && invitation.Role != database.RoleViewer {
+		return database.CategoryInvitation{}, ErrInvalidShareRole
+	}
+	if invitation.Expires_in_hours == 0 {
+		invitation.Expires_in_hours = defaultInvitationHours
+	}
+	if invitation.Expires_in_hours < 1 || invitation.Expires_in_hours > maxInvitationHours {
+		return database.CategoryInvitation{}, ErrInvalidExpiry
+	}
+	err := s.checkCategoryOwner(invitation.Category_id, username)
+	if err != nil {
+		return database.CategoryInvitation{}, err
+	}
+	user, err := database.GetUserByUsername(username)
+	if err != nil {
+		return database.CategoryInvitation{}, ErrForbidden
+	}
+
+	token, err := generateInvitationToken()
+	if err != nil {
+		return database.CategoryInvitation{}, err
+	}
+	invitation.Expires_at = time.Now().Add(time.Duration(invitation.Expires_in_hours) * time.Hour)
+	invitation, err = database.AddCategoryInvitation(invitation, hashInvitationToken(token), user.Id)
+	if err != nil {
+		return database.CategoryInvitation{}, err
+	}
+	invitation.Token = token
+	invitation.Created_by = username
+	return invitation, nil
+}
+
+// Returns the invitations of a category that can still be redeemed
+func (s *shareService) GetInvitations(categoryId int64, username string) ([]database.CategoryInvitation, error) {
+	err := s.checkCategoryOwner(categoryId, username)
+	if err != nil {
+		return nil, err
+	}
+	return database.GetOutstandingCategoryInvitations(categoryId)
+}
+
+func (s *shareService) RevokeInvitation(invitation database.CategoryInvitation, username string) error {
+	invitation, err := database.GetCategoryInvitationById(invitation.Id)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			return ErrNoSuchInvitation
+		}
+		return err
+	}
+	err = s.checkCategoryOwner(invitation.Category_id, username)
+	if err != nil {
+		return err
+	}
+	err = database.RevokeCategoryInvitation(invitation.Id)
+	if errors.Is(err, database.ErrNoResult) {
+		return ErrInvitationRevoked
+	}
+	return err
+}
+
+// Returns the invitation of the token without redeeming it, or the reason why it can't be redeemed
+func (s *shareService) GetInvitation(token string) (database.CategoryInvitation, error) {
+	invitation, err := database.GetCategoryInvitationByTokenHash(hashInvitationToken(token))
+	if err != nil {
+		return database.CategoryInvitation{}, invitationError(invitation, err)
+	}
+	if invitation.Revoked || !invitation.Expires_at.After(time.Now()) || (invitation.Single_use && invitation.Use_count > 0) {
+		return database.CategoryInvitation{}, invitationError(invitation, nil)
+	}
+	return invitation, nil
+}
+
+// Grants the role of the invitation on its category to the user. Returns the redeemed invitation without its token
+func (s *shareService) RedeemInvitation(token string, username string) (database.CategoryInvitation, error) {
+	user, err := database.GetUserByUsername(username)
+	if err != nil {
+		return database.CategoryInvitation{}, ErrForbidden
+	}
+	tokenHash := hashInvitationToken(token)
+	invitation, err := database.RedeemCategoryInvitation(tokenHash, user.Id)
+	if err == nil {
+		return invitation, nil
+	}
+	if !errors.Is(err, database.ErrNoResult) {
+		return database.CategoryInvitation{}, err
+	}
+
+	// Find out why the invitation could not be redeemed
+	return database.CategoryInvitation{}, invitationError(database.GetCategoryInvitationByTokenHash(tokenHash))
+}
+
+// Returns the reason why the invitation, as loaded by its token, can't be redeemed
+func invitationError(invitation database.CategoryInvitation, err error) error {
+	switch {
+	case errors.Is(err, database.ErrNoResult):
+		return ErrNoSuchInvitation
+	case err != nil:
+		return err
+	case invitation.Revoked:
+		return ErrInvitationRevoked
+	case !invitation.Expires_at.After(time.Now()):
+		return ErrInvitationExpired
+	default:
+		return ErrInvitationUsed
+	}
+}
+
+func generateInvitationToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashInvitationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -2,28 +2,131 @@ package service
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"slices"
+	"strings"
 	"todolist/internal/database"
 )
 
 type TaskService interface {
 	AddTask(database.Task, string) (database.Task, error)
 	UpdateTask(database.Task, string) (database.Task, error)
+	PatchTask(int64, database.TaskPatch, string) (database.Task, error)
 	DeleteTask(database.Task, string) error
 	RelocateTask(database.Task, string) (database.Task, error)
 	AddCategory(database.Categories, string) (database.Categories, error)
 	UpdateCategory(database.Categories, string) (database.Categories, error)
+	PatchCategory(int64, database.CategoryPatch, string) (database.Categories, error)
 	DeleteCategory(database.Categories, string) error
 	RelocateCategory(database.Categories, string) error
-	GetAllTasksAndCategories(string) ([]database.Categories, []database.Task)
+	GetAllTasksAndCategories(string, int64) ([]database.Categories, []database.Task, error)
+	GetCategories(string, int64) ([]database.Categories, error)
+	GetCategory(int64, string) (database.Categories, error)
+	GetCategoryTasks(int64, string) ([]database.Task, error)
+	GetTask(int64, string) (database.Task, error)
+	BulkTasks([]database.BulkOperation, string) ([]BulkResult, error)
+	ListTasks(database.TaskQuery) (database.TaskPage, error)
+	SearchTasks(string, string, int64, int) ([]database.TaskSearchResult, error)
+	AddSubtask(int64, database.Task, string) (database.Task, error)
+	AddChecklistItem(database.ChecklistItem, string) (database.ChecklistItem, error)
+	PatchChecklistItem(int64, database.ChecklistPatch, string) (database.ChecklistItem, error)
+	DeleteChecklistItem(database.ChecklistItem, string) error
+	GetDependencies(int64, string) (database.TaskDependencies, error)
+	AddDependency(int64, int64, string) error
+	RemoveDependency(int64, int64, string) error
+	AddAssignee(int64, int64, string) error
+	RemoveAssignee(int64, int64, string) error
+	GetTaskHistory(int64, string, int, string) (database.AuditPage, error)
+	GetCategoryHistory(int64, string, int, string) (database.AuditPage, error)
+	GetComments(int64, string) ([]database.Comment, error)
+	AddComment(database.Comment, string) (database.Comment, error)
+	EditComment(int64, string, int64, string) (database.Comment, error)
+	DeleteComment(database.Comment, string) error
+	GetTrash(string, int64) (database.Trash, error)
+	RestoreTask(int64, string) (database.Task, error)
+	RestoreCategory(int64, string) (database.Categories, error)
+	ArchiveTask(int64, int64, string) (database.Task, error)
+	UnarchiveTask(int64, int64, string) (database.Task, error)
+	ArchiveCategory(int64, int64, string) (database.Categories, error)
+	UnarchiveCategory(int64, int64, string) (database.Categories, error)
+	GetArchivedTasks(string, int64, string, int) (database.TaskPage, error)
+	GetArchivedCategories(string, int64, string, int) (database.CategoryPage, error)
 	checkPermissionTask(int64, int64, string) bool
 	checkPermissionCategory(int64, string) bool
 }
 
 var (
-	ErrForbidden error = errors.New("this user is not permitted to modify this entity")
+	ErrForbidden         error = errors.New("this user is not permitted to modify this entity")
+	ErrNotFound          error = errors.New("this entity does not exist")
+	ErrInvalidOperation  error = errors.New("invalid bulk operation")
+	ErrTooManyItems      error = errors.New("a bulk request may change at most 1000 tasks")
+	ErrBulkRejected      error = errors.New("no operation was applied because at least one of them failed")
+	ErrNotApplied        error = errors.New("not applied because another operation failed")
+	ErrInvalidQuery      error = errors.New("invalid task query")
+	ErrInvalidScope      error = errors.New("invalid scope")
+	ErrInvalidTransition error = errors.New("the workspace does not allow this state change")
+	ErrBlocked           error = errors.New("the task is blocked by open tasks")
 )
 
+var errSubtaskRecurrence = fmt.Errorf("%w: a subtask can't recur", database.ErrInvalidRecurrence)
+
+const maxBulkItems = 1000
+
+const (
+	defaultPageSize   = 50
+	maxPageSize       = 200
+	defaultSearchSize = 20
+	maxSearchSize     = 100
+)
+
+// Returned when a task can't be started or completed because of the open tasks in Blockers and Hidden more open tasks the user can't see
+type BlockedError struct {
+	Blockers []database.TaskRef
+	Hidden   int
+}
+
+func (e *BlockedError) Error() string {
+	blockers := make([]string, len(e.Blockers), len(e.Blockers)+1)
+	for i, blocker := range e.Blockers {
+		blockers[i] = fmt.Sprintf("%d (%s)", blocker.Id, blocker.Title)
+	}
+	if e.Hidden > 0 {
+		blockers = append(blockers, fmt.Sprintf("%d tasks you can't see", e.Hidden))
+	}
+	return fmt.Sprintf("%v: %s", ErrBlocked, strings.Join(blockers, ", "))
+}
+
+// Returns a BlockedError for the open blockers that are not completed together with the task, or nil if there are none. Blockers the
+// user can't access are only counted
+func blockedError(blockers []database.OpenBlocker, completing map[int64]bool) error {
+	blocked := &BlockedError{Blockers: []database.TaskRef{}}
+	for _, blocker := range blockers {
+		switch {
+		case completing[blocker.Id]:
+		case blocker.Visible:
+			blocked.Blockers = append(blocked.Blockers, blocker.TaskRef)
+		default:
+			blocked.Hidden++
+		}
+	}
+	if len(blocked.Blockers) == 0 && blocked.Hidden == 0 {
+		return nil
+	}
+	return blocked
+}
+
+func (e *BlockedError) Unwrap() error {
+	return ErrBlocked
+}
+
+// The outcome of one operation of a bulk request on one task. Op is the index of the operation in the request
+type BulkResult struct {
+	Op      int
+	Task_id int64
+	Err     error
+}
+
 type taskService struct {
 }
 
@@ -36,20 +139,228 @@ func (t *taskService) AddTask(task database.Task, username string) (database.Tas
 	if !t.checkPermissionCategory(task.Belongs_to, username) {
 		return database.Task{}, ErrForbidden
 	}
+	if task.Order < 1 {
+		task.Order = 1
+	}
+	if task.State == "" {
+		task.State = database.StateTodo
+	}
+	task.NormalizeDue(userTimezone(username))
 
 	task = database.AddTask(task)
+	if created, err := database.GetTaskById(task.Id); err == nil {
+		recordCreate(database.AuditTask, task.Id, username, created)
+	}
 
 	return task, nil
 }
 
+// Overwrites the task. The new state has to be allowed by the transitions of the workspace. Closing an occurrence of a recurring task
+// creates the next one, the recurrence itself is left unchanged
 func (t *taskService) UpdateTask(task database.Task, username string) (database.Task, error) {
 
 	if !t.checkPermissionTask(task.Belongs_to, task.Id, username) {
 		return database.Task{}, ErrForbidden
 	}
-	newTask := database.UpdateTask(task)
+	before, err := database.GetTaskById(task.Id)
+	if err != nil {
+		return database.Task{}, err
+	}
+	if task.State == "" {
+		task.State = before.State
+	}
+	err = checkTransition(task.Id, task.State, username)
+	if err != nil {
+		return database.Task{}, err
+	}
+	task.NormalizeDue(userTimezone(username))
+	task, err = database.UpdateTask(task)
+	if err != nil {
+		return database.Task{}, err
+	}
+	if after, err := database.GetTaskById(task.Id); err == nil {
+		recordChanges(database.AuditTask, task.Id, username, before, after)
+	}
+	if !before.State.Closed() && task.State.Closed() {
+		t.continueSeries(before, username)
+	}
+	return task, nil
+}
+
+// Applies a partial update to a task. Only the fields present in the patch are written, a new belongs_to or order moves the task first.
+// With ScopeSeries title and details are also changed for the series of the task and its other open occurrences. A recurrence always
+// applies to the series: it starts a series, changes its rule or, if empty, ends it
+func (t *taskService) PatchTask(task_id int64, patch database.TaskPatch, username string) (database.Task, error) {
+	err := patch.Validate()
+	if err != nil {
+		return database.Task{}, err
+	}
+	err = authorizeTask(task_id, username, database.RoleEditor)
+	if err != nil {
+		return database.Task{}, err
+	}
+	before, err := database.GetTaskById(task_id)
+	if err != nil {
+		return database.Task{}, err
+	}
+	if patch.Recurrence.Get() != "" && before.Parent_task_id != nil {
+		return database.Task{}, errSubtaskRecurrence
+	}
+	if patch.State.Set {
+		err = checkTransition(task_id, patch.State.Value, username)
+		if err != nil {
+			return database.Task{}, err
+		}
+	}
+	switch patch.Scope {
+	case "", database.ScopeOccurrence:
+	case database.ScopeSeries:
+		if before.Series_id == nil {
+			return database.Task{}, fmt.Errorf("%w: the task is not recurring", ErrInvalidScope)
+		}
+		if patch.Moves() || patch.DueFields() || patch.State.Set || patch.Priority.Set {
+			return database.Task{}, fmt.Errorf("%w: only title, details and recurrence can be changed for a series", ErrInvalidScope)
+		}
+	default:
+		return database.Task{}, fmt.Errorf("%w: %q", ErrInvalidScope, patch.Scope)
+	}
 
-	return newTask, nil
+	if patch.Moves() {
+		task, err := database.GetTaskById(task_id)
+		if err != nil {
+			return database.Task{}, err
+		}
+		if patch.Belongs_to.Set {
+			task.Belongs_to = patch.Belongs_to.Value
+		}
+		if patch.Order.Set {
+			task.Order = patch.Order.Value
+		}
+		task.Version = patch.Version
+		// The move is recorded on its own, the rest of the patch is compared with the moved task
+		before, err = t.RelocateTask(task, username)
+		if err != nil {
+			return database.Task{}, err
+		}
+		// The move already checked and incremented the version
+		patch.Version = 0
+	}
+	if patch.DueFields() {
+		task := before
+		patch.ApplyDue(&task)
+		err = task.ValidateDue()
+		if err != nil {
+			return database.Task{}, err
+		}
+		task.NormalizeDue(userTimezone(username))
+		patch.CompleteDue(task)
+	}
+
+	err = database.PatchTask(task_id, patch)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			return database.Task{}, ErrNotFound
+		}
+		return database.Task{}, err
+	}
+	if patch.Scope == database.ScopeSeries {
+		occurrences, err := database.GetOpenOccurrences(*before.Series_id, task_id)
+		if err != nil {
+			return database.Task{}, err
+		}
+		err = database.PatchSeries(*before.Series_id, patch, task_id)
+		if err != nil {
+			return database.Task{}, err
+		}
+		recordTaskChanges(occurrences, username)
+	}
+	if patch.Recurrence.Set {
+		err = changeRecurrence(before, patch.Recurrence.Get())
+		if err != nil {
+			return database.Task{}, err
+		}
+	}
+	task, err := database.GetTaskById(task_id)
+	if err != nil {
+		return database.Task{}, err
+	}
+	recordChanges(database.AuditTask, task_id, username, before, task)
+	if patch.State.Set && patch.State.Value.Closed() && !before.State.Closed() {
+		t.continueSeries(task, username)
+	}
+	return task, nil
+}
+
+// Starts, changes or, if the recurrence is empty, ends the series of the task
+func changeRecurrence(task database.Task, recurrence string) error {
+	switch {
+	case task.Series_id == nil && recurrence == "":
+		return nil
+	case task.Series_id == nil:
+		return database.StartSeries(task.Id, recurrence)
+	case recurrence == "":
+		return database.EndSeries(*task.Series_id)
+	default:
+		return database.SetSeriesRule(*task.Series_id, recurrence)
+	}
+}
+
+// Returns ErrInvalidTransition if the workspace of the task does not allow changing its current state to the given one and a BlockedError
+// if the task would be started or completed while some of its blockers are open
+func checkTransition(task_id int64, to database.TaskState, username string) error {
+	rules, err := database.GetTaskStateRules([]int64{task_id})
+	if err != nil {
+		return err
+	}
+	rule, ok := rules[task_id]
+	if !ok {
+		return ErrNotFound
+	}
+	if !rule.Transitions.Allows(rule.State, to) {
+		return fmt.Errorf("%w: from %s to %s", ErrInvalidTransition, rule.State, to)
+	}
+	if rule.State == to || (to != database.StateInProgress && to != database.StateDone) {
+		return nil
+	}
+	blockers, err := database.GetOpenBlockers([]int64{task_id}, username)
+	if err != nil {
+		return err
+	}
+	return blockedError(blockers[task_id], nil)
+}
+
+// Creates the next occurrence of the series after its latest occurrence, the given task, was closed. The new task takes the position of
+// the completed one in its category. Completing an older occurrence or the same one again creates nothing. Errors are only logged, as the
+// completion itself is already saved. The new occurrence is recorded as created by the user who closed the previous one
+func (t *taskService) continueSeries(task database.Task, username string) {
+	if task.Series_id == nil {
+		return
+	}
+	series, err := database.GetTaskSeries(*task.Series_id)
+	if err != nil {
+		if !errors.Is(err, database.ErrNoResult) {
+			log.Println(err)
+		}
+		return
+	}
+	if task.Occurrence != series.Occurrences {
+		return
+	}
+	due, ok, err := series.NextDue()
+	if err != nil || !ok {
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	occurrence, err := database.AddOccurrence(series, due, task.Belongs_to, task.Order)
+	if err != nil {
+		if !errors.Is(err, database.ErrNoResult) {
+			log.Println(err)
+		}
+		return
+	}
+	recordCreate(database.AuditTask, occurrence.Id, username, occurrence)
 }
 
 func (t *taskService) DeleteTask(task database.Task, username string) error {
@@ -57,24 +368,60 @@ func (t *taskService) DeleteTask(task database.Task, username string) error {
 	if !t.checkPermissionTask(task.Belongs_to, task.Id, username) {
 		return ErrForbidden
 	}
-	database.DeleteTask(task)
-
+	before, err := database.GetTaskById(task.Id)
+	if err != nil {
+		return err
+	}
+	err = database.DeleteTask(task)
+	if err != nil {
+		return err
+	}
+	recordDelete(database.AuditTask, task.Id, username, before)
 	return nil
 }
 
-// This function just deletes the old task and creates a new one at the right place. It returns the new task and an error
+// Moves the task to the position given by order in the category given by belongs_to. The task keeps its id and its subtasks move along.
+// A subtask only moves among the subtasks of its parent. It returns the moved task and an error
 func (t *taskService) RelocateTask(task database.Task, username string) (database.Task, error) {
 
-	if !t.checkPermissionTask(task.Belongs_to, task.Id, username) {
-		return database.Task{}, ErrForbidden
+	err := authorizeTask(task.Id, username, database.RoleEditor)
+	if err != nil {
+		return database.Task{}, err
+	}
+	err = authorizeCategory(task.Belongs_to, username, database.RoleEditor)
+	if err != nil {
+		return database.Task{}, err
 	}
 
-	database.DeleteTask(task)
-	newTask := database.AddTask(task)
-
-	return newTask, nil
+	before, err := database.GetTaskById(task.Id)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			return database.Task{}, ErrNotFound
+		}
+		return database.Task{}, err
+	}
+	if before.Parent_task_id == nil {
+		err = database.MoveTask(task.Id, task.Belongs_to, task.Order, task.Version)
+	} else if task.Belongs_to != before.Belongs_to {
+		err = database.ErrSubtaskMove
+	} else {
+		err = database.MoveSubtask(task.Id, task.Order, task.Version)
+	}
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			return database.Task{}, ErrNotFound
+		}
+		return database.Task{}, err
+	}
+	after, err := database.GetTaskById(task.Id)
+	if err != nil {
+		return database.Task{}, err
+	}
+	recordChanges(database.AuditTask, task.Id, username, before, after)
+	return after, nil
 }
 
+// Adds the category to the workspace given in the category or to the personal workspace of the user if none is given
 func (t *taskService) AddCategory(category database.Categories, username string) (database.Categories, error) {
 	user, err := database.GetUserByUsername(username)
 	if err != nil {
@@ -82,8 +429,18 @@ func (t *taskService) AddCategory(category database.Categories, username string)
 			return database.Categories{}, ErrForbidden
 		}
 	}
+	category.Workspace_id, err = resolveWorkspace(category.Workspace_id, username, database.RoleEditor)
+	if err != nil {
+		return database.Categories{}, err
+	}
 	category.Belongs_to = user.Id
+	if category.Order < 1 {
+		category.Order = 1
+	}
 	category.Id = database.AddCategory(category)
+	if created, err := database.GetCategoryByID(category.Id); err == nil {
+		recordCreate(database.AuditCategory, category.Id, username, created)
+	}
 	return category, nil
 }
 
@@ -92,15 +449,73 @@ func (t *taskService) UpdateCategory(category database.Categories, username stri
 	if !t.checkPermissionCategory(category.Id, username) {
 		return database.Categories{}, ErrForbidden
 	}
+	before, err := database.GetCategoryByID(category.Id)
+	if err != nil {
+		return database.Categories{}, err
+	}
+	category, err = database.UpdateCategory(category)
+	if err != nil {
+		return database.Categories{}, err
+	}
+	if after, err := database.GetCategoryByID(category.Id); err == nil {
+		recordChanges(database.AuditCategory, category.Id, username, before, after)
+	}
+	return category, nil
+}
+
+// Applies a partial update to a category. A new order moves the category
+func (t *taskService) PatchCategory(category_id int64, patch database.CategoryPatch, username string) (database.Categories, error) {
+	err := patch.Validate()
+	if err != nil {
+		return database.Categories{}, err
+	}
+	err = authorizeCategory(category_id, username, database.RoleEditor)
+	if err != nil {
+		return database.Categories{}, err
+	}
+	before, err := database.GetCategoryByID(category_id)
+	if err != nil {
+		return database.Categories{}, err
+	}
 
-	return database.UpdateCategory(category), nil
+	err = database.PatchCategory(category_id, patch)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			return database.Categories{}, ErrNotFound
+		}
+		return database.Categories{}, err
+	}
+	// The move below is recorded on its own
+	if renamed, err := database.GetCategoryByID(category_id); err == nil {
+		recordChanges(database.AuditCategory, category_id, username, before, renamed)
+	}
+	if patch.Order.Set {
+		// A rename already checked and incremented the version
+		version := patch.Version
+		if patch.Name.Set {
+			version = 0
+		}
+		err = t.RelocateCategory(database.Categories{Id: category_id, Order: patch.Order.Value, Version: version}, username)
+		if err != nil {
+			return database.Categories{}, err
+		}
+	}
+	return database.GetCategoryByID(category_id)
 }
 
 func (t *taskService) DeleteCategory(category database.Categories, username string) error {
 	if !t.checkPermissionCategory(category.Id, username) {
 		return ErrForbidden
 	}
-	database.DeleteCategory(category)
+	before, err := database.GetCategoryByID(category.Id)
+	if err != nil {
+		return err
+	}
+	err = database.DeleteCategory(category)
+	if err != nil {
+		return err
+	}
+	recordDelete(database.AuditCategory, category.Id, username, before)
 	return nil
 }
 
@@ -108,66 +523,819 @@ func (t *taskService) RelocateCategory(category database.Categories, username st
 	if !t.checkPermissionCategory(category.Id, username) {
 		return ErrForbidden
 	}
+	dbCategory, err := database.GetCategoryByID(category.Id)
+	if err != nil {
+		return ErrForbidden
+	}
+
+	err = database.ChangeCategoryOrder(category.Id, category.Order, dbCategory.Workspace_id, category.Version)
+	if err != nil {
+		return err
+	}
+	if after, err := database.GetCategoryByID(category.Id); err == nil {
+		recordChanges(database.AuditCategory, category.Id, username, dbCategory, after)
+	}
+	return nil
+}
+
+// Returns the categories and tasks of a workspace the user is a member of. The workspace id 0 selects the personal workspace of the user,
+// which also lists the categories shared with the user. Subtasks are returned within their parent task
+func (t *taskService) GetAllTasksAndCategories(username string, workspaceId int64) ([]database.Categories, []database.Task, error) {
+	workspaceId, err := resolveWorkspace(workspaceId, username, database.RoleViewer)
+	if err != nil {
+		return nil, nil, err
+	}
+	categories, tasks := database.GetCategoriesByWorkspaceId(workspaceId), database.GetTasksByWorkspaceId(workspaceId)
+
+	// Categories shared with the user through invitation links are shown next to the personal ones
+	personalId, err := database.GetPersonalWorkspaceId(username)
+	if err != nil {
+		return nil, nil, err
+	}
+	if workspaceId == personalId {
+		categories = append(categories, database.GetCategoriesSharedWith(username)...)
+		tasks = append(tasks, database.GetTasksSharedWith(username)...)
+	}
+	tasks, err = nestSubtasks(tasks)
+	if err != nil {
+		return nil, nil, err
+	}
+	return categories, tasks, nil
+}
+
+// Returns the categories of a workspace the user is a member of, including the shared categories for the personal workspace
+func (t *taskService) GetCategories(username string, workspaceId int64) ([]database.Categories, error) {
+	workspaceId, err := resolveWorkspace(workspaceId, username, database.RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+	categories := database.GetCategoriesByWorkspaceId(workspaceId)
+	personalId, err := database.GetPersonalWorkspaceId(username)
+	if err != nil {
+		return nil, err
+	}
+	if workspaceId == personalId {
+		categories = append(categories, database.GetCategoriesSharedWith(username)...)
+	}
+	return categories, nil
+}
+
+func (t *taskService) GetCategory(category_id int64, username string) (database.Categories, error) {
+	err := authorizeCategory(category_id, username, database.RoleViewer)
+	if err != nil {
+		return database.Categories{}, err
+	}
+	return database.GetCategoryByID(category_id)
+}
+
+func (t *taskService) GetCategoryTasks(category_id int64, username string) ([]database.Task, error) {
+	err := authorizeCategory(category_id, username, database.RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := database.GetTasksByCategoryId(category_id)
+	if err != nil {
+		return nil, err
+	}
+	return nestSubtasks(tasks)
+}
+
+func (t *taskService) GetTask(task_id int64, username string) (database.Task, error) {
+	err := authorizeTask(task_id, username, database.RoleViewer)
+	if err != nil {
+		return database.Task{}, err
+	}
+	task, err := database.GetTaskTree(task_id)
+	if errors.Is(err, database.ErrNoResult) {
+		return database.Task{}, ErrNotFound
+	}
+	return task, err
+}
+
+// Applies a list of operations to many tasks with all-or-nothing semantics. The permissions for all tasks and target categories are loaded
+// with two queries before anything is changed. If an item fails, nothing is applied, ErrBulkRejected is returned and the results tell which
+// items failed and why. All other items get ErrNotApplied
+func (t *taskService) BulkTasks(operations []database.BulkOperation, username string) ([]BulkResult, error) {
+	var taskIds, categoryIds []int64
+	for _, operation := range operations {
+		taskIds = append(taskIds, operation.Task_ids...)
+		if operation.Op == database.BulkMove {
+			categoryIds = append(categoryIds, operation.Category_id)
+		}
+	}
+	if len(taskIds) > maxBulkItems {
+		return nil, ErrTooManyItems
+	}
+	taskRoles, err := database.GetRolesByTaskIds(taskIds, username)
+	if err != nil {
+		return nil, err
+	}
+	categoryRoles, err := database.GetRolesByCategoryIds(categoryIds, username)
+	if err != nil {
+		return nil, err
+	}
+	stateRules, err := database.GetTaskStateRules(taskIds)
+	if err != nil {
+		return nil, err
+	}
+
+	// Blockers completed by the same request don't block
+	var completeIds []int64
+	completing := map[int64]bool{}
+	for _, operation := range operations {
+		if operation.Op == database.BulkComplete {
+			completeIds = append(completeIds, operation.Task_ids...)
+			for _, taskId := range operation.Task_ids {
+				completing[taskId] = true
+			}
+		}
+	}
+	blockers, err := database.GetOpenBlockers(completeIds, username)
+	if err != nil {
+		return nil, err
+	}
+
+	timezone := userTimezone(username)
+	var results []BulkResult
+	failed := false
+	for i, operation := range operations {
+		operationErr := validateBulkOperation(operation, categoryRoles)
+		if operationErr == nil && operation.Op == database.BulkSetDue {
+			operations[i] = normalizeBulkDue(operation, timezone)
+		}
+		for _, taskId := range operation.Task_ids {
+			result := BulkResult{Op: i, Task_id: taskId, Err: operationErr}
+			if result.Err == nil {
+				result.Err = roleError(taskRoles, taskId, database.RoleEditor)
+			}
+			if result.Err == nil && operation.Op == database.BulkComplete {
+				rule := stateRules[taskId]
+				if !rule.Transitions.Allows(rule.State, database.StateDone) {
+					result.Err = fmt.Errorf("%w: from %s to %s", ErrInvalidTransition, rule.State, database.StateDone)
+				} else if rule.State != database.StateDone {
+					result.Err = blockedError(blockers[taskId], completing)
+				}
+			}
+			failed = failed || result.Err != nil
+			results = append(results, result)
+		}
+	}
+
+	if !failed {
+		var completed []int64
+		for _, operation := range operations {
+			if operation.Op == database.BulkComplete {
+				completed = append(completed, operation.Task_ids...)
+			}
+		}
+		occurrences, err := database.GetOpenSeriesTasks(completed)
+		if err != nil {
+			return nil, err
+		}
+		befores, err := database.GetTasksByIds(taskIds)
+		if err != nil {
+			return nil, err
+		}
+		err = database.ApplyBulkOperations(operations)
+		if err == nil {
+			recordTaskChanges(befores, username)
+			for _, occurrence := range occurrences {
+				t.continueSeries(occurrence, username)
+			}
+			return results, nil
+		}
+		var bulkErr *database.BulkError
+		if !errors.As(err, &bulkErr) {
+			return nil, err
+		}
+		for i := range results {
+			if results[i].Op == bulkErr.Op && (bulkErr.Task_id == 0 || results[i].Task_id == bulkErr.Task_id) {
+				results[i].Err = bulkErr.Err
+				if errors.Is(bulkErr.Err, database.ErrNoResult) {
+					results[i].Err = ErrNotFound
+				}
+			}
+		}
+	}
+	for i := range results {
+		if results[i].Err == nil {
+			results[i].Err = ErrNotApplied
+		}
+	}
+	return results, ErrBulkRejected
+}
+
+// Returns the requested page size or the default one for 0
+func pageSize(limit int) (int, error) {
+	if limit == 0 {
+		return defaultPageSize, nil
+	}
+	if limit < 1 || limit > maxPageSize {
+		return 0, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidQuery, maxPageSize)
+	}
+	return limit, nil
+}
+
+// Lists a page of the tasks the user can access. The workspace and category filters are checked for access, so that an inaccessible one
+// is reported as ErrNotFound instead of an empty page
+func (t *taskService) ListTasks(query database.TaskQuery) (database.TaskPage, error) {
+	if query.Sort == "" {
+		query.Sort = "order"
+	}
+	if !database.ValidTaskSort(query.Sort) {
+		return database.TaskPage{}, fmt.Errorf("%w: unknown sort %q", ErrInvalidQuery, query.Sort)
+	}
+	limit, err := pageSize(query.Limit)
+	if err != nil {
+		return database.TaskPage{}, err
+	}
+	query.Limit = limit
+	switch query.Label_match {
+	case "":
+		query.Label_match = database.LabelMatchAny
+	case database.LabelMatchAny, database.LabelMatchAll:
+	default:
+		return database.TaskPage{}, fmt.Errorf("%w: labels_match must be %s or %s", ErrInvalidQuery, database.LabelMatchAny, database.LabelMatchAll)
+	}
+	slices.Sort(query.Labels)
+	query.Labels = slices.Compact(query.Labels)
+	if query.Workspace_id != 0 {
+		_, err := resolveWorkspace(query.Workspace_id, query.Username, database.RoleViewer)
+		if err != nil {
+			return database.TaskPage{}, err
+		}
+	}
+	if query.Category_id != 0 {
+		err := authorizeCategory(query.Category_id, query.Username, database.RoleViewer)
+		if err != nil {
+			return database.TaskPage{}, err
+		}
+	}
+	return database.QueryTasks(query)
+}
+
+// Searches the tasks the user can access. A workspace id other than 0 restricts the search to a workspace the user is a member of
+func (t *taskService) SearchTasks(username string, search string, workspaceId int64, limit int) ([]database.TaskSearchResult, error) {
+	if limit == 0 {
+		limit = defaultSearchSize
+	}
+	if limit < 1 || limit > maxSearchSize {
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidQuery, maxSearchSize)
+	}
+	if workspaceId != 0 {
+		_, err := resolveWorkspace(workspaceId, username, database.RoleViewer)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return database.SearchTasks(username, search, workspaceId, limit)
+}
+
+// Adds the task as a subtask of the parent at the position given by its order among the other subtasks. Subtasks belong to the category of
+// their parent and can't recur
+func (t *taskService) AddSubtask(parent_id int64, task database.Task, username string) (database.Task, error) {
+	err := authorizeTask(parent_id, username, database.RoleEditor)
+	if err != nil {
+		return database.Task{}, err
+	}
+	if task.Recurrence != "" {
+		return database.Task{}, errSubtaskRecurrence
+	}
+	if task.Order < 1 {
+		task.Order = 1
+	}
+	if task.State == "" {
+		task.State = database.StateTodo
+	}
+	task.NormalizeDue(userTimezone(username))
+	task, err = database.AddSubtask(parent_id, task)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			return database.Task{}, ErrNotFound
+		}
+		return database.Task{}, err
+	}
+	recordCreate(database.AuditTask, task.Id, username, task)
+	return task, nil
+}
+
+// Adds the item to the checklist of its task at the position given by its order
+func (t *taskService) AddChecklistItem(item database.ChecklistItem, username string) (database.ChecklistItem, error) {
+	err := authorizeTask(item.Task_id, username, database.RoleEditor)
+	if err != nil {
+		return database.ChecklistItem{}, err
+	}
+	if item.Order < 1 {
+		item.Order = 1
+	}
+	item, err = database.AddChecklistItem(item)
+	if errors.Is(err, database.ErrNoResult) {
+		return database.ChecklistItem{}, ErrNotFound
+	}
+	return item, err
+}
+
+// Applies a partial update to a checklist item. A new order moves the item within its checklist
+func (t *taskService) PatchChecklistItem(item_id int64, patch database.ChecklistPatch, username string) (database.ChecklistItem, error) {
+	err := patch.Validate()
+	if err != nil {
+		return database.ChecklistItem{}, err
+	}
+	item, err := authorizeChecklistItem(item_id, username, database.RoleEditor)
+	if err != nil {
+		return database.ChecklistItem{}, err
+	}
+	err = database.PatchChecklistItem(item.Id, patch)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			return database.ChecklistItem{}, ErrNotFound
+		}
+		return database.ChecklistItem{}, err
+	}
+	return database.GetChecklistItem(item.Id)
+}
+
+func (t *taskService) DeleteChecklistItem(item database.ChecklistItem, username string) error {
+	_, err := authorizeChecklistItem(item.Id, username, database.RoleEditor)
+	if err != nil {
+		return err
+	}
+	return database.DeleteChecklistItem(item)
+}
+
+// Returns the item if the user has at least the minimum role on its task, ErrNotFound if it does not exist or the user has no access to it
+func authorizeChecklistItem(item_id int64, username string, minimum string) (database.ChecklistItem, error) {
+	item, err := database.GetChecklistItem(item_id)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			return database.ChecklistItem{}, ErrNotFound
+		}
+		return database.ChecklistItem{}, err
+	}
+	return item, authorizeTask(item.Task_id, username, minimum)
+}
+
+// Returns the tasks the task waits for and the tasks waiting for it. Only tasks the user can see are listed
+func (t *taskService) GetDependencies(task_id int64, username string) (database.TaskDependencies, error) {
+	err := authorizeTask(task_id, username, database.RoleViewer)
+	if err != nil {
+		return database.TaskDependencies{}, err
+	}
+	return database.GetDependencies(task_id, username)
+}
+
+// Makes the task wait for the blocker. The user has to be allowed to change the task and to see the blocker
+func (t *taskService) AddDependency(task_id int64, blocker_id int64, username string) error {
+	err := authorizeTask(task_id, username, database.RoleEditor)
+	if err != nil {
+		return err
+	}
+	err = authorizeTask(blocker_id, username, database.RoleViewer)
+	if err != nil {
+		return err
+	}
+	err = database.AddDependency(task_id, blocker_id)
+	if errors.Is(err, database.ErrNoResult) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (t *taskService) RemoveDependency(task_id int64, blocker_id int64, username string) error {
+	err := authorizeTask(task_id, username, database.RoleEditor)
+	if err != nil {
+		return err
+	}
+	err = database.RemoveDependency(task_id, blocker_id)
+	if errors.Is(err, database.ErrNoResult) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Assigns the task to a user with access to its category. Only users who may change the task can assign it
+func (t *taskService) AddAssignee(task_id int64, user_id int64, username string) error {
+	err := authorizeTask(task_id, username, database.RoleEditor)
+	if err != nil {
+		return err
+	}
+	err = database.AddAssignee(task_id, user_id, username)
+	if errors.Is(err, database.ErrNoResult) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Removes an assignee from the task. Users who may change the task can remove anyone, everyone else only themselves
+func (t *taskService) RemoveAssignee(task_id int64, user_id int64, username string) error {
+	minimum := database.RoleEditor
 	user, err := database.GetUserByUsername(username)
+	if err == nil && user.Id == user_id {
+		minimum = database.RoleViewer
+	}
+	err = authorizeTask(task_id, username, minimum)
+	if err != nil {
+		return err
+	}
+	err = database.RemoveAssignee(task_id, user_id, username)
+	if errors.Is(err, database.ErrNoResult) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Returns a page of the audit log of the task, newest first
+func (t *taskService) GetTaskHistory(task_id int64, cursor string, limit int, username string) (database.AuditPage, error) {
+	limit, err := pageSize(limit)
+	if err != nil {
+		return database.AuditPage{}, err
+	}
+	err = authorizeTask(task_id, username, database.RoleViewer)
+	if err != nil {
+		return database.AuditPage{}, err
+	}
+	return database.GetAuditLog(database.AuditTask, task_id, cursor, limit)
+}
+
+// Returns a page of the audit log of the category, newest first
+func (t *taskService) GetCategoryHistory(category_id int64, cursor string, limit int, username string) (database.AuditPage, error) {
+	limit, err := pageSize(limit)
+	if err != nil {
+		return database.AuditPage{}, err
+	}
+	err = authorizeCategory(category_id, username, database.RoleViewer)
+	if err != nil {
+		return database.AuditPage{}, err
+	}
+	return database.GetAuditLog(database.AuditCategory, category_id, cursor, limit)
+}
+
+// Returns the deleted categories and tasks of a workspace the user is a member of. The workspace id 0 selects the personal workspace
+func (t *taskService) GetTrash(username string, workspaceId int64) (database.Trash, error) {
+	workspaceId, err := resolveWorkspace(workspaceId, username, database.RoleViewer)
+	if err != nil {
+		return database.Trash{}, err
+	}
+	return database.GetTrash(workspaceId)
+}
+
+// Takes the task with the subtasks deleted with it out of the trash. Tasks deleted with their category or parent are restored with those
+func (t *taskService) RestoreTask(task_id int64, username string) (database.Task, error) {
+	err := authorizeDeletedTask(task_id, username, database.RoleEditor)
+	if err != nil {
+		return database.Task{}, err
+	}
+	err = database.RestoreTask(task_id)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			return database.Task{}, ErrNotFound
+		}
+		return database.Task{}, err
+	}
+	task, err := database.GetTaskById(task_id)
+	if err != nil {
+		return database.Task{}, err
+	}
+	record(database.AuditTask, task_id, database.AuditRestore, username, nil, task)
+	return task, nil
+}
+
+// Takes the category with the tasks deleted with it out of the trash
+func (t *taskService) RestoreCategory(category_id int64, username string) (database.Categories, error) {
+	err := authorizeDeletedCategory(category_id, username, database.RoleEditor)
+	if err != nil {
+		return database.Categories{}, err
+	}
+	err = database.RestoreCategory(category_id)
 	if err != nil {
 		if errors.Is(err, database.ErrNoResult) {
-			return ErrForbidden
+			return database.Categories{}, ErrNotFound
 		}
+		return database.Categories{}, err
 	}
+	category, err := database.GetCategoryByID(category_id)
+	if err != nil {
+		return database.Categories{}, err
+	}
+	record(database.AuditCategory, category_id, database.AuditRestore, username, nil, category)
+	return category, nil
+}
 
-	database.ChangeCategoryOrder(category.Id, category.Order, user.Id)
+// Archives the task with its subtasks. If version is not 0 the task is only archived if it did not change in the meantime
+func (t *taskService) ArchiveTask(task_id int64, version int64, username string) (database.Task, error) {
+	return changeTaskArchive(task_id, version, username, database.ArchiveTask, database.AuditArchive)
+}
+
+// Takes the task with its subtasks out of the archive. If version is not 0 the task is only unarchived if it did not change in the meantime
+func (t *taskService) UnarchiveTask(task_id int64, version int64, username string) (database.Task, error) {
+	return changeTaskArchive(task_id, version, username, database.UnarchiveTask, database.AuditUnarchive)
+}
+
+func changeTaskArchive(task_id int64, version int64, username string, change func(int64, int64) error, action string) (database.Task, error) {
+	err := authorizeTask(task_id, username, database.RoleEditor)
+	if err != nil {
+		return database.Task{}, err
+	}
+	before, err := database.GetTaskById(task_id)
+	if err != nil {
+		return database.Task{}, err
+	}
+	err = change(task_id, version)
+	if err != nil {
+		return database.Task{}, err
+	}
+	task, err := database.GetTaskById(task_id)
+	if err != nil {
+		return database.Task{}, err
+	}
+	recordArchive(database.AuditTask, task_id, action, username, before.Archived_at, task.Archived_at)
+	return task, nil
+}
+
+// Archives the category. If version is not 0 the category is only archived if it did not change in the meantime
+func (t *taskService) ArchiveCategory(category_id int64, version int64, username string) (database.Categories, error) {
+	return changeCategoryArchive(category_id, version, username, database.ArchiveCategory, database.AuditArchive)
+}
+
+// Takes the category out of the archive. If version is not 0 the category is only unarchived if it did not change in the meantime
+func (t *taskService) UnarchiveCategory(category_id int64, version int64, username string) (database.Categories, error) {
+	return changeCategoryArchive(category_id, version, username, database.UnarchiveCategory, database.AuditUnarchive)
+}
+
+func changeCategoryArchive(category_id int64, version int64, username string, change func(int64, int64) error, action string) (database.Categories, error) {
+	err := authorizeCategory(category_id, username, database.RoleEditor)
+	if err != nil {
+		return database.Categories{}, err
+	}
+	before, err := database.GetCategoryByID(category_id)
+	if err != nil {
+		return database.Categories{}, err
+	}
+	err = change(category_id, version)
+	if err != nil {
+		return database.Categories{}, err
+	}
+	category, err := database.GetCategoryByID(category_id)
+	if err != nil {
+		return database.Categories{}, err
+	}
+	recordArchive(database.AuditCategory, category_id, action, username, before.Archived_at, category.Archived_at)
+	return category, nil
+}
+
+// Returns a page of the archived tasks of a workspace the user is a member of, most recently archived first. The workspace id 0 selects
+// the personal workspace
+func (t *taskService) GetArchivedTasks(username string, workspaceId int64, cursor string, limit int) (database.TaskPage, error) {
+	limit, err := pageSize(limit)
+	if err != nil {
+		return database.TaskPage{}, err
+	}
+	workspaceId, err = resolveWorkspace(workspaceId, username, database.RoleViewer)
+	if err != nil {
+		return database.TaskPage{}, err
+	}
+	return database.GetArchivedTasks(workspaceId, cursor, limit)
+}
+
+// Returns a page of the archived categories of a workspace the user is a member of like GetArchivedTasks
+func (t *taskService) GetArchivedCategories(username string, workspaceId int64, cursor string, limit int) (database.CategoryPage, error) {
+	limit, err := pageSize(limit)
+	if err != nil {
+		return database.CategoryPage{}, err
+	}
+	workspaceId, err = resolveWorkspace(workspaceId, username, database.RoleViewer)
+	if err != nil {
+		return database.CategoryPage{}, err
+	}
+	return database.GetArchivedCategories(workspaceId, cursor, limit)
+}
+
+// Returns the comment threads of the task
+func (t *taskService) GetComments(task_id int64, username string) ([]database.Comment, error) {
+	err := authorizeTask(task_id, username, database.RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+	return database.GetComments(task_id)
+}
+
+// Adds a comment by the user, who only needs to be able to see the task
+func (t *taskService) AddComment(comment database.Comment, username string) (database.Comment, error) {
+	var err error
+	comment.Body, err = database.ValidateCommentBody(comment.Body)
+	if err != nil {
+		return database.Comment{}, err
+	}
+	err = authorizeTask(comment.Task_id, username, database.RoleViewer)
+	if err != nil {
+		return database.Comment{}, err
+	}
+	user, err := database.GetUserByUsername(username)
+	if err != nil {
+		return database.Comment{}, ErrForbidden
+	}
+	comment.User_id = user.Id
+	comment, err = database.AddComment(comment)
+	if errors.Is(err, database.ErrNoResult) {
+		return database.Comment{}, ErrNotFound
+	}
+	return comment, err
+}
+
+// Replaces the body of a comment. Only its author can edit it
+func (t *taskService) EditComment(comment_id int64, body string, version int64, username string) (database.Comment, error) {
+	body, err := database.ValidateCommentBody(body)
+	if err != nil {
+		return database.Comment{}, err
+	}
+	_, err = authorizeComment(comment_id, username)
+	if err != nil {
+		return database.Comment{}, err
+	}
+	err = database.EditComment(comment_id, body, version)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			return database.Comment{}, ErrNotFound
+		}
+		return database.Comment{}, err
+	}
+	return database.GetComment(comment_id)
+}
+
+// Deletes a comment. Only its author can delete it
+func (t *taskService) DeleteComment(comment database.Comment, username string) error {
+	_, err := authorizeComment(comment.Id, username)
+	if err != nil {
+		return err
+	}
+	err = database.DeleteComment(comment)
+	if errors.Is(err, database.ErrNoResult) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Returns the comment if the user can still see its task and is its author. Deleted comments are reported as ErrNotFound
+func authorizeComment(comment_id int64, username string) (database.Comment, error) {
+	comment, err := database.GetComment(comment_id)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			return database.Comment{}, ErrNotFound
+		}
+		return database.Comment{}, err
+	}
+	if comment.Deleted {
+		return database.Comment{}, ErrNotFound
+	}
+	err = authorizeTask(comment.Task_id, username, database.RoleViewer)
+	if err != nil {
+		return database.Comment{}, err
+	}
+	user, err := database.GetUserByUsername(username)
+	if err != nil || user.Id != comment.User_id {
+		return database.Comment{}, ErrForbidden
+	}
+	return comment, nil
+}
+
+// Moves the subtasks among the tasks into their parents and attaches the checklists and the progress of every task
+func nestSubtasks(tasks []database.Task) ([]database.Task, error) {
+	ids := make([]int64, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.Id
+	}
+	items, err := database.GetChecklistItems(ids)
+	if err != nil {
+		return nil, err
+	}
+	return database.NestSubtasks(tasks, items), nil
+}
+
+// Checks the parameters of an operation and the permission for the target category of a move
+func validateBulkOperation(operation database.BulkOperation, categoryRoles map[int64]string) error {
+	switch operation.Op {
+	case database.BulkComplete, database.BulkDelete:
+		return nil
+	case database.BulkSetDue:
+		task := database.Task{Due: operation.Due, All_day: operation.All_day, Timezone: operation.Timezone}
+		err := task.ValidateDue()
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidOperation, err)
+		}
+		return nil
+	case database.BulkMove:
+		if operation.Category_id < 1 {
+			return fmt.Errorf("%w: move requires a category_id", ErrInvalidOperation)
+		}
+		return roleError(categoryRoles, operation.Category_id, database.RoleEditor)
+	default:
+		return fmt.Errorf("%w: unknown op %q", ErrInvalidOperation, operation.Op)
+	}
+}
+
+// Fills in the time zone of a set_due operation and moves an all-day due date to midnight
+func normalizeBulkDue(operation database.BulkOperation, timezone string) database.BulkOperation {
+	task := database.Task{Due: operation.Due, All_day: operation.All_day, Timezone: operation.Timezone}
+	task.NormalizeDue(timezone)
+	operation.Due, operation.All_day, operation.Timezone = task.Due, task.All_day, task.Timezone
+	return operation
+}
+
+// Returns the time zone of the user, which new due dates are in unless the client sends one. Falls back to UTC
+func userTimezone(username string) string {
+	settings, err := database.GetUserSettings(username)
+	if err != nil {
+		log.Println(err)
+		return "UTC"
+	}
+	return settings.Timezone
+}
+
+// Returns ErrNotFound if the roles don't contain the id and ErrForbidden if the role is lower than the minimum
+func roleError(roles map[int64]string, id int64, minimum string) error {
+	role, ok := roles[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if !hasRole(role, minimum) {
+		return ErrForbidden
+	}
 	return nil
 }
 
-func (t *taskService) GetAllTasksAndCategories(username string) ([]database.Categories, []database.Task) {
-	return database.GetCategoriesByUsername(username), database.GetTasksByUsername(username)
+// Returns ErrNotFound if the category does not exist or the user has no access to it and ErrForbidden if the user's role is lower than the minimum
+func authorizeCategory(category_id int64, username string, minimum string) error {
+	role, err := database.GetRoleByCategoryId(category_id, username)
+	return checkRole(role, err, minimum)
+}
+
+// Returns ErrNotFound if the task does not exist or the user has no access to it and ErrForbidden if the user's role is lower than the minimum
+func authorizeTask(task_id int64, username string, minimum string) error {
+	role, err := database.GetRoleByTaskId(task_id, username)
+	return checkRole(role, err, minimum)
+}
+
+// Returns ErrNotFound if the task is not in the trash or the user has no access to it and ErrForbidden if the user's role is lower than the minimum
+func authorizeDeletedTask(task_id int64, username string, minimum string) error {
+	role, err := database.GetRoleByDeletedTaskId(task_id, username)
+	return checkRole(role, err, minimum)
+}
+
+// Returns ErrNotFound if the category is not in the trash or the user has no access to it and ErrForbidden if the user's role is lower than the minimum
+func authorizeDeletedCategory(category_id int64, username string, minimum string) error {
+	role, err := database.GetRoleByDeletedCategoryId(category_id, username)
+	return checkRole(role, err, minimum)
+}
+
+// Maps the result of a role lookup to ErrNotFound if nothing was found and to ErrForbidden if the role is lower than the minimum
+func checkRole(role string, err error, minimum string) error {
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if !hasRole(role, minimum) {
+		return ErrForbidden
+	}
+	return nil
 }
 
-// These two functions check if the username encoded in the jwt is related to the ids of the entities that are changed to prevent a user from somehow modifying foreign entities
+// These two functions check if the user encoded in the jwt may modify the entities with the given ids to prevent a user from somehow modifying foreign entities.
+// Modifying requires at least the editor role in the workspace owning the category
 func (t *taskService) checkPermissionTask(belongs_to int64, task_id int64, username string) bool {
-	dbUsername, err := database.GetUsernameByCategoryId(belongs_to)
+	if !t.checkPermissionCategory(belongs_to, username) {
+		return false
+	}
+	role, err := database.GetRoleByTaskId(task_id, username)
 	if err != nil {
 		if errors.Is(err, database.ErrNoResult) {
-			log.Printf("A permission to modify an entity was denied: %v (db) != %v (request)\n", dbUsername, username)
+			log.Printf("A permission to modify task %d was denied for %v\n", task_id, username)
 			return false
 		}
 		log.Fatalf("Something went wrong while checking permissions of task manipulation: %v", err)
 		return false
 	}
-	if dbUsername == username {
-		dbUsername, err = database.GetUsernameByTaskId(task_id)
-		if err != nil {
-			if errors.Is(err, database.ErrNoResult) {
-				return false
-			}
-			log.Fatalf("Something went wrong while checking permissions of task manipulation: %v", err)
-			return false
-		}
-		if dbUsername == username {
-			return true
-		} else {
-			log.Printf("A permission to modify an entity was denied: %v (db) != %v (request)\n", dbUsername, username)
-			return false
-		}
-	} else {
+	if !hasRole(role, database.RoleEditor) {
+		log.Printf("A permission to modify task %d was denied for %v (role %v)\n", task_id, username, role)
 		return false
 	}
+	return true
 }
 
 func (t *taskService) checkPermissionCategory(category_id int64, username string) bool {
-	dbUsername, err := database.GetUsernameByCategoryId(category_id)
+	role, err := database.GetRoleByCategoryId(category_id, username)
 	if err != nil {
 		if errors.Is(err, database.ErrNoResult) {
-			log.Printf("A permission to modify an entity was denied: %v (db) != %v (request)\n", dbUsername, username)
+			log.Printf("A permission to modify category %d was denied for %v\n", category_id, username)
 			return false
 		}
 		log.Fatalf("Something went wrong while checking permissions of task manipulation: %v", err)
 		return false
 	}
-	if dbUsername == username {
-		return true
-	} else {
-		log.Printf("A permission to modify an entity was denied: %v (db) != %v (request)\n", dbUsername, username)
+	if !hasRole(role, database.RoleEditor) {
+		log.Printf("A permission to modify category %d was denied for %v (role %v)\n", category_id, username, role)
 		return false
 	}
+	return true
 }
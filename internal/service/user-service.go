@@ -2,7 +2,10 @@ package service
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"net/mail"
+	"slices"
 	"todolist/internal/auth"
 	"todolist/internal/database"
 
@@ -12,6 +15,8 @@ import (
 type UserService interface {
 	RegisterUser(database.User) (string, error)
 	LoginUser(database.User) (string, error)
+	GetSettings(string) (database.UserSettings, error)
+	PatchSettings(string, database.SettingsPatch) (database.UserSettings, error)
 }
 
 type userService struct {
@@ -25,10 +30,63 @@ var (
 	ErrNoSuchUser        error = errors.New("there is no user with this username")
 	ErrWrongPassword     error = errors.New("wrong password")
 	ErrUserAlreadyExists error = errors.New("a user with this username already exists")
+	ErrInvalidSettings   error = errors.New("invalid settings")
 )
 
-// RegisterUser Registers the new user. Returns nil on success or ErrUserAlreadyExists if the user already exists
+// A user can have at most this many reminders per task
+const maxReminderOffsets = 10
+
+// Returns the settings of the user
+func (service *userService) GetSettings(username string) (database.UserSettings, error) {
+	settings, err := database.GetUserSettings(username)
+	if errors.Is(err, database.ErrNoResult) {
+		return database.UserSettings{}, ErrNoSuchUser
+	}
+	return settings, err
+}
+
+// Updates the settings present in the patch and returns all settings. The time zone has to be an IANA name like Europe/Berlin.
+// An empty email removes the address, the reminder offsets are sorted and deduplicated. 0 auto-archive days turn off the auto-archive
+func (service *userService) PatchSettings(username string, patch database.SettingsPatch) (database.UserSettings, error) {
+	if patch.Timezone.Set && !database.ValidTimezone(patch.Timezone.Get()) {
+		return database.UserSettings{}, fmt.Errorf("%w: %s", database.ErrInvalidTimezone, patch.Timezone.Get())
+	}
+	if email := patch.Email.Get(); email != "" {
+		address, err := mail.ParseAddress(email)
+		if err != nil || address.Address != email {
+			return database.UserSettings{}, fmt.Errorf("%w: invalid email address %q", ErrInvalidSettings, email)
+		}
+	}
+	offsets := patch.Reminder_offsets.Get()
+	if len(offsets) > maxReminderOffsets {
+		return database.UserSettings{}, fmt.Errorf("%w: at most %d reminder offsets", ErrInvalidSettings, maxReminderOffsets)
+	}
+	for _, offset := range offsets {
+		if offset < 0 || offset > database.MaxReminderOffset {
+			return database.UserSettings{}, fmt.Errorf("%w: reminder offsets must be between 0 and %d minutes", ErrInvalidSettings, database.MaxReminderOffset)
+		}
+	}
+	if days := patch.Auto_archive_days.Get(); patch.Auto_archive_days.Null || days < 0 || days > database.MaxAutoArchiveDays {
+		return database.UserSettings{}, fmt.Errorf("%w: auto archive days must be between 0 and %d", ErrInvalidSettings, database.MaxAutoArchiveDays)
+	}
+	slices.Sort(offsets)
+	patch.Reminder_offsets.Value = slices.Compact(offsets)
+	err := database.PatchUserSettings(username, patch)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			return database.UserSettings{}, ErrNoSuchUser
+		}
+		return database.UserSettings{}, err
+	}
+	return service.GetSettings(username)
+}
+
+// RegisterUser Registers the new user. Returns nil on success or ErrUserAlreadyExists if the user already exists or the name is the
+// actor of the application's own changes in the audit log
 func (service *userService) RegisterUser(user database.User) (string, error) {
+	if user.Username == database.AuditSystemActor {
+		return "", ErrUserAlreadyExists
+	}
 	err := database.AddUser(user)
 	if err != nil {
 		if errors.Is(err, database.ErrAlreadyExists) {
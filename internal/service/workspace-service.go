@@ -0,0 +1,339 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"todolist/internal/database"
+)
+
+type WorkspaceService interface {
+	GetWorkspaces(string) ([]database.Workspace, error)
+	AddWorkspace(database.Workspace, string) (database.Workspace, error)
+	UpdateWorkspace(database.Workspace, string) (database.Workspace, error)
+	DeleteWorkspace(database.Workspace, string) error
+	GetMembers(int64, string) ([]database.WorkspaceMember, error)
+	UpdateMember(database.WorkspaceMember, string) error
+	RemoveMember(database.WorkspaceMember, string) error
+	InviteUser(database.WorkspaceInvitation, string) (database.WorkspaceInvitation, error)
+	GetInvitations(string) ([]database.WorkspaceInvitation, error)
+	GetWorkspaceInvitations(int64, string) ([]database.WorkspaceInvitation, error)
+	AcceptInvitation(database.WorkspaceInvitation, string) error
+	DeclineInvitation(database.WorkspaceInvitation, string) error
+	GetStateTransitions(int64, string) (database.WorkspaceTransitions, error)
+	SetStateTransitions(database.WorkspaceTransitions, string) (database.WorkspaceTransitions, error)
+}
+
+var (
+	ErrInvalidRole       error = errors.New("the role must be one of owner, editor or viewer")
+	ErrPersonalWorkspace error = errors.New("personal workspaces cannot be shared or deleted")
+	ErrLastOwner         error = errors.New("a workspace must keep at least one owner")
+	ErrAlreadyMember     error = errors.New("this user is already a member or invited")
+	ErrNoSuchInvitation  error = errors.New("there is no such invitation")
+	ErrNotAMember        error = errors.New("this user is not a member of the workspace")
+)
+
+type workspaceService struct {
+}
+
+func NewWorkspaceService() WorkspaceService {
+	return &workspaceService{}
+}
+
+var roleRanks = map[string]int{
+	database.RoleViewer: 1,
+	database.RoleEditor: 2,
+	database.RoleOwner:  3,
+}
+
+// Reports whether a role grants at least the permissions of the minimum role. Unknown roles grant nothing
+func hasRole(role string, minimum string) bool {
+	return roleRanks[role] > 0 && roleRanks[role] >= roleRanks[minimum]
+}
+
+func validRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// Resolves the workspace id 0 to the personal workspace of the user and checks that the user has at least the given role in the workspace
+func resolveWorkspace(workspaceId int64, username string, minimum string) (int64, error) {
+	if workspaceId == 0 {
+		var err error
+		workspaceId, err = database.GetPersonalWorkspaceId(username)
+		if err != nil {
+			if errors.Is(err, database.ErrNoResult) {
+				return 0, ErrForbidden
+			}
+			return 0, err
+		}
+	}
+	role, err := database.GetWorkspaceRole(workspaceId, username)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			log.Printf("A permission to access workspace %d was denied for %v\n", workspaceId, username)
+			return 0, ErrForbidden
+		}
+		return 0, err
+	}
+	if !hasRole(role, minimum) {
+		log.Printf("A permission to modify workspace %d was denied for %v (role %v)\n", workspaceId, username, role)
+		return 0, ErrForbidden
+	}
+	return workspaceId, nil
+}
+
+func (w *workspaceService) GetWorkspaces(username string) ([]database.Workspace, error) {
+	return database.GetWorkspacesByUsername(username)
+}
+
+func (w *workspaceService) AddWorkspace(workspace database.Workspace, username string) (database.Workspace, error) {
+	user, err := database.GetUserByUsername(username)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			return database.Workspace{}, ErrForbidden
+		}
+		return database.Workspace{}, err
+	}
+	return database.AddWorkspace(workspace, user.Id)
+}
+
+func (w *workspaceService) UpdateWorkspace(workspace database.Workspace, username string) (database.Workspace, error) {
+	workspaceId, err := resolveWorkspace(workspace.Id, username, database.RoleOwner)
+	if err != nil {
+		return database.Workspace{}, err
+	}
+	workspace.Id = workspaceId
+	err = database.UpdateWorkspace(workspace)
+	if err != nil {
+		return database.Workspace{}, err
+	}
+	workspace, err = database.GetWorkspaceById(workspace.Id)
+	workspace.Role = database.RoleOwner
+	return workspace, err
+}
+
+func (w *workspaceService) DeleteWorkspace(workspace database.Workspace, username string) error {
+	if workspace.Id == 0 {
+		return ErrPersonalWorkspace
+	}
+	_, err := resolveWorkspace(workspace.Id, username, database.RoleOwner)
+	if err != nil {
+		return err
+	}
+	workspace, err = database.GetWorkspaceById(workspace.Id)
+	if err != nil {
+		return err
+	}
+	if workspace.Personal {
+		return ErrPersonalWorkspace
+	}
+	return database.DeleteWorkspace(workspace.Id)
+}
+
+// Returns the allowed state changes of the tasks in the workspace
+func (w *workspaceService) GetStateTransitions(workspaceId int64, username string) (database.WorkspaceTransitions, error) {
+	workspaceId, err := resolveWorkspace(workspaceId, username, database.RoleViewer)
+	if err != nil {
+		return database.WorkspaceTransitions{}, err
+	}
+	transitions, err := database.GetStateTransitions(workspaceId)
+	return database.WorkspaceTransitions{Workspace_id: workspaceId, Transitions: transitions}, err
+}
+
+// Replaces the allowed state changes of the tasks in the workspace. Only owners may do this
+func (w *workspaceService) SetStateTransitions(transitions database.WorkspaceTransitions, username string) (database.WorkspaceTransitions, error) {
+	workspaceId, err := resolveWorkspace(transitions.Workspace_id, username, database.RoleOwner)
+	if err != nil {
+		return database.WorkspaceTransitions{}, err
+	}
+	err = transitions.Transitions.Validate()
+	if err != nil {
+		return database.WorkspaceTransitions{}, err
+	}
+	err = database.SetStateTransitions(workspaceId, transitions.Transitions)
+	if err != nil {
+		return database.WorkspaceTransitions{}, err
+	}
+	return w.GetStateTransitions(workspaceId, username)
+}
+
+func (w *workspaceService) GetMembers(workspaceId int64, username string) ([]database.WorkspaceMember, error) {
+	workspaceId, err := resolveWorkspace(workspaceId, username, database.RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+	return database.GetWorkspaceMembers(workspaceId)
+}
+
+// Changes the role of a member. Only owners may do this and the last owner cannot be demoted
+func (w *workspaceService) UpdateMember(member database.WorkspaceMember, username string) error {
+	if !validRole(member.Role) {
+		return ErrInvalidRole
+	}
+	workspaceId, err := resolveWorkspace(member.Workspace_id, username, database.RoleOwner)
+	if err != nil {
+		return err
+	}
+	member.Workspace_id = workspaceId
+	if member.Role != database.RoleOwner {
+		err = w.checkNotLastOwner(member)
+		if err != nil {
+			return err
+		}
+	}
+	err = database.UpdateWorkspaceMemberRole(member.Workspace_id, member.User_id, member.Role)
+	if errors.Is(err, database.ErrNoResult) {
+		return ErrNotAMember
+	}
+	return err
+}
+
+// Removes a member from a workspace. Owners may remove anyone, every member may leave on their own
+func (w *workspaceService) RemoveMember(member database.WorkspaceMember, username string) error {
+	user, err := database.GetUserByUsername(username)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			return ErrForbidden
+		}
+		return err
+	}
+	minimum := database.RoleOwner
+	if member.User_id == user.Id {
+		minimum = database.RoleViewer
+	}
+	member.Workspace_id, err = resolveWorkspace(member.Workspace_id, username, minimum)
+	if err != nil {
+		return err
+	}
+	workspace, err := database.GetWorkspaceById(member.Workspace_id)
+	if err != nil {
+		return err
+	}
+	if workspace.Personal {
+		return ErrPersonalWorkspace
+	}
+	err = w.checkNotLastOwner(member)
+	if err != nil {
+		return err
+	}
+	err = database.RemoveWorkspaceMember(member.Workspace_id, member.User_id)
+	if errors.Is(err, database.ErrNoResult) {
+		return ErrNotAMember
+	}
+	return err
+}
+
+// Returns ErrLastOwner if the member is the only owner of the workspace
+func (w *workspaceService) checkNotLastOwner(member database.WorkspaceMember) error {
+	members, err := database.GetWorkspaceMembers(member.Workspace_id)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if m.User_id == member.User_id && m.Role == database.RoleOwner {
+			owners, err := database.CountWorkspaceOwners(member.Workspace_id)
+			if err != nil {
+				return err
+			}
+			if owners <= 1 {
+				return ErrLastOwner
+			}
+		}
+	}
+	return nil
+}
+
+// Invites the user with the username given in the invitation. Only owners of a shared workspace may invite
+func (w *workspaceService) InviteUser(invitation database.WorkspaceInvitation, username string) (database.WorkspaceInvitation, error) {
+	if !validRole(invitation.Role) {
+		return database.WorkspaceInvitation{}, ErrInvalidRole
+	}
+	var err error
+	invitation.Workspace_id, err = resolveWorkspace(invitation.Workspace_id, username, database.RoleOwner)
+	if err != nil {
+		return database.WorkspaceInvitation{}, err
+	}
+	workspace, err := database.GetWorkspaceById(invitation.Workspace_id)
+	if err != nil {
+		return database.WorkspaceInvitation{}, err
+	}
+	if workspace.Personal {
+		return database.WorkspaceInvitation{}, ErrPersonalWorkspace
+	}
+	inviter, err := database.GetUserByUsername(username)
+	if err != nil {
+		return database.WorkspaceInvitation{}, ErrForbidden
+	}
+	invited, err := database.GetUserByUsername(invitation.Username)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			return database.WorkspaceInvitation{}, ErrNoSuchUser
+		}
+		return database.WorkspaceInvitation{}, err
+	}
+	_, err = database.GetWorkspaceRole(invitation.Workspace_id, invited.Username)
+	if err == nil {
+		return database.WorkspaceInvitation{}, ErrAlreadyMember
+	}
+	if !errors.Is(err, database.ErrNoResult) {
+		return database.WorkspaceInvitation{}, err
+	}
+
+	invitationId, err := database.AddWorkspaceInvitation(invitation.Workspace_id, invited.Id, inviter.Id, invitation.Role)
+	if err != nil {
+		if errors.Is(err, database.ErrAlreadyExists) {
+			return database.WorkspaceInvitation{}, ErrAlreadyMember
+		}
+		return database.WorkspaceInvitation{}, err
+	}
+	return database.GetWorkspaceInvitationById(invitationId)
+}
+
+func (w *workspaceService) GetInvitations(username string) ([]database.WorkspaceInvitation, error) {
+	return database.GetInvitationsByUsername(username)
+}
+
+func (w *workspaceService) GetWorkspaceInvitations(workspaceId int64, username string) ([]database.WorkspaceInvitation, error) {
+	workspaceId, err := resolveWorkspace(workspaceId, username, database.RoleOwner)
+	if err != nil {
+		return nil, err
+	}
+	return database.GetInvitationsByWorkspaceId(workspaceId)
+}
+
+func (w *workspaceService) AcceptInvitation(invitation database.WorkspaceInvitation, username string) error {
+	user, err := database.GetUserByUsername(username)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			return ErrForbidden
+		}
+		return err
+	}
+	err = database.AcceptWorkspaceInvitation(invitation.Id, user.Id)
+	if errors.Is(err, database.ErrNoResult) {
+		return ErrNoSuchInvitation
+	}
+	return err
+}
+
+// Declines an invitation. The invited user may decline it and the owners of the workspace may withdraw it
+func (w *workspaceService) DeclineInvitation(invitation database.WorkspaceInvitation, username string) error {
+	invitation, err := database.GetWorkspaceInvitationById(invitation.Id)
+	if err != nil {
+		if errors.Is(err, database.ErrNoResult) {
+			return ErrNoSuchInvitation
+		}
+		return err
+	}
+	if invitation.Username != username {
+		_, err = resolveWorkspace(invitation.Workspace_id, username, database.RoleOwner)
+		if err != nil {
+			return err
+		}
+	}
+	err = database.DeleteWorkspaceInvitation(invitation.Id)
+	if errors.Is(err, database.ErrNoResult) {
+		return ErrNoSuchInvitation
+	}
+	return err
+}
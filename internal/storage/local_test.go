@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalStore(t *testing.T) {
+	store, err := NewLocalStore(filepath.Join(t.TempDir(), "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBlobStore(t, store)
+}
+
+func TestLocalStoreRejectsKeysOutsideOfItsDirectory(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLocalStore(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"../outside", "/etc/passwd", ".", "", "a/../../outside"} {
+		err = store.Put(context.Background(), key, strings.NewReader("x"), 1, "")
+		if err == nil {
+			t.Errorf("Put(%q) succeeded", key)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "outside")); !os.IsNotExist(err) {
+		t.Errorf("a blob was written outside of the store: %v", err)
+	}
+}
+
+func TestLocalStoreKeepsBlobOnShortContent(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	err = store.Put(ctx, "ab/blob", strings.NewReader("complete"), 8, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Put(ctx, "ab/blob", strings.NewReader("short"), 8, "")
+	if err == nil {
+		t.Fatal("Put with fewer bytes than its size succeeded")
+	}
+	content, err := os.ReadFile(filepath.Join(store.dir, "ab", "blob"))
+	if err != nil || string(content) != "complete" {
+		t.Errorf("blob after failed Put = %q, %v, want the previous content", content, err)
+	}
+}
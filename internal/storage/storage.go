@@ -0,0 +1,57 @@
+// Package storage keeps the content of files like task attachments outside of the database, on the local filesystem or in an S3-compatible
+// object store
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var ErrNotFound error = errors.New("blob not found")
+
+// Stores blobs under keys made of lowercase letters, digits and slashes, like the ones NewKey returns
+type BlobStore interface {
+	// Stores size bytes read from content under the key, replacing a blob stored under it before
+	Put(ctx context.Context, key string, content io.Reader, size int64, contentType string) error
+	// Opens the blob for reading. Returns ErrNotFound if there is none under the key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Removes the blob. Removing a blob that does not exist is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// Returns a new random key. The first two characters form a directory, so that a local store doesn't put all blobs into one directory
+func NewKey() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	name := hex.EncodeToString(b)
+	return name[:2] + "/" + name, nil
+}
+
+// Builds the store configured in the environment. BLOB_STORE=s3 selects an S3-compatible store configured by S3_ENDPOINT, S3_REGION,
+// S3_BUCKET, S3_ACCESS_KEY and S3_SECRET_KEY, otherwise blobs are stored in the directory BLOB_DIR (default data/blobs)
+func FromEnv() (BlobStore, error) {
+	switch os.Getenv("BLOB_STORE") {
+	case "", "local":
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			dir = "data/blobs"
+		}
+		return NewLocalStore(dir)
+	case "s3":
+		region := os.Getenv("S3_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		return NewS3Store(os.Getenv("S3_ENDPOINT"), region, os.Getenv("S3_BUCKET"), os.Getenv("S3_ACCESS_KEY"), os.Getenv("S3_SECRET_KEY"))
+	default:
+		return nil, fmt.Errorf("unknown BLOB_STORE %q, use local or s3", os.Getenv("BLOB_STORE"))
+	}
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// Checks the behaviour every BlobStore has to provide
+func testBlobStore(t *testing.T, store BlobStore) {
+	ctx := context.Background()
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Delete(context.Background(), key) })
+
+	get := func(key string) (string, error) {
+		t.Helper()
+		blob, err := store.Get(ctx, key)
+		if err != nil {
+			return "", err
+		}
+		defer blob.Close()
+		content, err := io.ReadAll(blob)
+		return string(content), err
+	}
+
+	_, err = get(key)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get before Put: error = %v, want ErrNotFound", err)
+	}
+
+	for _, content := range []string{"first content", "replaced", ""} {
+		err = store.Put(ctx, key, strings.NewReader(content), int64(len(content)), "text/plain")
+		if err != nil {
+			t.Fatalf("Put(%q): %v", content, err)
+		}
+		got, err := get(key)
+		if err != nil || got != content {
+			t.Fatalf("Get after Put(%q) = %q, %v", content, got, err)
+		}
+	}
+
+	binary := bytes.Repeat([]byte{0, 1, 2, 254, 255}, 1000)
+	err = store.Put(ctx, key, bytes.NewReader(binary), int64(len(binary)), "application/octet-stream")
+	if err != nil {
+		t.Fatalf("Put of binary content: %v", err)
+	}
+	got, err := get(key)
+	if err != nil || got != string(binary) {
+		t.Fatalf("Get of binary content returned %d bytes, %v, want %d bytes", len(got), err, len(binary))
+	}
+
+	err = store.Delete(ctx, key)
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	_, err = get(key)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get after Delete: error = %v, want ErrNotFound", err)
+	}
+	err = store.Delete(ctx, key)
+	if err != nil {
+		t.Fatalf("Delete of a missing blob: %v", err)
+	}
+}
+
+func TestNewKey(t *testing.T) {
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, name, ok := strings.Cut(key, "/")
+	if !ok || len(name) != 32 || !strings.HasPrefix(name, dir) || len(dir) != 2 {
+		t.Errorf("NewKey() = %q, want two characters, a slash and 32 hex digits starting with them", key)
+	}
+	other, _ := NewKey()
+	if other == key {
+		t.Errorf("NewKey() returned %q twice", key)
+	}
+}